@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Все ключи начинаются с этого префикса - так middleware отличает их от JWT
+const apiKeyPrefix = "sk_"
+
+// APIKey - долгоживущий ключ парсера; в базе хранится только SHA-256 от ключа
+type APIKey struct {
+	ID           string     `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	Name         string     `json:"name" gorm:"type:varchar(100);not null;uniqueIndex"`
+	KeyHash      string     `json:"-" gorm:"type:char(64);not null;uniqueIndex"`
+	KeyHint      string     `json:"keyHint" gorm:"type:varchar(20)"`
+	Scopes       string     `json:"scopes" gorm:"type:text;not null"` // через пробел
+	Source       string     `json:"source,omitempty" gorm:"type:varchar(100)"`
+	RequestCount int64      `json:"requestCount" gorm:"not null;default:0"`
+	LastUsedAt   *time.Time `json:"lastUsedAt,omitempty" gorm:"type:timestamptz"`
+	RevokedAt    *time.Time `json:"revokedAt,omitempty" gorm:"type:timestamptz"`
+	CreatedAt    time.Time  `json:"createdAt" gorm:"autoCreateTime"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	Source string   `json:"source"`
+}
+
+type CreateAPIKeyResponse struct {
+	Success bool    `json:"success"`
+	APIKey  *APIKey `json:"apiKey"`
+	Key     string  `json:"key"` // показывается один раз
+}
+
+type ListAPIKeysResponse struct {
+	Success bool     `json:"success"`
+	APIKeys []APIKey `json:"apiKeys"`
+}
+
+// Обработчик создания API-ключа
+func (app *Application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid JSON format")
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		app.respondWithError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if len(req.Scopes) == 0 {
+		app.respondWithError(w, http.StatusBadRequest, "At least one scope is required")
+		return
+	}
+	for _, s := range req.Scopes {
+		if !validScopes[s] {
+			app.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown scope %q", s))
+			return
+		}
+	}
+	if len(req.Source) > 100 {
+		app.respondWithError(w, http.StatusBadRequest, "source must be at most 100 characters")
+		return
+	}
+
+	key, err := generateAPIKey()
+	if err != nil {
+		log.Printf("Error generating API key: %v", err)
+		app.respondWithError(w, http.StatusInternalServerError, "Failed to create API key")
+		return
+	}
+
+	apiKey := APIKey{
+		Name:    req.Name,
+		KeyHash: hashAPIKey(key),
+		KeyHint: key[:len(apiKeyPrefix)+6],
+		Scopes:  strings.Join(req.Scopes, " "),
+		Source:  req.Source,
+	}
+
+	if err := app.db.Create(&apiKey).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			app.respondWithError(w, http.StatusConflict, "API key with this name already exists")
+		} else {
+			log.Printf("Error creating API key: %v", err)
+			app.respondWithError(w, http.StatusInternalServerError, "Failed to create API key")
+		}
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, CreateAPIKeyResponse{
+		Success: true,
+		APIKey:  &apiKey,
+		Key:     key,
+	})
+}
+
+// Обработчик списка API-ключей
+func (app *Application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys := []APIKey{}
+	if err := app.db.Order("created_at DESC").Find(&keys).Error; err != nil {
+		log.Printf("Error listing API keys: %v", err)
+		app.respondWithError(w, http.StatusInternalServerError, "Failed to list API keys")
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, ListAPIKeysResponse{
+		Success: true,
+		APIKeys: keys,
+	})
+}
+
+// Обработчик отзыва API-ключа по id
+func (app *Application) revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		app.respondWithError(w, http.StatusBadRequest, "id parameter is required")
+		return
+	}
+	if !isUUID(id) {
+		app.respondWithError(w, http.StatusBadRequest, "id must be a UUID")
+		return
+	}
+
+	result := app.db.Model(&APIKey{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		log.Printf("Error revoking API key: %v", result.Error)
+		app.respondWithError(w, http.StatusInternalServerError, "Failed to revoke API key")
+		return
+	}
+	if result.RowsAffected == 0 {
+		app.respondWithError(w, http.StatusNotFound, "API key not found")
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "API key revoked",
+	})
+}
+
+// Находит действующий ключ и учитывает запрос в статистике использования
+func (app *Application) authenticateAPIKey(key string) (*Principal, error) {
+	if app.db == nil {
+		return nil, errors.New("API keys are not supported by the storage backend")
+	}
+
+	var apiKey APIKey
+	err := app.db.First(&apiKey, "key_hash = ?", hashAPIKey(key)).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.New("unknown API key")
+		}
+		log.Printf("Error looking up API key: %v", err)
+		return nil, errors.New("failed to verify API key")
+	}
+	if apiKey.RevokedAt != nil {
+		return nil, errors.New("API key revoked")
+	}
+
+	err = app.db.Model(&APIKey{}).Where("id = ?", apiKey.ID).Updates(map[string]interface{}{
+		"last_used_at":  time.Now(),
+		"request_count": gorm.Expr("request_count + 1"),
+	}).Error
+	if err != nil {
+		log.Printf("Error recording API key usage: %v", err)
+	}
+
+	return &Principal{
+		Name:     apiKey.Name,
+		Scopes:   strings.Fields(apiKey.Scopes),
+		Source:   apiKey.Source,
+		APIKeyID: apiKey.ID,
+	}, nil
+}
+
+func generateAPIKey() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Допустимое расхождение часов между воркерами и сервером при проверке exp/nbf
+const jwtLeeway = 30 * time.Second
+
+var (
+	errTokenMalformed = errors.New("malformed token")
+	errTokenSignature = errors.New("invalid token signature")
+	errTokenAlgorithm = errors.New("unsupported token algorithm")
+	errTokenExpired   = errors.New("token expired")
+	errTokenNotYet    = errors.New("token not valid yet")
+	errTokenIssuer    = errors.New("invalid token issuer")
+	errTokenSubject   = errors.New("token has no subject")
+)
+
+// JWTClaims - поля токена, которые проверяет сервер
+type JWTClaims struct {
+	Subject   string `json:"sub"`
+	Issuer    string `json:"iss"`
+	Scope     string `json:"scope,omitempty"` // скоупы через пробел, по умолчанию ingest
+	IssuedAt  int64  `json:"iat,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+}
+
+// Скоупы доступа: ingest - запись данных парсинга, read - GET-эндпоинты с данными, admin - все
+const (
+	ScopeIngest = "ingest"
+	ScopeRead   = "read"
+	ScopeAdmin  = "admin"
+)
+
+var validScopes = map[string]bool{ScopeIngest: true, ScopeRead: true, ScopeAdmin: true}
+
+// Principal - аутентифицированный клиент: воркер с JWT или владелец API-ключа
+type Principal struct {
+	Name     string
+	Scopes   []string
+	Source   string // если задан - клиент может писать только продукты этого источника
+	APIKeyID string
+}
+
+// Проверяет наличие скоупа; admin разрешает все
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey string
+
+const principalKey contextKey = "principal"
+
+// Возвращает аутентифицированного клиента из контекста запроса
+func principalFromContext(r *http.Request) *Principal {
+	principal, _ := r.Context().Value(principalKey).(*Principal)
+	return principal
+}
+
+// Эндпоинты чтения публичные, пока не включен REQUIRE_READ_AUTH;
+// тогда они требуют скоуп read, как и остальные
+func (app *Application) readMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	auth := app.authMiddleware(ScopeRead, next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.RequireReadAuth {
+			next(w, r)
+			return
+		}
+		auth(w, r)
+	}
+}
+
+// Auth middleware: принимает Authorization: Bearer <HS256 JWT> или API-ключ
+// (X-API-Key или Bearer с префиксом ключа) и требует указанный скоуп
+func (app *Application) authMiddleware(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="simple-api"`)
+
+		token := r.Header.Get("X-API-Key")
+		if token == "" {
+			token, _ = strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+		if token == "" {
+			app.respondWithError(w, http.StatusUnauthorized, "Bearer token or API key is required")
+			return
+		}
+
+		var principal *Principal
+		var err error
+		if strings.HasPrefix(token, apiKeyPrefix) {
+			principal, err = app.authenticateAPIKey(token)
+		} else {
+			principal, err = authenticateJWT(token)
+		}
+		if err != nil {
+			app.respondWithError(w, http.StatusUnauthorized, "Invalid credentials: "+err.Error())
+			return
+		}
+
+		w.Header().Del("WWW-Authenticate")
+
+		// Имя клиента попадает в лог запроса
+		if ww, ok := w.(*responseWriterWrapper); ok {
+			ww.principal = principal.Name
+		}
+
+		if !principal.HasScope(scope) {
+			app.respondWithError(w, http.StatusForbidden, "Scope "+scope+" is required")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), principalKey, principal)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+func authenticateJWT(token string) (*Principal, error) {
+	if cfg.JWTSecret == "" {
+		return nil, errors.New("JWT authentication is not configured")
+	}
+
+	claims, err := parseJWT(token, []byte(cfg.JWTSecret), cfg.JWTIssuer, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := strings.Fields(claims.Scope)
+	if len(scopes) == 0 {
+		scopes = []string{ScopeIngest}
+	}
+	return &Principal{Name: claims.Subject, Scopes: scopes}, nil
+}
+
+// Проверяет, что клиент с ограничением по источнику пишет только свои продукты
+func checkSourceRestriction(principal *Principal, pageData *PageData) error {
+	if principal == nil || principal.Source == "" {
+		return nil
+	}
+	for i, p := range pageData.Products {
+		if p.Source != principal.Source {
+			return fmt.Errorf("product %d: source %q is not allowed for this key", i, p.Source)
+		}
+	}
+	return nil
+}
+
+// Подписывает claims алгоритмом HS256
+func signJWT(claims JWTClaims, secret []byte) (string, error) {
+	header, err := json.Marshal(jwtHeader{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + jwtSignature(signingInput, secret), nil
+}
+
+// Проверяет подпись HS256, exp, nbf, iss и наличие sub
+func parseJWT(token string, secret []byte, issuer string, now time.Time) (*JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errTokenMalformed
+	}
+
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, errTokenMalformed
+	}
+	if header.Alg != "HS256" {
+		return nil, errTokenAlgorithm
+	}
+
+	expected := jwtSignature(parts[0]+"."+parts[1], secret)
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return nil, errTokenSignature
+	}
+
+	var claims JWTClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, errTokenMalformed
+	}
+
+	if claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(jwtLeeway)) {
+		return nil, errTokenExpired
+	}
+	if claims.NotBefore != 0 && now.Before(time.Unix(claims.NotBefore, 0).Add(-jwtLeeway)) {
+		return nil, errTokenNotYet
+	}
+	if issuer != "" && claims.Issuer != issuer {
+		return nil, errTokenIssuer
+	}
+	// sub - имя клиента в логах и владелец ключей идемпотентности
+	if strings.TrimSpace(claims.Subject) == "" {
+		return nil, errTokenSubject
+	}
+
+	return &claims, nil
+}
+
+func jwtSignature(signingInput string, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Команда token: выпускает токен для воркера-парсера
+//
+//	simple-api token -sub scraper-ozon -ttl 720h
+//	simple-api token -sub dashboard -scope read -ttl 720h
+//	simple-api token -sub ops -scope admin -ttl 1h
+func runTokenCommand(args []string) error {
+	fs := flag.NewFlagSet("token", flag.ContinueOnError)
+	subject := fs.String("sub", "", "имя воркера (обязательно)")
+	ttl := fs.Duration("ttl", 30*24*time.Hour, "срок действия токена")
+	scope := fs.String("scope", ScopeIngest, "скоупы через пробел: ingest, read, admin")
+
+	config, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if *subject == "" {
+		return usageErrorf("-sub обязателен")
+	}
+	if config.JWTSecret == "" {
+		return usageErrorf("JWT_SECRET не задан")
+	}
+	for _, s := range strings.Fields(*scope) {
+		if !validScopes[s] {
+			return usageErrorf("неизвестный скоуп %q", s)
+		}
+	}
+
+	now := time.Now()
+	token, err := signJWT(JWTClaims{
+		Subject:   *subject,
+		Issuer:    config.JWTIssuer,
+		Scope:     *scope,
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		ExpiresAt: now.Add(*ttl).Unix(),
+	}, []byte(config.JWTSecret))
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stdout, token)
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseJWT(t *testing.T) {
+	secret := []byte("test-secret")
+	now := time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+	valid := JWTClaims{
+		Subject:   "scraper",
+		Issuer:    "simple-api",
+		ExpiresAt: now.Add(time.Hour).Unix(),
+	}
+	sign := func(claims JWTClaims) string {
+		token, err := signJWT(claims, secret)
+		if err != nil {
+			t.Fatalf("signJWT: %v", err)
+		}
+		return token
+	}
+	// Токен с произвольным заголовком, подписанный тем же секретом
+	withHeader := func(header string) string {
+		parts := strings.Split(sign(valid), ".")
+		input := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + parts[1]
+		return input + "." + jwtSignature(input, secret)
+	}
+	claims := func(modify func(c *JWTClaims)) string {
+		c := valid
+		modify(&c)
+		return sign(c)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"valid", sign(valid), nil},
+		{"expired", claims(func(c *JWTClaims) { c.ExpiresAt = now.Add(-time.Minute).Unix() }), errTokenExpired},
+		{"expired within leeway", claims(func(c *JWTClaims) { c.ExpiresAt = now.Add(-jwtLeeway / 2).Unix() }), nil},
+		{"no exp", claims(func(c *JWTClaims) { c.ExpiresAt = 0 }), errTokenExpired},
+		{"not yet valid", claims(func(c *JWTClaims) { c.NotBefore = now.Add(time.Minute).Unix() }), errTokenNotYet},
+		{"nbf within leeway", claims(func(c *JWTClaims) { c.NotBefore = now.Add(jwtLeeway / 2).Unix() }), nil},
+		{"wrong issuer", claims(func(c *JWTClaims) { c.Issuer = "other" }), errTokenIssuer},
+		{"empty sub", claims(func(c *JWTClaims) { c.Subject = "" }), errTokenSubject},
+		{"blank sub", claims(func(c *JWTClaims) { c.Subject = "  " }), errTokenSubject},
+		{"alg none", withHeader(`{"alg":"none","typ":"JWT"}`), errTokenAlgorithm},
+		{"alg none unsigned", strings.Join(strings.Split(withHeader(`{"alg":"none"}`), ".")[:2], ".") + ".", errTokenAlgorithm},
+		{"alg RS256", withHeader(`{"alg":"RS256","typ":"JWT"}`), errTokenAlgorithm},
+		{"tampered payload", func() string {
+			parts := strings.Split(sign(valid), ".")
+			other := strings.Split(claims(func(c *JWTClaims) { c.Scope = ScopeAdmin }), ".")
+			return parts[0] + "." + other[1] + "." + parts[2]
+		}(), errTokenSignature},
+		{"tampered signature", sign(valid) + "A", errTokenSignature},
+		{"other secret", func() string {
+			token, _ := signJWT(valid, []byte("other-secret"))
+			return token
+		}(), errTokenSignature},
+		{"two segments", strings.Join(strings.Split(sign(valid), ".")[:2], "."), errTokenMalformed},
+		{"four segments", sign(valid) + ".x", errTokenMalformed},
+		{"empty", "", errTokenMalformed},
+		{"header not base64", "!!!." + strings.SplitN(sign(valid), ".", 2)[1], errTokenMalformed},
+		{"header not json", withHeader(`alg=HS256`), errTokenMalformed},
+		{"payload not json", func() string {
+			input := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256"}`)) + "." + base64.RawURLEncoding.EncodeToString([]byte("sub=x"))
+			return input + "." + jwtSignature(input, secret)
+		}(), errTokenMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseJWT(tt.token, secret, "simple-api", now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got.Subject != valid.Subject {
+				t.Errorf("subject = %q, want %q", got.Subject, valid.Subject)
+			}
+		})
+	}
+
+	// Без настроенного издателя iss не проверяется
+	if _, err := parseJWT(claims(func(c *JWTClaims) { c.Issuer = "other" }), secret, "", now); err != nil {
+		t.Errorf("empty issuer: %v", err)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultBulkBatchSize = 50
+	maxBulkBatchSize     = 500
+	maxBulkLineSize      = 32 << 20
+	// Время на чтение и сохранение одной пачки и отправку ее результатов: ReadTimeout и
+	// WriteTimeout сервера рассчитаны на один запрос, поэтому дедлайны продлеваются на каждую пачку
+	bulkBatchTimeout = 30 * time.Second
+)
+
+// BulkLineResult - результат обработки одной строки NDJSON
+type BulkLineResult struct {
+	Line   int    `json:"line"`
+	ID     string `json:"id,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// BulkSaveResponse отдается потоком: results - по мере сохранения пачек, итоги - в конце
+type BulkSaveResponse struct {
+	Results []BulkLineResult `json:"results"`
+	bulkSummary
+}
+
+type bulkSummary struct {
+	Success bool   `json:"success"`
+	Total   int    `json:"total"`
+	Created int    `json:"created"`
+	Failed  int    `json:"failed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// bulkItem - разобранная строка, ожидающая сохранения в составе пачки
+type bulkItem struct {
+	result   int // индекс в срезе неотправленных результатов
+	pageData *PageData
+}
+
+// bulkStream пишет BulkSaveResponse по частям, чтобы клиент видел результаты строк
+// сразу после сохранения их пачки, а сервер не держал в памяти ответ на весь файл
+type bulkStream struct {
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	pending []BulkLineResult // результаты, еще не отправленные клиенту
+	summary bulkSummary
+	err     error // первая ошибка записи: дальше клиенту ничего не пишем
+}
+
+func newBulkStream(w http.ResponseWriter) *bulkStream {
+	s := &bulkStream{w: w, rc: http.NewResponseController(w)}
+	// Без этого HTTP/1 сервер дочитывает тело запроса до первой записи ответа
+	if err := s.rc.EnableFullDuplex(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("Error enabling full duplex for bulk response: %v", err)
+	}
+	s.extendDeadlines()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	s.write([]byte(`{"results":[`))
+	return s
+}
+
+// Продлевает дедлайны соединения на следующую пачку. Обертки без Unwrap и httptest
+// возвращают http.ErrNotSupported - тогда остаются таймауты сервера.
+func (s *bulkStream) extendDeadlines() {
+	deadline := time.Now().Add(bulkBatchTimeout)
+	s.rc.SetReadDeadline(deadline)
+	s.rc.SetWriteDeadline(deadline)
+}
+
+func (s *bulkStream) write(b []byte) {
+	if s.err != nil {
+		return
+	}
+	if _, err := s.w.Write(b); err != nil {
+		log.Printf("Error writing bulk response: %v", err)
+		s.err = err
+	}
+}
+
+// Отправляет накопленные результаты. Вызывается, когда пачка пуста: все результаты окончательные.
+func (s *bulkStream) flush() {
+	for _, res := range s.pending {
+		if res.Status == "created" {
+			s.summary.Created++
+		} else {
+			s.summary.Failed++
+		}
+		if s.summary.Total > 0 {
+			s.write([]byte(","))
+		}
+		s.summary.Total++
+
+		raw, err := json.Marshal(res)
+		if err != nil {
+			raw = []byte(fmt.Sprintf(`{"line": %d, "status": "error", "error": "Failed to marshal result"}`, res.Line))
+		}
+		s.write(raw)
+	}
+	s.pending = s.pending[:0]
+
+	if s.err == nil {
+		if err := s.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			log.Printf("Error flushing bulk response: %v", err)
+		}
+	}
+	s.extendDeadlines()
+}
+
+// Отправляет оставшиеся результаты и итоговые поля
+func (s *bulkStream) finish() {
+	s.flush()
+	s.summary.Success = s.summary.Failed == 0 && s.summary.Error == ""
+
+	raw, _ := json.Marshal(s.summary)
+	// raw - объект {"success": ...}: продолжаем им открытый объект ответа
+	s.write([]byte("],"))
+	s.write(raw[1:])
+}
+
+// Обработчик пакетной загрузки: одна PageData на строку в формате NDJSON
+func (app *Application) saveBulkPageDataHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		app.respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "application/x-ndjson") {
+		app.respondWithError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/x-ndjson")
+		return
+	}
+
+	batchSize := app.getQueryInt(r.URL.Query(), "batch_size", defaultBulkBatchSize)
+	if batchSize > maxBulkBatchSize {
+		batchSize = maxBulkBatchSize
+	}
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBulkLineSize)
+
+	principal := principalFromContext(r)
+	stream := newBulkStream(w)
+	var batch []bulkItem
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+
+		stream.pending = append(stream.pending, BulkLineResult{Line: line})
+		resultIdx := len(stream.pending) - 1
+		result := &stream.pending[resultIdx]
+
+		var pageData PageData
+		if err := json.Unmarshal(raw, &pageData); err != nil {
+			result.Status = "error"
+			result.Errors = []FieldError{decodeError(err)}
+			result.Error = summarizeErrors(result.Errors)
+		} else if errs := validatePageData(&pageData); len(errs) > 0 {
+			result.Status = "error"
+			result.Errors = errs
+			result.Error = summarizeErrors(errs)
+		} else if err := checkSourceRestriction(principal, &pageData); err != nil {
+			result.Status = "error"
+			result.Error = err.Error()
+		} else {
+			normalizePageData(&pageData, r.UserAgent())
+			batch = append(batch, bulkItem{result: resultIdx, pageData: &pageData})
+		}
+
+		if len(batch) >= batchSize {
+			if !app.saveBulkBatch(batch, stream.pending) {
+				discardIdempotentResponse(r)
+			}
+			batch = batch[:0]
+			stream.flush()
+		} else if len(batch) == 0 && len(stream.pending) >= batchSize {
+			// Подряд идут только ошибочные строки - их результаты тоже не копим
+			stream.flush()
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Printf("Error reading NDJSON body: %v", err)
+		stream.summary.Error = fmt.Sprintf("stopped reading at line %d: %v", line+1, err)
+	}
+
+	if len(batch) > 0 && !app.saveBulkBatch(batch, stream.pending) {
+		discardIdempotentResponse(r)
+	}
+	stream.finish()
+}
+
+// Сохраняет пачку в одной транзакции; каждая страница пишется в своей точке сохранения,
+// поэтому ошибка в одной странице не откатывает остальные. false - часть страниц не сохранена
+// из-за ошибки базы: ответ с такими строками нельзя повторять по Idempotency-Key.
+func (app *Application) saveBulkBatch(batch []bulkItem, results []BulkLineResult) bool {
+	saved := true
+	err := app.db.Transaction(func(tx *gorm.DB) error {
+		for _, item := range batch {
+			result := &results[item.result]
+			err := tx.Transaction(func(sp *gorm.DB) error {
+				return savePageDataTx(sp, item.pageData)
+			})
+			if err != nil {
+				log.Printf("Error saving page data (line %d): %v", result.Line, err)
+				result.Status = "error"
+				result.Error = "Failed to save page data"
+				saved = false
+				continue
+			}
+			result.ID = item.pageData.ID
+			result.Status = "created"
+		}
+		return nil
+	})
+
+	if err != nil {
+		log.Printf("Error committing bulk batch: %v", err)
+		for _, item := range batch {
+			result := &results[item.result]
+			result.ID = ""
+			result.Status = "error"
+			result.Error = "Failed to commit batch"
+		}
+		return false
+	}
+	return saved
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Размер пачки несопоставленных продуктов, обрабатываемой за один проход
+const canonicalMatchBatchSize = 1000
+
+// CanonicalProduct - один физический товар, объединяющий продукты разных магазинов
+type CanonicalProduct struct {
+	ID             string    `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	Name           string    `json:"name" gorm:"type:varchar(255);not null"`
+	NormalizedName string    `json:"normalizedName" gorm:"type:varchar(255)"`
+	Weight         *float64  `json:"weight,omitempty" gorm:"type:decimal(11,3)"`
+	Unit           string    `json:"unit,omitempty" gorm:"type:varchar(50)"`
+	GTIN           string    `json:"gtin,omitempty" gorm:"type:varchar(14)"`
+	MatchKey       string    `json:"matchKey,omitempty" gorm:"type:text;uniqueIndex:,where:match_key <> ''"` // пусто у созданных вручную
+	MergedIntoID   *string   `json:"mergedIntoId,omitempty" gorm:"type:uuid;index"`
+	CreatedAt      time.Time `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt      time.Time `json:"updatedAt" gorm:"autoUpdateTime"`
+}
+
+// CanonicalOffer - текущее предложение магазина по каноническому товару
+type CanonicalOffer struct {
+	ProductID string    `json:"productId"`
+	Source    string    `json:"source"`
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+	PageURL   string    `json:"pageUrl"`
+	Price     float64   `json:"price"`
+	OldPrice  *float64  `json:"oldPrice,omitempty"`
+	Discount  *float64  `json:"discount,omitempty"`
+	Weight    *float64  `json:"weight,omitempty"`
+	Unit      string    `json:"unit,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type GetCanonicalResponse struct {
+	Success   bool              `json:"success"`
+	Canonical *CanonicalProduct `json:"canonical"`
+	Offers    []CanonicalOffer  `json:"offers"`
+	Sources   int               `json:"sources"`
+	MinPrice  float64           `json:"minPrice"`
+	MaxPrice  float64           `json:"maxPrice"`
+}
+
+type CanonicalMatchResult struct {
+	Matched int `json:"matched"`
+	Created int `json:"created"`
+}
+
+type MergeCanonicalRequest struct {
+	TargetID  string   `json:"targetId"`
+	SourceIDs []string `json:"sourceIds"`
+}
+
+type SplitCanonicalRequest struct {
+	ProductIDs []string `json:"productIds"`
+	Name       string   `json:"name"`
+}
+
+var (
+	// Десятичная запятая: "3,2%" -> "3.2%"
+	decimalCommaRe = regexp.MustCompile(`(\d),(\d)`)
+	// Размер в названии: "930 мл", "1.5л", "2x250g" - в нормализованном имени не учитывается,
+	// т.к. вес сравнивается отдельно
+	sizeTokenRe = regexp.MustCompile(`\d+(?:[.,]\d+)?\s*(?:x|х|\*)?\s*\d*(?:[.,]\d+)?\s*(?:гр|г|кг|мл|л|шт|g|gr|kg|ml|l|pcs)(?:\s|$)`)
+)
+
+// Нормализует название: регистр, ё, пунктуация, размер и порядок слов
+func normalizeProductName(name string) string {
+	s := strings.ToLower(name)
+	s = strings.ReplaceAll(s, "ё", "е")
+	s = decimalCommaRe.ReplaceAllString(s, "$1.$2")
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '%' || r == '.' || r == '*' {
+			return r
+		}
+		return ' '
+	}, s)
+	s = sizeTokenRe.ReplaceAllString(s+" ", " ")
+
+	tokens := strings.Fields(s)
+	seen := make(map[string]bool, len(tokens))
+	unique := tokens[:0]
+	for _, t := range tokens {
+		t = strings.Trim(t, ".")
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		unique = append(unique, t)
+	}
+	sort.Strings(unique)
+	return strings.Join(unique, " ")
+}
+
+// Единицы, в которых размер входит в ключ сопоставления
+var sizeKeyUnits = map[string]struct {
+	suffix string
+	scale  float64
+}{
+	DimensionMass:   {"g", 1000},
+	DimensionVolume: {"ml", 1000},
+	DimensionCount:  {"pcs", 1},
+}
+
+// Приводит вес к базовой единице измерения, чтобы "1 кг" и "1000 г" совпадали
+func normalizeSize(weight *float64, unit string) string {
+	if weight == nil {
+		return ""
+	}
+	def, ok := parseUnit(unit)
+	if !ok {
+		return strconv.FormatFloat(*weight, 'f', -1, 64) + normalizeUnit(unit)
+	}
+	key := sizeKeyUnits[def.Dimension]
+	value := math.Round(*weight*def.Factor*key.scale*1000) / 1000
+	return strconv.FormatFloat(value, 'f', -1, 64) + key.suffix
+}
+
+// Ключ сопоставления: GTIN, затем артикул в пределах магазина, иначе нормализованное название и размер.
+// Название и размер сравниваются точно после нормализации: опечатки и разные формулировки
+// одного товара не сопоставляются, такие товары объединяются вручную через /canonical/merge
+func canonicalMatchKey(p *Product) string {
+	if gtin := strings.TrimSpace(p.GTIN); gtin != "" {
+		return "gtin:" + gtin
+	}
+	if sku := strings.TrimSpace(p.SKU); sku != "" && p.Source != "" {
+		return "sku:" + p.Source + ":" + sku
+	}
+	name := normalizeProductName(p.Name)
+	if name == "" {
+		return ""
+	}
+	return "name:" + name + "|" + normalizeSize(p.Weight, p.Unit)
+}
+
+// Привязывает несопоставленные продукты к каноническим товарам, создавая новые при необходимости
+func (app *Application) matchCanonicalProducts() (CanonicalMatchResult, error) {
+	var result CanonicalMatchResult
+	lastID := ""
+
+	for {
+		var products []Product
+		err := app.db.
+			Where("canonical_product_id IS NULL AND NOT canonical_locked AND CAST(id AS TEXT) > ?", lastID).
+			Order("CAST(id AS TEXT) ASC").
+			Limit(canonicalMatchBatchSize).
+			Find(&products).Error
+		if err != nil {
+			return result, fmt.Errorf("ошибка загрузки продуктов: %w", err)
+		}
+		if len(products) == 0 {
+			return result, nil
+		}
+		lastID = products[len(products)-1].ID
+
+		err = app.db.Transaction(func(tx *gorm.DB) error {
+			created, matched, err := matchCanonicalBatch(tx, products)
+			result.Created += created
+			result.Matched += matched
+			return err
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+}
+
+func matchCanonicalBatch(tx *gorm.DB, products []Product) (created, matched int, err error) {
+	byKey := map[string][]*Product{}
+	keys := make([]string, 0)
+	for i := range products {
+		key := canonicalMatchKey(&products[i])
+		if key == "" {
+			continue
+		}
+		if _, ok := byKey[key]; !ok {
+			keys = append(keys, key)
+		}
+		byKey[key] = append(byKey[key], &products[i])
+	}
+	if len(keys) == 0 {
+		return 0, 0, nil
+	}
+
+	var existing []CanonicalProduct
+	if err := tx.Where("match_key IN ?", keys).Order("created_at ASC").Find(&existing).Error; err != nil {
+		return 0, 0, fmt.Errorf("ошибка поиска канонических товаров: %w", err)
+	}
+	canonicalByKey := make(map[string]string, len(existing))
+	for _, c := range existing {
+		if _, ok := canonicalByKey[c.MatchKey]; ok {
+			continue
+		}
+		// Объединенный вручную товар перенаправляет на итоговый
+		if c.MergedIntoID != nil {
+			canonicalByKey[c.MatchKey] = *c.MergedIntoID
+		} else {
+			canonicalByKey[c.MatchKey] = c.ID
+		}
+	}
+
+	for _, key := range keys {
+		group := byKey[key]
+		canonicalID, ok := canonicalByKey[key]
+		if !ok {
+			var isNew bool
+			canonicalID, isNew, err = createCanonicalForKey(tx, group[0], key)
+			if err != nil {
+				return created, matched, err
+			}
+			if isNew {
+				created++
+			}
+		}
+
+		ids := make([]string, 0, len(group))
+		for _, p := range group {
+			ids = append(ids, p.ID)
+		}
+		err := tx.Model(&Product{}).Where("id IN ?", ids).Update("canonical_product_id", canonicalID).Error
+		if err != nil {
+			return created, matched, fmt.Errorf("ошибка привязки продуктов: %w", err)
+		}
+		matched += len(ids)
+	}
+
+	return created, matched, nil
+}
+
+// Создает канонический товар с ключом key по первому продукту группы. Если параллельное
+// сопоставление уже создало товар с этим ключом, вставка пропускается (уникальный индекс
+// match_key) и возвращается ID существующего товара.
+func createCanonicalForKey(tx *gorm.DB, first *Product, key string) (id string, created bool, err error) {
+	canonical := CanonicalProduct{
+		Name:           first.Name,
+		NormalizedName: normalizeProductName(first.Name),
+		Weight:         first.Weight,
+		Unit:           first.Unit,
+		GTIN:           strings.TrimSpace(first.GTIN),
+		MatchKey:       key,
+	}
+	result := tx.Clauses(clause.OnConflict{
+		Columns:     []clause.Column{{Name: "match_key"}},
+		TargetWhere: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "match_key <> ''"}}},
+		DoNothing:   true,
+	}).Create(&canonical)
+	if result.Error != nil {
+		return "", false, fmt.Errorf("ошибка создания канонического товара: %w", result.Error)
+	}
+	if result.RowsAffected == 1 {
+		return canonical.ID, true, nil
+	}
+
+	var existing CanonicalProduct
+	if err := tx.Where("match_key = ?", key).First(&existing).Error; err != nil {
+		return "", false, fmt.Errorf("ошибка поиска канонического товара: %w", err)
+	}
+	if existing.MergedIntoID != nil {
+		return *existing.MergedIntoID, false, nil
+	}
+	return existing.ID, false, nil
+}
+
+// Периодический запуск сопоставления; interval <= 0 отключает фоновую задачу
+func (app *Application) startCanonicalMatcher(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			result, err := app.matchCanonicalProducts()
+			if err != nil {
+				log.Printf("Error matching canonical products: %v", err)
+				continue
+			}
+			if result.Matched > 0 {
+				log.Printf("Canonical matching: matched %d products, created %d canonical products", result.Matched, result.Created)
+			}
+		}
+	}()
+}
+
+// Обработчик получения канонического товара с ценами всех магазинов
+func (app *Application) getCanonicalHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		app.respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	id := r.PathValue("id")
+	if !isUUID(id) {
+		app.respondWithError(w, http.StatusBadRequest, "id must be a UUID")
+		return
+	}
+
+	var canonical CanonicalProduct
+	err := app.db.First(&canonical, "id = ?", id).Error
+	// Объединенный товар отдаем в виде итогового
+	if err == nil && canonical.MergedIntoID != nil {
+		err = app.db.First(&canonical, "id = ?", *canonical.MergedIntoID).Error
+	}
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			app.respondWithError(w, http.StatusNotFound, "Canonical product not found")
+		} else {
+			log.Printf("Error getting canonical product: %v", err)
+			app.respondWithError(w, http.StatusInternalServerError, "Failed to get canonical product")
+		}
+		return
+	}
+
+	offers := []CanonicalOffer{}
+	err = app.db.Model(&Product{}).
+		Select("id AS product_id, source, name, url, page_url, price, old_price, discount, weight, unit, updated_at").
+		Where("canonical_product_id = ?", canonical.ID).
+		Order("price ASC, source ASC").
+		Scan(&offers).Error
+	if err != nil {
+		log.Printf("Error getting canonical offers: %v", err)
+		app.respondWithError(w, http.StatusInternalServerError, "Failed to get canonical product")
+		return
+	}
+
+	response := GetCanonicalResponse{
+		Success:   true,
+		Canonical: &canonical,
+		Offers:    offers,
+	}
+	sources := map[string]bool{}
+	for i, o := range offers {
+		sources[o.Source] = true
+		if i == 0 || o.Price < response.MinPrice {
+			response.MinPrice = o.Price
+		}
+		if o.Price > response.MaxPrice {
+			response.MaxPrice = o.Price
+		}
+	}
+	response.Sources = len(sources)
+
+	app.respondWithJSON(w, http.StatusOK, response)
+}
+
+// Обработчик ручного запуска сопоставления. Продукт привязывается к товару с тем же ключом
+// canonicalMatchKey; нечеткого сравнения названий нет
+func (app *Application) runCanonicalMatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		app.respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	result, err := app.matchCanonicalProducts()
+	if err != nil {
+		log.Printf("Error matching canonical products: %v", err)
+		app.respondWithError(w, http.StatusInternalServerError, "Failed to match canonical products")
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"result":  result,
+	})
+}
+
+// Обработчик ручного объединения канонических товаров в один
+func (app *Application) mergeCanonicalHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		app.respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req MergeCanonicalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid JSON format")
+		return
+	}
+	if req.TargetID == "" || len(req.SourceIDs) == 0 {
+		app.respondWithError(w, http.StatusBadRequest, "targetId and sourceIds are required")
+		return
+	}
+	// Повторы в списке не должны превращаться в "не найден" при сверке количества
+	req.SourceIDs = uniqueIDs(req.SourceIDs)
+	if !allUUIDs(append([]string{req.TargetID}, req.SourceIDs...)) {
+		app.respondWithError(w, http.StatusBadRequest, "ids must be UUIDs")
+		return
+	}
+	for _, id := range req.SourceIDs {
+		if id == req.TargetID {
+			app.respondWithError(w, http.StatusBadRequest, "sourceIds must not contain targetId")
+			return
+		}
+	}
+
+	var moved int64
+	err := app.db.Transaction(func(tx *gorm.DB) error {
+		var target CanonicalProduct
+		if err := tx.First(&target, "id = ? AND merged_into_id IS NULL", req.TargetID).Error; err != nil {
+			return err
+		}
+
+		var count int64
+		err := tx.Model(&CanonicalProduct{}).Where("id IN ? AND merged_into_id IS NULL", req.SourceIDs).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if int(count) != len(req.SourceIDs) {
+			return gorm.ErrRecordNotFound
+		}
+
+		result := tx.Model(&Product{}).
+			Where("canonical_product_id IN ?", req.SourceIDs).
+			Updates(map[string]interface{}{"canonical_product_id": target.ID, "canonical_locked": true})
+		if result.Error != nil {
+			return result.Error
+		}
+		moved = result.RowsAffected
+
+		// Ранее объединенные в источники товары тоже перенаправляем на итоговый
+		return tx.Model(&CanonicalProduct{}).
+			Where("id IN ? OR merged_into_id IN ?", req.SourceIDs, req.SourceIDs).
+			Update("merged_into_id", target.ID).Error
+	})
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			app.respondWithError(w, http.StatusNotFound, "Canonical product not found")
+		} else {
+			log.Printf("Error merging canonical products: %v", err)
+			app.respondWithError(w, http.StatusInternalServerError, "Failed to merge canonical products")
+		}
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"success":       true,
+		"canonicalId":   req.TargetID,
+		"movedProducts": moved,
+	})
+}
+
+// Обработчик ручного разделения: выбранные продукты выносятся в новый канонический товар
+func (app *Application) splitCanonicalHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		app.respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req SplitCanonicalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid JSON format")
+		return
+	}
+	if len(req.ProductIDs) == 0 {
+		app.respondWithError(w, http.StatusBadRequest, "productIds are required")
+		return
+	}
+	req.ProductIDs = uniqueIDs(req.ProductIDs)
+	if !allUUIDs(req.ProductIDs) {
+		app.respondWithError(w, http.StatusBadRequest, "productIds must be UUIDs")
+		return
+	}
+
+	var canonical CanonicalProduct
+	err := app.db.Transaction(func(tx *gorm.DB) error {
+		var products []Product
+		if err := tx.Where("id IN ?", req.ProductIDs).Order("created_at ASC").Find(&products).Error; err != nil {
+			return err
+		}
+		if len(products) != len(req.ProductIDs) {
+			return gorm.ErrRecordNotFound
+		}
+
+		first := products[0]
+		name := req.Name
+		if name == "" {
+			name = first.Name
+		}
+		canonical = CanonicalProduct{
+			Name:           name,
+			NormalizedName: normalizeProductName(name),
+			Weight:         first.Weight,
+			Unit:           first.Unit,
+			GTIN:           strings.TrimSpace(first.GTIN),
+		}
+		if err := tx.Create(&canonical).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&Product{}).
+			Where("id IN ?", req.ProductIDs).
+			Updates(map[string]interface{}{"canonical_product_id": canonical.ID, "canonical_locked": true}).Error
+	})
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			app.respondWithError(w, http.StatusNotFound, "Product not found")
+		} else {
+			log.Printf("Error splitting canonical product: %v", err)
+			app.respondWithError(w, http.StatusInternalServerError, "Failed to split canonical product")
+		}
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, map[string]interface{}{
+		"success":   true,
+		"canonical": canonical,
+	})
+}
+
+// Убирает повторы, сохраняя порядок
+func uniqueIDs(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	unique := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+func allUUIDs(ids []string) bool {
+	for _, id := range ids {
+		if !isUUID(id) {
+			return false
+		}
+	}
+	return true
+}
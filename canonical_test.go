@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCanonicalMatchKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		product Product
+		want    string
+	}{
+		{"gtin wins", Product{GTIN: " 4600000000017 ", SKU: "A1", Source: "shop", Name: "Молоко"}, "gtin:4600000000017"},
+		{"sku within source", Product{SKU: " A1 ", Source: "shop", Name: "Молоко"}, "sku:shop:A1"},
+		{"sku without source", Product{SKU: "A1", Name: "Молоко 1 л"}, "name:молоко|"},
+		{"name and size", Product{Name: "Молоко Простоквашино 930 мл", Weight: floatPtr(930), Unit: "мл", Source: "shop"}, "name:молоко простоквашино|930ml"},
+		{"size in base unit", Product{Name: "Сахар", Weight: floatPtr(1), Unit: "кг"}, "name:сахар|1000g"},
+		{"empty name", Product{Name: "  "}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := canonicalMatchKey(&tt.product); got != tt.want {
+				t.Errorf("canonicalMatchKey = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateCanonicalForKeyReusesExisting(t *testing.T) {
+	db := openTestSQLite(t)
+	if _, err := migrateUp(db); err != nil {
+		t.Fatalf("migrateUp: %v", err)
+	}
+
+	first := &Product{Name: "Молоко 1 л", SKU: "A1", Source: "shop"}
+	id, created, err := createCanonicalForKey(db, first, "sku:shop:A1")
+	if err != nil || !created || id == "" {
+		t.Fatalf("first create = %q, %v, %v", id, created, err)
+	}
+
+	// Второй сопоставитель не нашел товар при чтении и пытается создать его снова
+	again, created, err := createCanonicalForKey(db, first, "sku:shop:A1")
+	if err != nil || created || again != id {
+		t.Fatalf("second create = %q, %v, %v; want existing %q", again, created, err, id)
+	}
+
+	var count int64
+	db.Model(&CanonicalProduct{}).Where("match_key = ?", "sku:shop:A1").Count(&count)
+	if count != 1 {
+		t.Errorf("canonical products with the key = %d, want 1", count)
+	}
+
+	// Пустой ключ у созданных вручную товаров уникальным быть не обязан, а заполненный - обязан
+	for _, name := range []string{"Ручной 1", "Ручной 2"} {
+		if err := db.Create(&CanonicalProduct{Name: name}).Error; err != nil {
+			t.Errorf("manual canonical product: %v", err)
+		}
+	}
+	if err := db.Create(&CanonicalProduct{Name: "Молоко", MatchKey: "sku:shop:A1"}).Error; err == nil {
+		t.Error("duplicate match_key: want unique violation")
+	}
+}
+
+func TestMergeAndSplitCanonicalWithRepeatedIDs(t *testing.T) {
+	forEachDBStore(t, func(t *testing.T, api *testAPI) {
+		api.token = signTestToken(t, ScopeIngest+" "+ScopeRead+" "+ScopeAdmin)
+		expectStatus(t, api.do(t, http.MethodPost, "/api/v1/page-data", samplePageData(), true), http.StatusCreated)
+
+		var products []Product
+		api.app.db.Order("url ASC").Find(&products)
+		target := CanonicalProduct{Name: "Молоко"}
+		source := CanonicalProduct{Name: "Молоко 930 мл"}
+		for i, c := range []*CanonicalProduct{&target, &source} {
+			if err := api.app.db.Create(c).Error; err != nil {
+				t.Fatalf("create canonical: %v", err)
+			}
+			api.app.db.Model(&products[i]).Update("canonical_product_id", c.ID)
+		}
+
+		// Один и тот же id дважды - не повод отвечать 404
+		w := api.do(t, http.MethodPost, "/api/v1/canonical/merge", MergeCanonicalRequest{
+			TargetID: target.ID, SourceIDs: []string{source.ID, source.ID},
+		}, true)
+		expectStatus(t, w, http.StatusOK)
+		var merged struct {
+			MovedProducts int64 `json:"movedProducts"`
+		}
+		decodeBody(t, w, &merged)
+		if merged.MovedProducts != 1 {
+			t.Errorf("movedProducts = %d, want 1", merged.MovedProducts)
+		}
+
+		w = api.do(t, http.MethodPost, "/api/v1/canonical/split", SplitCanonicalRequest{
+			ProductIDs: []string{products[0].ID, products[0].ID, products[1].ID},
+		}, true)
+		expectStatus(t, w, http.StatusCreated)
+		var split struct {
+			Canonical CanonicalProduct `json:"canonical"`
+		}
+		decodeBody(t, w, &split)
+		var moved int64
+		api.app.db.Model(&Product{}).Where("canonical_product_id = ?", split.Canonical.ID).Count(&moved)
+		if moved != 2 {
+			t.Errorf("products in the split canonical = %d, want 2", moved)
+		}
+
+		expectStatus(t, api.do(t, http.MethodPost, "/api/v1/canonical/merge", MergeCanonicalRequest{
+			TargetID: target.ID, SourceIDs: []string{"42"},
+		}, true), http.StatusBadRequest)
+		expectStatus(t, api.do(t, http.MethodGet, "/api/v1/canonical/42", nil, true), http.StatusBadRequest)
+	})
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Направления изменения цены
+const (
+	ChangeDrop        = "drop"
+	ChangeRise        = "rise"
+	ChangeNew         = "new"
+	ChangeBackInStock = "back-in-stock"
+)
+
+var validChangeDirections = map[string]bool{ChangeDrop: true, ChangeRise: true, ChangeNew: true, ChangeBackInStock: true}
+
+// PriceChange - событие изменения цены продукта, обнаруженное при сохранении снимка
+type PriceChange struct {
+	ID            uint64    `json:"id" gorm:"primaryKey;autoIncrement"`
+	ProductID     string    `json:"productId" gorm:"type:uuid;not null;index"`
+	PageDataID    *string   `json:"pageDataId,omitempty" gorm:"type:uuid;index"` // nil - снимок удален prune
+	Source        string    `json:"source" gorm:"type:varchar(100);index"`
+	PageURL       string    `json:"pageUrl" gorm:"type:text;index"`
+	Name          string    `json:"name" gorm:"type:varchar(255)"`
+	URL           string    `json:"url" gorm:"type:text"`
+	Direction     string    `json:"direction" gorm:"type:varchar(20);not null;index"`
+	PreviousPrice *float64  `json:"previousPrice,omitempty" gorm:"type:decimal(10,2)"`
+	Price         float64   `json:"price" gorm:"type:decimal(10,2);not null"`
+	AbsDelta      *float64  `json:"absDelta,omitempty" gorm:"type:decimal(10,2)"`
+	PctDelta      *float64  `json:"pctDelta,omitempty" gorm:"type:numeric"` // при предыдущей цене 0.01 процент не помещается в decimal(10,2)
+	DetectedAt    time.Time `json:"detectedAt" gorm:"type:timestamptz;not null"`
+}
+
+type GetPriceChangesResponse struct {
+	Success bool          `json:"success"`
+	Changes []PriceChange `json:"changes"`
+	Total   int64         `json:"total"`
+	Page    int           `json:"page"`
+	PerPage int           `json:"perPage"`
+}
+
+// Обработчик ленты изменений цен
+func (app *Application) getPriceChangesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		app.respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	page := app.getQueryInt(query, "page", 1)
+	perPage := app.getQueryInt(query, "per_page", 50)
+
+	since, err := parseTimeParam(query.Get("since"))
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid since parameter")
+		return
+	}
+
+	direction := query.Get("direction")
+	if direction != "" && !validChangeDirections[direction] {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid direction parameter: expected drop, rise, new or back-in-stock")
+		return
+	}
+
+	// Фильтры
+	dbQuery := app.db.Model(&PriceChange{})
+	if source := query.Get("source"); source != "" {
+		dbQuery = dbQuery.Where("source = ?", source)
+	}
+	if pageURL := query.Get("page_url"); pageURL != "" {
+		dbQuery = dbQuery.Where("page_url = ?", pageURL)
+	}
+	if direction != "" {
+		dbQuery = dbQuery.Where("direction = ?", direction)
+	}
+	if minPct := app.getQueryFloat(query, "min_pct", 0); minPct > 0 {
+		dbQuery = dbQuery.Where("ABS(pct_delta) >= ?", minPct)
+	}
+	if since != nil {
+		dbQuery = dbQuery.Where("detected_at >= ?", *since)
+	}
+
+	var total int64
+	if err := dbQuery.Count(&total).Error; err != nil {
+		log.Printf("Error counting price changes: %v", err)
+		app.respondWithError(w, http.StatusInternalServerError, "Failed to get price changes")
+		return
+	}
+
+	changes := []PriceChange{}
+	offset := (page - 1) * perPage
+	err = dbQuery.
+		Order("detected_at DESC, id DESC").
+		Offset(offset).
+		Limit(perPage).
+		Find(&changes).Error
+
+	if err != nil {
+		log.Printf("Error getting price changes: %v", err)
+		app.respondWithError(w, http.StatusInternalServerError, "Failed to get price changes")
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, GetPriceChangesResponse{
+		Success: true,
+		Changes: changes,
+		Total:   total,
+		Page:    page,
+		PerPage: perPage,
+	})
+}
+
+// Возвращает ID последнего сохраненного снимка страницы или nil, если снимков еще не было
+func previousSnapshotID(tx *gorm.DB, pageID string) (*string, error) {
+	var ids []string
+	err := tx.Model(&PageData{}).
+		Where("page_id = ?", pageID).
+		Order("created_at DESC").
+		Limit(1).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения предыдущего снимка: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	return &ids[0], nil
+}
+
+// Сравнивает сохраненный продукт с новым; existing == nil означает новый продукт.
+// Возвращает nil, если цена не изменилась и продукт не пропадал со страницы.
+func detectPriceChange(existing, current *Product, prevSnapshotID *string) *PriceChange {
+	change := &PriceChange{
+		ProductID:  current.ID,
+		Source:     current.Source,
+		PageURL:    current.PageURL,
+		Name:       current.Name,
+		URL:        current.URL,
+		Price:      current.Price,
+		DetectedAt: time.Now(),
+	}
+
+	if existing == nil {
+		change.Direction = ChangeNew
+		return change
+	}
+
+	previous := existing.Price
+	change.PreviousPrice = &previous
+	if previous != current.Price {
+		abs := roundCents(current.Price - previous)
+		change.AbsDelta = &abs
+		if previous != 0 {
+			pct := roundCents(abs / previous * 100)
+			change.PctDelta = &pct
+		}
+	}
+
+	// Продукт был на этой же странице, но отсутствовал в ее предыдущем снимке
+	missing := prevSnapshotID != nil && existing.PageURL == current.PageURL &&
+		(existing.PageDataID == nil || *existing.PageDataID != *prevSnapshotID)
+
+	switch {
+	case missing:
+		change.Direction = ChangeBackInStock
+	case current.Price < previous:
+		change.Direction = ChangeDrop
+	case current.Price > previous:
+		change.Direction = ChangeRise
+	default:
+		return nil
+	}
+
+	return change
+}
+
+func savePriceChanges(tx *gorm.DB, pageData *PageData, changes []PriceChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	for i := range changes {
+		changes[i].PageDataID = &pageData.ID
+	}
+
+	if err := tx.CreateInBatches(changes, 500).Error; err != nil {
+		return fmt.Errorf("ошибка сохранения изменений цен: %w", err)
+	}
+	return nil
+}
+
+func roundCents(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Коды завершения подкоманд
+const (
+	exitOK      = 0
+	exitFailure = 1 // ошибка выполнения: база недоступна, не удалось записать файл и т.п.
+	exitUsage   = 2 // неверные аргументы или конфигурация
+	exitPartial = 3 // команда отработала, но часть записей отклонена или ответы не совпали (replay)
+	exitSchema  = 4 // схема базы отстает от миграций: нужен migrate up
+)
+
+var (
+	// Часть записей не обработана (import); остальные сохранены
+	errPartial = errors.New("часть записей отклонена")
+	// Схема базы отстает от миграций в бинарнике
+	errSchemaBehind = errors.New("схема базы отстает")
+)
+
+// usageError - ошибка в аргументах или конфигурации
+type usageError struct{ err error }
+
+func (e usageError) Error() string { return e.err.Error() }
+func (e usageError) Unwrap() error { return e.err }
+
+func usageErrorf(format string, args ...interface{}) error {
+	return usageError{fmt.Errorf(format, args...)}
+}
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"serve", "запуск HTTP API (по умолчанию)", runServe},
+	{"migrate", "миграции схемы: up, down, status, create", runMigrateCommand},
+	{"import", "загрузка PageData из файлов JSON/NDJSON в базу", runImportCommand},
+	{"export", "выгрузка продуктов или снимков в JSON/NDJSON", runExportCommand},
+	{"prune", "удаление данных старше срока хранения", runPruneCommand},
+	{"replay", "повтор записанных запросов из JSONL и сравнение ответов", runReplayCommand},
+	{"token", "выпуск JWT для воркеров-парсеров", runTokenCommand},
+}
+
+// Разбирает подкоманду и возвращает код завершения.
+// Без подкоманды (или если первый аргумент - флаг) запускается serve.
+func runCLI(args []string) int {
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	if name == "help" {
+		printUsage()
+		return exitOK
+	}
+	for _, cmd := range commands {
+		if cmd.name == name {
+			err := cmd.run(args)
+			if err != nil && !errors.Is(err, flag.ErrHelp) {
+				log.Printf("%s: %v", name, err)
+			}
+			return exitCode(err)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "неизвестная команда %q\n\n", name)
+	printUsage()
+	return exitUsage
+}
+
+func exitCode(err error) int {
+	var usage usageError
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.As(err, &usage):
+		return exitUsage
+	case errors.Is(err, errPartial):
+		return exitPartial
+	case errors.Is(err, errSchemaBehind):
+		return exitSchema
+	}
+	return exitFailure
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "использование: simple-api [команда] [флаги]")
+	fmt.Fprintln(os.Stderr)
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s\t%s\n", cmd.name, cmd.summary)
+	}
+	w.Flush()
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "флаги конфигурации общие для всех команд: simple-api <команда> -h")
+}
+
+// Подключается к базе для подкоманды и проверяет, что схема не отстает от миграций
+func openCommandDatabase(c Config) (*gorm.DB, error) {
+	db, err := openDatabase(c, logger.Silent)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSchema(db); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Хранилище, соответствующее DB_DRIVER
+func newStore(c Config, db *gorm.DB) Store {
+	if c.DBDriver == driverSQLite {
+		return NewSQLiteStore(db)
+	}
+	return NewPostgresStore(db)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodePageDataStream(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		want  int
+	}{
+		{"empty", "  \n", 0},
+		{"object", `{"url": "https://shop.example/a", "products": []}`, 1},
+		{"array", `[{"url": "https://shop.example/a"}, {"url": "https://shop.example/b"}]`, 2},
+		{"ndjson", "{\"url\": \"https://shop.example/a\"}\n\n{\"url\": \"https://shop.example/b\"}\n", 2},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []int
+			err := decodePageDataStream(strings.NewReader(tc.input), func(n int, raw json.RawMessage) error {
+				got = append(got, n)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("decodePageDataStream: %v", err)
+			}
+			if len(got) != tc.want {
+				t.Errorf("records = %v, want %d", got, tc.want)
+			}
+		})
+	}
+
+	if err := decodePageDataStream(strings.NewReader(`{"url": `), func(int, json.RawMessage) error { return nil }); err == nil {
+		t.Error("truncated input: want error")
+	}
+}
+
+func TestCLIImportExport(t *testing.T) {
+	dir := t.TempDir()
+	dbFlags := []string{"-db-driver", "sqlite", "-sqlite-path", filepath.Join(dir, "cli.db")}
+	run := func(args ...string) int {
+		t.Helper()
+		return runCLI(append(args, dbFlags...))
+	}
+
+	// Сервер не стартует на базе без миграций
+	if code := run("serve"); code != exitSchema {
+		t.Fatalf("serve before migrate: exit %d, want %d", code, exitSchema)
+	}
+	if code := run("migrate", "up"); code != exitOK {
+		t.Fatalf("migrate up: exit %d", code)
+	}
+
+	first := samplePageData()
+	second := samplePageData()
+	second.URL = "https://shop.example/kefir"
+	second.Products = second.Products[:1]
+	second.Products[0].URL = "https://shop.example/p/4"
+
+	var input strings.Builder
+	for _, pd := range []PageData{first, second} {
+		line, _ := json.Marshal(pd)
+		input.Write(line)
+		input.WriteByte('\n')
+	}
+	input.WriteString(`{"url": "not a url", "products": []}` + "\n")
+	inputPath := filepath.Join(dir, "pages.ndjson")
+	if err := os.WriteFile(inputPath, []byte(input.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := runCLI(append(append([]string{"import"}, dbFlags...), inputPath)); code != exitPartial {
+		t.Fatalf("import with an invalid record: exit %d, want %d", code, exitPartial)
+	}
+
+	outputPath := filepath.Join(dir, "snapshots.json")
+	if code := run("export", "snapshots", "-format", "json", "-o", outputPath); code != exitOK {
+		t.Fatalf("export snapshots: exit %d", code)
+	}
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshots []PageData
+	if err := json.Unmarshal(data, &snapshots); err != nil {
+		t.Fatalf("export is not a JSON array: %v\n%s", err, data)
+	}
+	if len(snapshots) != 2 || snapshots[0].URL != first.URL || len(snapshots[0].Products) != 3 || len(snapshots[1].Products) != 1 {
+		t.Errorf("exported snapshots = %+v", snapshots)
+	}
+
+	outputPath = filepath.Join(dir, "products.ndjson")
+	if code := run("export", "products", "-source", "shop", "-page-url", first.URL, "-o", outputPath); code != exitOK {
+		t.Fatalf("export products: exit %d", code)
+	}
+	data, _ = os.ReadFile(outputPath)
+	if lines := strings.Count(string(data), "\n"); lines != 3 {
+		t.Errorf("exported %d products, want 3:\n%s", lines, data)
+	}
+
+	for _, args := range [][]string{
+		{"export", "products", "-format", "xml"},
+		{"export", "pages"},
+		{"import"},
+		{"prune"},
+	} {
+		if code := run(args...); code != exitUsage {
+			t.Errorf("%v: exit %d, want %d", args, code, exitUsage)
+		}
+	}
+	if code := runCLI([]string{"frobnicate"}); code != exitUsage {
+		t.Errorf("unknown command: exit %d, want %d", code, exitUsage)
+	}
+}
+
+func TestExportProductsAcrossBatches(t *testing.T) {
+	db := openTestSQLite(t)
+	if _, err := migrateUp(db); err != nil {
+		t.Fatalf("migrateUp: %v", err)
+	}
+
+	// Больше двух пачек, у всех одинаковое время создания: порядок задает id
+	createdAt := time.Now().Add(-time.Hour)
+	products := make([]Product, 2*exportBatchSize+1)
+	for i := range products {
+		products[i] = Product{
+			Name: fmt.Sprintf("Товар %d", i), URL: fmt.Sprintf("https://shop.example/p/%d", i),
+			Price: 10, Source: "shop", CreatedAt: createdAt,
+		}
+	}
+	if err := db.CreateInBatches(products, 200).Error; err != nil {
+		t.Fatalf("create products: %v", err)
+	}
+
+	var out bytes.Buffer
+	w := newExportWriter(&out, false)
+	if err := exportProducts(db, w, exportFilter{}); err != nil {
+		t.Fatalf("exportProducts: %v", err)
+	}
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[string]bool{}
+	prev := ""
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var p Product
+		if err := json.Unmarshal([]byte(line), &p); err != nil {
+			t.Fatalf("decode %q: %v", line, err)
+		}
+		if seen[p.ID] || p.ID <= prev {
+			t.Fatalf("product %s exported out of order or twice", p.ID)
+		}
+		seen[p.ID] = true
+		prev = p.ID
+	}
+	if len(seen) != len(products) || w.count != len(products) {
+		t.Errorf("exported %d products (count %d), want %d", len(seen), w.count, len(products))
+	}
+}
+
+func TestPruneData(t *testing.T) {
+	db := openTestSQLite(t)
+	if _, err := migrateUp(db); err != nil {
+		t.Fatalf("migrateUp: %v", err)
+	}
+	store := NewSQLiteStore(db)
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		pd := samplePageData()
+		pd.Products[0].Price += float64(i)
+		normalizePageData(&pd, "test")
+		if err := store.SavePageData(&pd); err != nil {
+			t.Fatalf("SavePageData: %v", err)
+		}
+		ids = append(ids, pd.ID)
+	}
+	// Все снимки старые; последний остается как текущий снимок страницы. История цен старая
+	// только у первого снимка.
+	old := time.Now().Add(-48 * time.Hour)
+	for i, id := range ids {
+		if err := db.Exec("UPDATE page_data SET created_at = ? WHERE id = ?", old.Add(time.Duration(i)*time.Minute), id).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Exec("UPDATE price_observations SET observed_at = ? WHERE page_data_id = ?", old, ids[0]).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec("UPDATE price_changes SET detected_at = ? WHERE page_data_id = ?", old, ids[0]).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	cutoff := time.Now().Add(-24 * time.Hour)
+	dry, err := pruneData(db, cutoff, cutoff, true)
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if dry.Snapshots != 2 || dry.SnapshotProducts != 6 || dry.Observations != 3 || dry.Changes != 3 {
+		t.Errorf("dry run result = %+v, want 2 snapshots, 6 snapshot products, 3 observations, 3 changes", dry)
+	}
+	var count int64
+	db.Model(&PageData{}).Count(&count)
+	if count != 3 {
+		t.Fatalf("dry run deleted snapshots: %d left", count)
+	}
+
+	// Задан только срок хранения снимков: история остается, но больше не ссылается на них
+	res, err := pruneData(db, cutoff, time.Time{}, false)
+	if err != nil {
+		t.Fatalf("pruneData: %v", err)
+	}
+	if res.Snapshots != 2 || res.Observations != 0 || res.Changes != 0 {
+		t.Errorf("result = %+v, want 2 snapshots and no history", res)
+	}
+
+	var left []string
+	db.Model(&PageData{}).Pluck("id", &left)
+	if len(left) != 1 || left[0] != ids[2] {
+		t.Errorf("snapshots left = %v, want only the latest %s", left, ids[2])
+	}
+	var page Page
+	db.First(&page)
+	if page.SnapshotCount != 1 {
+		t.Errorf("snapshot_count = %d, want 1", page.SnapshotCount)
+	}
+	db.Model(&PriceObservation{}).Count(&count)
+	if count != 9 {
+		t.Errorf("price observations = %d, want 9 kept", count)
+	}
+	db.Model(&PriceObservation{}).Where("page_data_id IS NULL").Count(&count)
+	if count != 6 {
+		t.Errorf("observations without a snapshot = %d, want 6", count)
+	}
+	for _, table := range []string{"price_observations", "price_changes", "products"} {
+		db.Table(table).Where("page_data_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM page_data WHERE page_data.id = " + table + ".page_data_id)").Count(&count)
+		if count != 0 {
+			t.Errorf("%s: %d rows reference deleted snapshots", table, count)
+		}
+	}
+
+	// История без снимка удаляется по своему сроку
+	res, err = pruneData(db, time.Time{}, cutoff, false)
+	if err != nil {
+		t.Fatalf("pruneData history: %v", err)
+	}
+	if res.Snapshots != 0 || res.Observations != 3 || res.Changes != 3 {
+		t.Errorf("history result = %+v, want 3 observations and 3 changes", res)
+	}
+}
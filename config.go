@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	envDevelopment = "development"
+	envStaging     = "staging"
+	envProduction  = "production"
+	envTest        = "test"
+
+	// Значение JWT_SECRET из примера .env - в продакшене его использовать нельзя
+	placeholderJWTSecret = "your-secret-key-change-in-production"
+
+	driverPostgres = "postgres"
+	driverSQLite   = "sqlite"
+)
+
+// Значения по умолчанию для локальной разработки
+var defaultConfig = Config{
+	DBDriver:   driverPostgres,
+	SQLitePath: "simple-api.db",
+
+	Host:      "localhost",
+	Port:      5432,
+	User:      "postgres",
+	DBName:    "simple_api",
+	SSLMode:   "disable",
+	APIPort:   8080,
+	Env:       envDevelopment,
+	JWTIssuer: "simple-api",
+
+	CanonicalMatchInterval: 15 * time.Minute,
+	IdempotencyTTL:         24 * time.Hour,
+	IdempotencyLease:       5 * time.Minute,
+
+	JobWorkers:      4,
+	JobMaxAttempts:  5,
+	JobRetryBackoff: 5 * time.Second,
+	JobPollInterval: time.Second,
+}
+
+var validSSLModes = map[string]bool{
+	"disable": true, "allow": true, "prefer": true, "require": true, "verify-ca": true, "verify-full": true,
+}
+
+// Загружает конфигурацию. Приоритет: флаги > переменные окружения > .env > значения по умолчанию.
+// Флаги регистрируются в переданном FlagSet, чтобы подкоманды могли добавить свои.
+func loadConfig(fs *flag.FlagSet, args []string) (Config, error) {
+	envFile := fs.String("env-file", "", "путь к .env файлу (по умолчанию .env, если существует)")
+	dbDriver := fs.String("db-driver", "", "хранилище: postgres или sqlite (DB_DRIVER)")
+	sqlitePath := fs.String("sqlite-path", "", "файл базы SQLite (SQLITE_PATH)")
+	dbHost := fs.String("db-host", "", "хост базы данных (DB_HOST)")
+	dbPort := fs.Int("db-port", 0, "порт базы данных (DB_PORT)")
+	dbUser := fs.String("db-user", "", "пользователь базы данных (DB_USER)")
+	dbName := fs.String("db-name", "", "имя базы данных (DB_NAME)")
+	dbSSLMode := fs.String("db-ssl-mode", "", "режим SSL (DB_SSL_MODE)")
+	apiPort := fs.Int("port", 0, "порт HTTP сервера (APP_PORT)")
+	appEnv := fs.String("env", "", "окружение: development, staging, production, test (APP_ENV)")
+
+	if err := fs.Parse(args); err != nil {
+		return Config{}, usageError{err}
+	}
+
+	// .env не переопределяет уже заданные переменные окружения
+	path := *envFile
+	if path == "" {
+		path = os.Getenv("ENV_FILE")
+	}
+	explicit := path != ""
+	if !explicit {
+		path = ".env"
+	}
+	dotenv, err := readDotEnv(path)
+	if err != nil && (explicit || !errors.Is(err, os.ErrNotExist)) {
+		return Config{}, usageErrorf("не удалось прочитать %s: %w", path, err)
+	}
+
+	lookup := func(key string) (string, bool) {
+		if value, ok := os.LookupEnv(key); ok {
+			return value, true
+		}
+		value, ok := dotenv[key]
+		return value, ok
+	}
+
+	c := defaultConfig
+	// Ключи, заданные явно (а не взятые по умолчанию) - нужны для проверки в продакшене
+	provided := map[string]bool{}
+	var errs []string
+
+	setString := func(key string, dst *string) {
+		if value, ok := lookup(key); ok && value != "" {
+			*dst = value
+			provided[key] = true
+		}
+	}
+	setInt := func(key string, dst *int) {
+		value, ok := lookup(key)
+		if !ok || value == "" {
+			return
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: ожидается число, получено %q", key, value))
+			return
+		}
+		*dst = n
+		provided[key] = true
+	}
+	setBool := func(key string, dst *bool) {
+		value, ok := lookup(key)
+		if !ok || value == "" {
+			return
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: ожидается true или false, получено %q", key, value))
+			return
+		}
+		*dst = b
+		provided[key] = true
+	}
+	setDuration := func(key string, dst *time.Duration) {
+		value, ok := lookup(key)
+		if !ok || value == "" {
+			return
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: ожидается длительность (например 15m), получено %q", key, value))
+			return
+		}
+		*dst = d
+		provided[key] = true
+	}
+
+	setString("DB_DRIVER", &c.DBDriver)
+	setString("SQLITE_PATH", &c.SQLitePath)
+	setString("DB_HOST", &c.Host)
+	setInt("DB_PORT", &c.Port)
+	setString("DB_USER", &c.User)
+	setString("DB_PASSWORD", &c.Password)
+	setString("DB_NAME", &c.DBName)
+	setString("DB_SSL_MODE", &c.SSLMode)
+	setInt("APP_PORT", &c.APIPort)
+	setString("APP_ENV", &c.Env)
+	setString("JWT_SECRET", &c.JWTSecret)
+	setString("JWT_ISSUER", &c.JWTIssuer)
+	setBool("REQUIRE_READ_AUTH", &c.RequireReadAuth)
+	setDuration("CANONICAL_MATCH_INTERVAL", &c.CanonicalMatchInterval)
+	setDuration("IDEMPOTENCY_TTL", &c.IdempotencyTTL)
+	setDuration("IDEMPOTENCY_LEASE", &c.IdempotencyLease)
+	setInt("JOB_WORKERS", &c.JobWorkers)
+	setInt("JOB_MAX_ATTEMPTS", &c.JobMaxAttempts)
+	setDuration("JOB_RETRY_BACKOFF", &c.JobRetryBackoff)
+	setDuration("JOB_POLL_INTERVAL", &c.JobPollInterval)
+	setDuration("SNAPSHOT_RETENTION", &c.SnapshotRetention)
+	setDuration("PRICE_HISTORY_RETENTION", &c.PriceHistoryRetention)
+
+	// Флаги переопределяют все остальное
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "db-driver":
+			c.DBDriver, provided["DB_DRIVER"] = *dbDriver, true
+		case "sqlite-path":
+			c.SQLitePath, provided["SQLITE_PATH"] = *sqlitePath, true
+		case "db-host":
+			c.Host, provided["DB_HOST"] = *dbHost, true
+		case "db-port":
+			c.Port, provided["DB_PORT"] = *dbPort, true
+		case "db-user":
+			c.User, provided["DB_USER"] = *dbUser, true
+		case "db-name":
+			c.DBName, provided["DB_NAME"] = *dbName, true
+		case "db-ssl-mode":
+			c.SSLMode, provided["DB_SSL_MODE"] = *dbSSLMode, true
+		case "port":
+			c.APIPort, provided["APP_PORT"] = *apiPort, true
+		case "env":
+			c.Env, provided["APP_ENV"] = *appEnv, true
+		}
+	})
+
+	errs = append(errs, c.validate(provided)...)
+	if len(errs) > 0 {
+		return Config{}, usageError{errors.New(strings.Join(errs, "; "))}
+	}
+	return c, nil
+}
+
+// Проверяет значения; в продакшене дополнительно требует явно заданные обязательные параметры
+func (c Config) validate(provided map[string]bool) []string {
+	var errs []string
+
+	switch c.DBDriver {
+	case driverPostgres:
+	case driverSQLite:
+		if c.SQLitePath == "" {
+			errs = append(errs, "SQLITE_PATH обязателен при DB_DRIVER=sqlite")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("DB_DRIVER: недопустимое значение %q (postgres или sqlite)", c.DBDriver))
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		errs = append(errs, fmt.Sprintf("DB_PORT: недопустимый порт %d", c.Port))
+	}
+	if c.APIPort < 1 || c.APIPort > 65535 {
+		errs = append(errs, fmt.Sprintf("APP_PORT: недопустимый порт %d", c.APIPort))
+	}
+	if c.CanonicalMatchInterval < 0 {
+		errs = append(errs, "CANONICAL_MATCH_INTERVAL: длительность не может быть отрицательной")
+	}
+	if c.IdempotencyTTL <= 0 {
+		errs = append(errs, "IDEMPOTENCY_TTL: длительность должна быть положительной")
+	}
+	if c.IdempotencyLease <= 0 {
+		errs = append(errs, "IDEMPOTENCY_LEASE: длительность должна быть положительной")
+	}
+	if c.JobWorkers < 0 {
+		errs = append(errs, fmt.Sprintf("JOB_WORKERS: недопустимое значение %d", c.JobWorkers))
+	}
+	if c.JobMaxAttempts < 1 {
+		errs = append(errs, fmt.Sprintf("JOB_MAX_ATTEMPTS: должно быть не меньше 1, получено %d", c.JobMaxAttempts))
+	}
+	if c.JobRetryBackoff <= 0 || c.JobPollInterval <= 0 {
+		errs = append(errs, "JOB_RETRY_BACKOFF и JOB_POLL_INTERVAL должны быть положительными")
+	}
+	if c.SnapshotRetention < 0 || c.PriceHistoryRetention < 0 {
+		errs = append(errs, "SNAPSHOT_RETENTION и PRICE_HISTORY_RETENTION не могут быть отрицательными")
+	}
+	if !validSSLModes[c.SSLMode] {
+		errs = append(errs, fmt.Sprintf("DB_SSL_MODE: недопустимое значение %q", c.SSLMode))
+	}
+	switch c.Env {
+	case envDevelopment, envStaging, envProduction, envTest:
+	default:
+		errs = append(errs, fmt.Sprintf("APP_ENV: недопустимое значение %q", c.Env))
+	}
+
+	if c.Env == envProduction {
+		required := []string{"JWT_SECRET"}
+		if c.DBDriver == driverPostgres {
+			required = append(required, "DB_HOST", "DB_USER", "DB_PASSWORD", "DB_NAME")
+		}
+		for _, key := range required {
+			if !provided[key] {
+				errs = append(errs, fmt.Sprintf("%s обязателен при APP_ENV=production", key))
+			}
+		}
+		if c.JWTSecret == placeholderJWTSecret {
+			errs = append(errs, "JWT_SECRET: используется значение из примера .env")
+		}
+	}
+
+	return errs
+}
+
+// Представление для логов: секреты скрыты
+func (c Config) String() string {
+	return fmt.Sprintf(
+		"env=%s db=%s apiPort=%d jwtIssuer=%s jwtSecret=%s requireReadAuth=%t canonicalMatchInterval=%s idempotencyTTL=%s jobs=%d",
+		c.Env, c.database(), c.APIPort, c.JWTIssuer, redact(c.JWTSecret), c.RequireReadAuth,
+		c.CanonicalMatchInterval, c.IdempotencyTTL, c.JobWorkers,
+	)
+}
+
+// Описание подключения к базе без пароля
+func (c Config) database() string {
+	if c.DBDriver == driverSQLite {
+		return "sqlite:" + c.SQLitePath
+	}
+	return fmt.Sprintf("%s@%s:%d/%s sslmode=%s password=%s", c.User, c.Host, c.Port, c.DBName, c.SSLMode, redact(c.Password))
+}
+
+// Параметры пула воркеров асинхронного сохранения
+func (c Config) jobWorkerConfig() jobWorkerConfig {
+	return jobWorkerConfig{
+		Workers:      c.JobWorkers,
+		MaxAttempts:  c.JobMaxAttempts,
+		RetryBackoff: c.JobRetryBackoff,
+		PollInterval: c.JobPollInterval,
+	}
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return "(empty)"
+	}
+	return "***"
+}
+
+// Разбирает .env: KEY=VALUE, комментарии с #, необязательный префикс export и кавычки
+func readDotEnv(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("строка %d: ожидается KEY=VALUE", lineNo)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		} else if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Ключи, которые читает loadConfig: тест не должен зависеть от окружения, в котором запущен
+var configEnvKeys = []string{
+	"ENV_FILE", "DB_DRIVER", "SQLITE_PATH", "DB_HOST", "DB_PORT", "DB_USER", "DB_PASSWORD", "DB_NAME", "DB_SSL_MODE",
+	"APP_PORT", "APP_ENV", "JWT_SECRET", "JWT_ISSUER", "REQUIRE_READ_AUTH", "CANONICAL_MATCH_INTERVAL",
+	"IDEMPOTENCY_TTL", "IDEMPOTENCY_LEASE", "JOB_WORKERS", "JOB_MAX_ATTEMPTS", "JOB_RETRY_BACKOFF",
+	"JOB_POLL_INTERVAL", "SNAPSHOT_RETENTION", "PRICE_HISTORY_RETENTION",
+}
+
+func clearConfigEnv(t *testing.T) {
+	t.Helper()
+	for _, key := range configEnvKeys {
+		// Setenv запоминает старое значение и восстановит его после теста
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+}
+
+func writeDotEnv(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func loadTestConfig(t *testing.T, args ...string) (Config, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return loadConfig(fs, args)
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	clearConfigEnv(t)
+	envFile := writeDotEnv(t, strings.Join([]string{
+		"DB_HOST=dotenv-host",
+		"DB_USER=dotenv-user",
+		"DB_NAME=dotenv-name",
+		"APP_PORT=9000",
+		"IDEMPOTENCY_TTL=1h",
+		"REQUIRE_READ_AUTH=true",
+	}, "\n"))
+	t.Setenv("DB_HOST", "env-host")
+	t.Setenv("DB_USER", "env-user")
+	t.Setenv("APP_PORT", "9100")
+
+	c, err := loadTestConfig(t, "-env-file", envFile, "-db-host", "flag-host")
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+
+	// DB_HOST из флага, DB_USER и APP_PORT из окружения, DB_NAME, IDEMPOTENCY_TTL и
+	// REQUIRE_READ_AUTH из .env, DB_PORT и DB_DRIVER - значения по умолчанию
+	got := map[string]interface{}{
+		"DB_HOST":           c.Host,
+		"DB_USER":           c.User,
+		"APP_PORT":          c.APIPort,
+		"DB_NAME":           c.DBName,
+		"IDEMPOTENCY_TTL":   c.IdempotencyTTL,
+		"REQUIRE_READ_AUTH": c.RequireReadAuth,
+		"DB_PORT":           c.Port,
+		"DB_DRIVER":         c.DBDriver,
+	}
+	want := map[string]interface{}{
+		"DB_HOST":           "flag-host",
+		"DB_USER":           "env-user",
+		"APP_PORT":          9100,
+		"DB_NAME":           "dotenv-name",
+		"IDEMPOTENCY_TTL":   time.Hour,
+		"REQUIRE_READ_AUTH": true,
+		"DB_PORT":           defaultConfig.Port,
+		"DB_DRIVER":         driverPostgres,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("config = %v, want %v", got, want)
+	}
+
+	// Файл из ENV_FILE, если флаг не задан
+	t.Setenv("ENV_FILE", envFile)
+	if c, err := loadTestConfig(t); err != nil || c.DBName != "dotenv-name" {
+		t.Errorf("ENV_FILE: DBName = %q, %v", c.DBName, err)
+	}
+}
+
+func TestLoadConfigEnvFile(t *testing.T) {
+	clearConfigEnv(t)
+
+	// Без явного пути отсутствующий .env не ошибка
+	t.Chdir(t.TempDir())
+	c, err := loadTestConfig(t)
+	if err != nil {
+		t.Fatalf("no .env: %v", err)
+	}
+	if !reflect.DeepEqual(c, defaultConfig) {
+		t.Errorf("config = %+v, want defaults", c)
+	}
+
+	// Явно указанный файл обязан существовать
+	if _, err := loadTestConfig(t, "-env-file", "missing.env"); err == nil {
+		t.Error("missing -env-file: no error")
+	}
+
+	_, err = loadTestConfig(t, "-env-file", writeDotEnv(t, "DB_PORT=five\nJOB_RETRY_BACKOFF=5\nREQUIRE_READ_AUTH=maybe\n"))
+	var usage usageError
+	if !errors.As(err, &usage) {
+		t.Fatalf("invalid values: err = %v, want usageError", err)
+	}
+	for _, key := range []string{"DB_PORT", "JOB_RETRY_BACKOFF", "REQUIRE_READ_AUTH"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("error %q does not mention %s", err, key)
+		}
+	}
+}
+
+func TestLoadConfigProduction(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr []string
+	}{
+		{
+			name:    "nothing provided",
+			env:     map[string]string{"APP_ENV": envProduction},
+			wantErr: []string{"JWT_SECRET обязателен", "DB_HOST обязателен", "DB_USER обязателен", "DB_PASSWORD обязателен", "DB_NAME обязателен"},
+		},
+		{
+			name: "placeholder secret",
+			env: map[string]string{
+				"APP_ENV": envProduction, "JWT_SECRET": placeholderJWTSecret,
+				"DB_HOST": "db", "DB_USER": "api", "DB_PASSWORD": "secret", "DB_NAME": "simple_api",
+			},
+			wantErr: []string{"JWT_SECRET: используется значение из примера .env"},
+		},
+		{
+			name: "all provided",
+			env: map[string]string{
+				"APP_ENV": envProduction, "JWT_SECRET": "real-secret",
+				"DB_HOST": "db", "DB_USER": "api", "DB_PASSWORD": "secret", "DB_NAME": "simple_api",
+			},
+		},
+		{
+			// Для SQLite параметры подключения к Postgres не нужны
+			name: "sqlite",
+			env:  map[string]string{"APP_ENV": envProduction, "JWT_SECRET": "real-secret", "DB_DRIVER": driverSQLite},
+		},
+		{
+			name:    "sqlite without secret",
+			env:     map[string]string{"APP_ENV": envProduction, "DB_DRIVER": driverSQLite},
+			wantErr: []string{"JWT_SECRET обязателен"},
+		},
+		{
+			name: "development defaults",
+			env:  map[string]string{"APP_ENV": envDevelopment},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			_, err := loadTestConfig(t, "-env-file", writeDotEnv(t, ""))
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("loadConfig: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("no error, want %v", tt.wantErr)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+
+	// Флаг -env тоже включает проверку
+	clearConfigEnv(t)
+	if _, err := loadTestConfig(t, "-env-file", writeDotEnv(t, ""), "-env", envProduction); err == nil ||
+		!strings.Contains(err.Error(), "JWT_SECRET обязателен") {
+		t.Errorf("-env production: err = %v", err)
+	}
+}
+
+func TestReadDotEnv(t *testing.T) {
+	path := writeDotEnv(t, `# комментарий
+  # комментарий с отступом
+
+PLAIN=value
+SPACED = value with spaces
+export EXPORTED=yes
+DOUBLE="quoted # not a comment"
+SINGLE='single quoted'
+INLINE=value # комментарий
+HASH=a#b
+EMPTY=
+EMPTY_QUOTES=""
+EQUALS=a=b=c
+MISMATCHED="open
+`)
+	got, err := readDotEnv(path)
+	if err != nil {
+		t.Fatalf("readDotEnv: %v", err)
+	}
+	want := map[string]string{
+		"PLAIN":        "value",
+		"SPACED":       "value with spaces",
+		"EXPORTED":     "yes",
+		"DOUBLE":       "quoted # not a comment",
+		"SINGLE":       "single quoted",
+		"INLINE":       "value",
+		"HASH":         "a#b",
+		"EMPTY":        "",
+		"EMPTY_QUOTES": "",
+		"EQUALS":       "a=b=c",
+		"MISMATCHED":   `"open`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("values = %v\nwant %v", got, want)
+	}
+
+	_, err = readDotEnv(writeDotEnv(t, "GOOD=1\nNO_EQUALS\n"))
+	if err == nil || !strings.Contains(err.Error(), "строка 2") {
+		t.Errorf("line without =: err = %v", err)
+	}
+
+	if _, err := readDotEnv(filepath.Join(t.TempDir(), "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file: err = %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import "net/http"
+
+// ValidatePageDataResponse - результат проверки без сохранения
+type ValidatePageDataResponse struct {
+	Success  bool         `json:"success"`
+	Valid    bool         `json:"valid"`
+	Errors   []FieldError `json:"errors"`
+	Warnings []FieldError `json:"warnings"`
+	Data     *PageData    `json:"data,omitempty"` // то, что было бы сохранено
+}
+
+// Обработчик проверки данных парсинга без записи в базу: тот же разбор, проверки
+// и нормализация, что и при сохранении
+func (app *Application) validatePageDataHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		app.respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	pageData, errs, ok := app.decodePageDataRequest(w, r)
+	if !ok {
+		return
+	}
+
+	response := ValidatePageDataResponse{
+		Success:  true,
+		Errors:   append([]FieldError{}, errs...),
+		Warnings: []FieldError{},
+	}
+	if pageData == nil {
+		app.respondWithJSON(w, http.StatusOK, response)
+		return
+	}
+
+	if err := checkSourceRestriction(principalFromContext(r), pageData); err != nil {
+		response.Errors = append(response.Errors, FieldError{Pointer: "", Message: err.Error()})
+	}
+
+	// Нормализуем и при ошибках - так видно, что сервер сделал бы с остальными полями
+	normalizePageData(pageData, r.UserAgent())
+	response.Warnings = append(response.Warnings, pageDataWarnings(pageData)...)
+
+	response.Valid = len(response.Errors) == 0
+	response.Data = pageData
+	app.respondWithJSON(w, http.StatusOK, response)
+}
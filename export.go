@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const exportBatchSize = 500
+
+// exportFilter - общие фильтры выгрузки; пустые поля не фильтруют
+type exportFilter struct {
+	Source  string
+	PageURL string
+	Since   time.Time
+}
+
+// Подкоманда export: products или snapshots в NDJSON (по умолчанию) или массив JSON.
+// Снимки выгружаются с составом в момент парсинга и подходят для import.
+func runExportCommand(args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return usageErrorf("использование: export products|snapshots [флаги]")
+	}
+	kind, args := args[0], args[1:]
+	if kind != "products" && kind != "snapshots" {
+		return usageErrorf("неизвестный вид выгрузки %q: ожидается products или snapshots", kind)
+	}
+
+	fs := flag.NewFlagSet("export "+kind, flag.ContinueOnError)
+	format := fs.String("format", "ndjson", "формат: ndjson или json (массив)")
+	output := fs.String("o", "-", "файл для выгрузки, - - stdout")
+	since := fs.String("since", "", "только записи, созданные не раньше этого момента (RFC3339 или 2006-01-02)")
+	pageURL := fs.String("page-url", "", "только продукты или снимки этой страницы")
+	source := fs.String("source", "", "только продукты этого источника")
+
+	config, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if *format != "ndjson" && *format != "json" {
+		return usageErrorf("-format: ожидается ndjson или json, получено %q", *format)
+	}
+	if *source != "" && kind != "products" {
+		return usageErrorf("-source применим только к products")
+	}
+	filter := exportFilter{Source: *source, PageURL: *pageURL}
+	if *since != "" {
+		if filter.Since, err = parseSince(*since); err != nil {
+			return usageErrorf("-since: %v", err)
+		}
+	}
+
+	db, err := openCommandDatabase(config)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	w := newExportWriter(out, *format == "json")
+	if kind == "products" {
+		err = exportProducts(db, w, filter)
+	} else {
+		err = exportSnapshots(db, w, filter)
+	}
+	if err == nil {
+		err = w.close()
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("exported %d %s", w.count, kind)
+	return nil
+}
+
+func parseSince(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// Продукты в порядке создания
+func exportProducts(db *gorm.DB, w *exportWriter, filter exportFilter) error {
+	query := db.Model(&Product{}).Order("created_at, id")
+	if filter.Source != "" {
+		query = query.Where("source = ?", filter.Source)
+	}
+	if filter.PageURL != "" {
+		query = query.Where("page_url = ?", filter.PageURL)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+
+	var last *Product
+	for {
+		var batch []Product
+		page := query.Session(&gorm.Session{})
+		if last != nil {
+			page = afterExportKey(page, last.CreatedAt, last.ID)
+		}
+		if err := page.Limit(exportBatchSize).Find(&batch).Error; err != nil {
+			return fmt.Errorf("ошибка чтения продуктов: %w", err)
+		}
+		for i := range batch {
+			if err := w.write(&batch[i]); err != nil {
+				return err
+			}
+		}
+		if len(batch) < exportBatchSize {
+			return nil
+		}
+		last = &batch[len(batch)-1]
+	}
+}
+
+// Снимки от старых к новым - в этом порядке их и нужно импортировать
+func exportSnapshots(db *gorm.DB, w *exportWriter, filter exportFilter) error {
+	query := db.Model(&PageData{}).Order("created_at, id")
+	if filter.PageURL != "" {
+		query = query.Where("url = ?", filter.PageURL)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+
+	var last *PageData
+	for {
+		var batch []PageData
+		page := query.Session(&gorm.Session{})
+		if last != nil {
+			page = afterExportKey(page, last.CreatedAt, last.ID)
+		}
+		if err := page.Limit(exportBatchSize).Find(&batch).Error; err != nil {
+			return fmt.Errorf("ошибка чтения снимков: %w", err)
+		}
+		for i := range batch {
+			if err := loadSnapshotProducts(db, &batch[i]); err != nil {
+				return fmt.Errorf("ошибка чтения состава снимка %s: %w", batch[i].ID, err)
+			}
+			if err := w.write(&batch[i]); err != nil {
+				return err
+			}
+		}
+		if len(batch) < exportBatchSize {
+			return nil
+		}
+		last = &batch[len(batch)-1]
+	}
+}
+
+// Следующая пачка выгрузки - строки после последней выгруженной в порядке (created_at, id).
+// В отличие от OFFSET, база не перечитывает уже выгруженные строки на каждой пачке.
+func afterExportKey(query *gorm.DB, createdAt time.Time, id string) *gorm.DB {
+	return query.Where("(created_at, id) > (?, ?)", createdAt, id)
+}
+
+// exportWriter пишет записи построчно (NDJSON) или элементами массива JSON
+type exportWriter struct {
+	w     *bufio.Writer
+	array bool
+	count int
+}
+
+func newExportWriter(w io.Writer, array bool) *exportWriter {
+	return &exportWriter{w: bufio.NewWriter(w), array: array}
+}
+
+func (e *exportWriter) write(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if e.array {
+		if e.count == 0 {
+			e.w.WriteString("[\n")
+		} else {
+			e.w.WriteString(",\n")
+		}
+	}
+	e.w.Write(data)
+	if !e.array {
+		e.w.WriteByte('\n')
+	}
+	e.count++
+	return nil
+}
+
+// Закрывает массив и сбрасывает буфер; ошибки записи проявляются здесь
+func (e *exportWriter) close() error {
+	if e.array {
+		if e.count == 0 {
+			e.w.WriteString("[")
+		}
+		e.w.WriteString("\n]\n")
+	}
+	return e.w.Flush()
+}
@@ -0,0 +1,921 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Хранилища, на которых прогоняются HTTP-тесты
+var testStores = []struct {
+	name string
+	new  func(t *testing.T) Store
+}{
+	{"memory", func(t *testing.T) Store { return NewMemoryStore() }},
+	{"sqlite", func(t *testing.T) Store {
+		db := openTestSQLite(t)
+		if _, err := migrateUp(db); err != nil {
+			t.Fatalf("migrateUp: %v", err)
+		}
+		return NewSQLiteStore(db)
+	}},
+	{"postgres", func(t *testing.T) Store {
+		db := openTestPostgres(t)
+		if _, err := migrateUp(db); err != nil {
+			t.Fatalf("migrateUp: %v", err)
+		}
+		return NewPostgresStore(db)
+	}},
+}
+
+func openTestSQLite(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := openSQLite(filepath.Join(t.TempDir(), "test.db"), &gorm.Config{Logger: logger.Discard, TranslateError: true})
+	if err != nil {
+		t.Fatalf("openSQLite: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// Тестовая база Postgres из TEST_DATABASE_DSN; без переменной тест пропускается.
+// Схема public пересоздается: база должна быть отдельной, только для тестов.
+func openTestPostgres(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN не задан")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Discard, TranslateError: true})
+	if err != nil {
+		t.Fatalf("connect postgres: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	if err := db.Exec("DROP SCHEMA public CASCADE; CREATE SCHEMA public").Error; err != nil {
+		t.Fatalf("reset schema: %v", err)
+	}
+	return db
+}
+
+// Прогоняет тест для каждого хранилища на новом экземпляре приложения
+func forEachStore(t *testing.T, test func(t *testing.T, api *testAPI)) {
+	for _, s := range testStores {
+		t.Run(s.name, func(t *testing.T) {
+			test(t, newTestAPI(t, s.new(t)))
+		})
+	}
+}
+
+// То же для хранилищ на базе данных: эндпоинты под requireDB в памяти недоступны
+func forEachDBStore(t *testing.T, test func(t *testing.T, api *testAPI)) {
+	for _, s := range testStores {
+		if s.name == "memory" {
+			continue
+		}
+		t.Run(s.name, func(t *testing.T) {
+			test(t, newTestAPI(t, s.new(t)))
+		})
+	}
+}
+
+type testAPI struct {
+	app     *Application
+	handler http.Handler
+	token   string
+}
+
+func newTestAPI(t *testing.T, store Store) *testAPI {
+	t.Helper()
+
+	cfg.JWTSecret = "test-secret"
+	cfg.JWTIssuer = "simple-api"
+	app := NewApplication(store)
+	return &testAPI{app: app, handler: setupRouter(app), token: signTestToken(t, ScopeIngest+" "+ScopeRead)}
+}
+
+func signTestToken(t *testing.T, scope string) string {
+	t.Helper()
+	token, err := signJWT(JWTClaims{
+		Subject:   "test-scraper",
+		Issuer:    cfg.JWTIssuer,
+		Scope:     scope,
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}, []byte(cfg.JWTSecret))
+	if err != nil {
+		t.Fatalf("signJWT: %v", err)
+	}
+	return token
+}
+
+func (api *testAPI) do(t *testing.T, method, path string, body interface{}, authorized bool) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var reader *bytes.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("marshal request: %v", err)
+		}
+		reader = bytes.NewReader(raw)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	r := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if authorized {
+		r.Header.Set("Authorization", "Bearer "+api.token)
+	}
+
+	w := httptest.NewRecorder()
+	api.handler.ServeHTTP(w, r)
+	return w
+}
+
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode response %q: %v", w.Body.String(), err)
+	}
+}
+
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, code int) {
+	t.Helper()
+	if w.Code != code {
+		t.Fatalf("status = %d, want %d; body: %s", w.Code, code, w.Body.String())
+	}
+}
+
+func floatPtr(v float64) *float64 { return &v }
+
+func samplePageData() PageData {
+	return PageData{
+		URL:       "https://shop.example/milk",
+		PageTitle: "Молоко",
+		Products: []Product{
+			{Name: "Молоко Простоквашино 3,2% 930 мл", URL: "https://shop.example/p/1", Price: 89.99, Source: "shop"},
+			{Name: "Молоко Домик в деревне 2,5%", URL: "https://shop.example/p/2", Price: 120, OldPrice: floatPtr(150), Discount: floatPtr(20), Weight: floatPtr(1.4), Unit: "л", Source: "shop"},
+			{Name: "Молочный коктейль", URL: "https://shop.example/p/3", Price: 65, Source: "shop"},
+		},
+	}
+}
+
+func TestSavePageDataRequiresToken(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		w := api.do(t, http.MethodPost, "/api/v1/page-data", samplePageData(), false)
+		expectStatus(t, w, http.StatusUnauthorized)
+	})
+}
+
+func TestReadEndpointsArePublicByDefault(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		expectStatus(t, api.do(t, http.MethodPost, "/api/v1/page-data", samplePageData(), true), http.StatusCreated)
+		expectStatus(t, api.do(t, http.MethodGet, "/api/v1/product?url=https://shop.example/p/1", nil, false), http.StatusOK)
+		expectStatus(t, api.do(t, http.MethodGet, "/api/v1/category?page_url=https://shop.example/milk", nil, false), http.StatusOK)
+	})
+}
+
+func TestReadEndpointsRequireReadScope(t *testing.T) {
+	cfg.RequireReadAuth = true
+	t.Cleanup(func() { cfg.RequireReadAuth = false })
+
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		expectStatus(t, api.do(t, http.MethodGet, "/api/v1/category?page_url=https://shop.example/milk", nil, false), http.StatusUnauthorized)
+
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/product?url=https://shop.example/p/1", nil)
+		r.Header.Set("Authorization", "Bearer "+signTestToken(t, ScopeIngest))
+		w := httptest.NewRecorder()
+		api.handler.ServeHTTP(w, r)
+		expectStatus(t, w, http.StatusForbidden)
+
+		expectStatus(t, api.do(t, http.MethodGet, "/", nil, false), http.StatusOK)
+	})
+}
+
+func TestSavePageDataStoresSnapshotAndProducts(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		w := api.do(t, http.MethodPost, "/api/v1/page-data", samplePageData(), true)
+		expectStatus(t, w, http.StatusCreated)
+
+		var saved SavePageDataResponse
+		decodeBody(t, w, &saved)
+		if saved.ID == "" || saved.PageID == "" {
+			t.Fatalf("response without ids: %+v", saved)
+		}
+
+		w = api.do(t, http.MethodGet, "/api/v1/page-data", nil, true)
+		expectStatus(t, w, http.StatusOK)
+		var list GetPageDataResponse
+		decodeBody(t, w, &list)
+		if len(list.Data) != 1 || list.Data[0].ID != saved.ID {
+			t.Fatalf("page data = %+v, want snapshot %s", list.Data, saved.ID)
+		}
+		if got := len(list.Data[0].Products); got != 3 {
+			t.Errorf("snapshot products = %d, want 3", got)
+		}
+		if got := list.Data[0].Stats.TotalProducts; got != 3 {
+			t.Errorf("recomputed totalProducts = %d, want 3", got)
+		}
+
+		// Вес и единица извлечены из названия, цена за литр посчитана
+		w = api.do(t, http.MethodGet, "/api/v1/product?url=https://shop.example/p/1", nil, true)
+		expectStatus(t, w, http.StatusOK)
+		var product GetProductsResponse
+		decodeBody(t, w, &product)
+		p := product.Product
+		if p == nil || p.ID == "" {
+			t.Fatalf("product not returned: %s", w.Body.String())
+		}
+		if p.WeightSource != SizeInferred || p.Unit != "мл" || p.UnitDimension != DimensionVolume {
+			t.Errorf("size = %v %q (%s), dimension %q", p.Weight, p.Unit, p.WeightSource, p.UnitDimension)
+		}
+		if p.UnitPrice == nil || *p.UnitPrice != 96.76 {
+			t.Errorf("unitPrice = %v, want 96.76", p.UnitPrice)
+		}
+
+		w = api.do(t, http.MethodGet, "/api/v1/product?id="+p.ID, nil, true)
+		expectStatus(t, w, http.StatusOK)
+		expectStatus(t, api.do(t, http.MethodGet, "/api/v1/product?id=not-a-uuid", nil, true), http.StatusBadRequest)
+	})
+}
+
+func TestGetSnapshotRejectsMalformedID(t *testing.T) {
+	forEachDBStore(t, func(t *testing.T, api *testAPI) {
+		expectStatus(t, api.do(t, http.MethodGet, "/api/v1/page-data/snapshot?id=42", nil, true), http.StatusBadRequest)
+		expectStatus(t, api.do(t, http.MethodGet, "/api/v1/page-data/snapshot?id="+newUUID(), nil, true), http.StatusNotFound)
+	})
+}
+
+func TestSavePageDataUpsertsProductsByURL(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		first := samplePageData()
+		expectStatus(t, api.do(t, http.MethodPost, "/api/v1/page-data", first, true), http.StatusCreated)
+
+		w := api.do(t, http.MethodGet, "/api/v1/product?url=https://shop.example/p/1", nil, true)
+		var before GetProductsResponse
+		decodeBody(t, w, &before)
+
+		second := samplePageData()
+		second.Products = second.Products[:1]
+		second.Products[0].Price = 79.99
+		expectStatus(t, api.do(t, http.MethodPost, "/api/v1/page-data", second, true), http.StatusCreated)
+
+		w = api.do(t, http.MethodGet, "/api/v1/product?url=https://shop.example/p/1", nil, true)
+		var after GetProductsResponse
+		decodeBody(t, w, &after)
+		if after.Product.ID != before.Product.ID {
+			t.Errorf("product id changed: %s -> %s", before.Product.ID, after.Product.ID)
+		}
+		if after.Product.Price != 79.99 {
+			t.Errorf("price = %v, want 79.99", after.Product.Price)
+		}
+
+		// Каждый снимок отдает свой состав с ценами на момент парсинга
+		w = api.do(t, http.MethodGet, "/api/v1/page-data", nil, true)
+		var list GetPageDataResponse
+		decodeBody(t, w, &list)
+		if len(list.Data) != 2 {
+			t.Fatalf("snapshots = %d, want 2", len(list.Data))
+		}
+		if got := list.Data[0].Products; len(got) != 1 || got[0].Price != 79.99 {
+			t.Errorf("newest snapshot products = %+v, want p/1 at 79.99", got)
+		}
+		if got := list.Data[1].Products; len(got) != 3 || got[0].URL != "https://shop.example/p/1" || got[0].Price != 89.99 {
+			t.Errorf("older snapshot products = %+v, want 3 with p/1 at 89.99", got)
+		}
+	})
+}
+
+func TestSavePageDataValidationErrors(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		pageData := samplePageData()
+		pageData.URL = "shop.example/milk"
+		pageData.Products[1].Price = -1
+
+		w := api.do(t, http.MethodPost, "/api/v1/page-data", pageData, true)
+		expectStatus(t, w, http.StatusBadRequest)
+
+		var resp ValidationErrorResponse
+		decodeBody(t, w, &resp)
+		pointers := map[string]bool{}
+		for _, e := range resp.Errors {
+			pointers[e.Pointer] = true
+		}
+		if !pointers["/url"] || !pointers["/products/1/price"] || len(resp.Errors) != 2 {
+			t.Errorf("errors = %+v, want /url and /products/1/price", resp.Errors)
+		}
+
+		w = api.do(t, http.MethodGet, "/api/v1/page-data", nil, true)
+		var list GetPageDataResponse
+		decodeBody(t, w, &list)
+		if len(list.Data) != 0 {
+			t.Errorf("invalid page data was stored: %+v", list.Data)
+		}
+	})
+}
+
+func TestGetPageDataStatsDivergentFilter(t *testing.T) {
+	// Stats уходят в запрос как есть: отсутствующее поле не сравнивается, присланный ноль - сравнивается
+	withStats := func(pd PageData, stats string) json.RawMessage {
+		raw, _ := json.Marshal(pd)
+		var fields map[string]json.RawMessage
+		_ = json.Unmarshal(raw, &fields)
+		fields["stats"] = json.RawMessage(stats)
+		raw, _ = json.Marshal(fields)
+		return raw
+	}
+
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		honest := samplePageData()
+		expectStatus(t, api.do(t, http.MethodPost, "/api/v1/page-data", withStats(honest, `{"totalProducts": 3}`), true), http.StatusCreated)
+
+		wrong := samplePageData()
+		wrong.URL = "https://shop.example/kefir"
+		for i := range wrong.Products {
+			wrong.Products[i].URL += "-kefir"
+		}
+		expectStatus(t, api.do(t, http.MethodPost, "/api/v1/page-data", withStats(wrong, `{"totalProducts": 200, "maxPrice": 120}`), true), http.StatusCreated)
+
+		zero := samplePageData()
+		zero.URL = "https://shop.example/yogurt"
+		for i := range zero.Products {
+			zero.Products[i].URL += "-yogurt"
+		}
+		expectStatus(t, api.do(t, http.MethodPost, "/api/v1/page-data", withStats(zero, `{"totalProducts": 3, "withDiscount": 0}`), true), http.StatusCreated)
+
+		tests := []struct {
+			query string
+			want  []string
+		}{
+			{"", []string{zero.URL, wrong.URL, honest.URL}},
+			{"?stats_divergent=true", []string{zero.URL, wrong.URL}},
+			{"?stats_divergent=false", []string{honest.URL}},
+			{"?stats_field=totalProducts", []string{wrong.URL}},
+			{"?stats_field=withDiscount", []string{zero.URL}},
+			{"?stats_field=maxPrice", []string{}},
+		}
+		for _, tt := range tests {
+			w := api.do(t, http.MethodGet, "/api/v1/page-data"+tt.query, nil, true)
+			expectStatus(t, w, http.StatusOK)
+			var list GetPageDataResponse
+			decodeBody(t, w, &list)
+
+			got := []string{}
+			for _, pd := range list.Data {
+				got = append(got, pd.URL)
+			}
+			if len(got) != len(tt.want) || (len(got) > 0 && got[0] != tt.want[0]) {
+				t.Errorf("GET /api/v1/page-data%s = %v, want %v", tt.query, got, tt.want)
+			}
+		}
+
+		w := api.do(t, http.MethodGet, "/api/v1/page-data?stats_divergent=maybe", nil, true)
+		expectStatus(t, w, http.StatusBadRequest)
+	})
+}
+
+func TestGetProductErrors(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		expectStatus(t, api.do(t, http.MethodGet, "/api/v1/product", nil, true), http.StatusBadRequest)
+		expectStatus(t, api.do(t, http.MethodGet, "/api/v1/product?id=00000000-0000-4000-8000-000000000000", nil, true), http.StatusNotFound)
+		expectStatus(t, api.do(t, http.MethodGet, "/api/v1/product?url=https://shop.example/none", nil, true), http.StatusNotFound)
+	})
+}
+
+func TestGetCategory(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		expectStatus(t, api.do(t, http.MethodPost, "/api/v1/page-data", samplePageData(), true), http.StatusCreated)
+
+		other := samplePageData()
+		other.URL = "https://shop.example/other"
+		other.Products = []Product{{Name: "Сыр 200 г", URL: "https://shop.example/p/9", Price: 300, Source: "shop"}}
+		expectStatus(t, api.do(t, http.MethodPost, "/api/v1/page-data", other, true), http.StatusCreated)
+
+		tests := []struct {
+			query string
+			want  []string // URL продуктов по порядку
+		}{
+			{"sort=price_asc", []string{"https://shop.example/p/3", "https://shop.example/p/1", "https://shop.example/p/2"}},
+			{"sort=price_desc", []string{"https://shop.example/p/2", "https://shop.example/p/1", "https://shop.example/p/3"}},
+			// 120 за 1,4 л = 85.71 за литр дешевле, чем 96.76; без цены за единицу - в конце
+			{"sort=unit_price_asc", []string{"https://shop.example/p/2", "https://shop.example/p/1", "https://shop.example/p/3"}},
+			{"sort=unit_price_desc", []string{"https://shop.example/p/1", "https://shop.example/p/2", "https://shop.example/p/3"}},
+			{"sort=unit_price_asc&dimension=volume&max_unit_price=90", []string{"https://shop.example/p/2"}},
+			{"sort=price_asc&discount=true", []string{"https://shop.example/p/2"}},
+			{"sort=price_asc&per_page=2&page=2", []string{"https://shop.example/p/2"}},
+		}
+		for _, tt := range tests {
+			w := api.do(t, http.MethodGet, "/api/v1/category?page_url=https://shop.example/milk&"+tt.query, nil, true)
+			expectStatus(t, w, http.StatusOK)
+			var resp GetCategoryResponse
+			decodeBody(t, w, &resp)
+
+			got := []string{}
+			for _, p := range resp.Products {
+				got = append(got, p.URL)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("%s: products = %v, want %v", tt.query, got, tt.want)
+				continue
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("%s: products = %v, want %v", tt.query, got, tt.want)
+					break
+				}
+			}
+		}
+
+		expectStatus(t, api.do(t, http.MethodGet, "/api/v1/category", nil, true), http.StatusBadRequest)
+		expectStatus(t, api.do(t, http.MethodGet, "/api/v1/category?page_url=https://shop.example/milk&sort=cheapest", nil, true), http.StatusBadRequest)
+		expectStatus(t, api.do(t, http.MethodGet, "/api/v1/category?page_url=https://shop.example/milk&dimension=area", nil, true), http.StatusBadRequest)
+	})
+}
+
+func TestGetPriceChangesDirectionFilter(t *testing.T) {
+	forEachDBStore(t, func(t *testing.T, api *testAPI) {
+		expectStatus(t, api.do(t, http.MethodPost, "/api/v1/page-data", samplePageData(), true), http.StatusCreated)
+		cheaper := samplePageData()
+		cheaper.Products[0].Price = 79.99
+		expectStatus(t, api.do(t, http.MethodPost, "/api/v1/page-data", cheaper, true), http.StatusCreated)
+
+		for direction, want := range map[string]int64{ChangeNew: 3, ChangeDrop: 1, ChangeRise: 0} {
+			w := api.do(t, http.MethodGet, "/api/v1/changes?direction="+direction, nil, true)
+			expectStatus(t, w, http.StatusOK)
+			var resp GetPriceChangesResponse
+			decodeBody(t, w, &resp)
+			if resp.Total != want || len(resp.Changes) != int(want) {
+				t.Errorf("direction=%s: total %d, %d changes, want %d", direction, resp.Total, len(resp.Changes), want)
+			}
+		}
+
+		expectStatus(t, api.do(t, http.MethodGet, "/api/v1/changes?direction=falling", nil, true), http.StatusBadRequest)
+	})
+}
+
+// Процент от копеечной цены не помещается в decimal(10,2): сохранение не должно падать
+func TestPriceChangeFromTinyPrice(t *testing.T) {
+	forEachDBStore(t, func(t *testing.T, api *testAPI) {
+		tiny := samplePageData()
+		tiny.Products[0].Price = 0.01
+		expectStatus(t, api.do(t, http.MethodPost, "/api/v1/page-data", tiny, true), http.StatusCreated)
+		pricey := samplePageData()
+		pricey.Products[0].Price = 1000
+		expectStatus(t, api.do(t, http.MethodPost, "/api/v1/page-data", pricey, true), http.StatusCreated)
+
+		w := api.do(t, http.MethodGet, "/api/v1/changes?direction="+ChangeRise, nil, true)
+		expectStatus(t, w, http.StatusOK)
+		var resp GetPriceChangesResponse
+		decodeBody(t, w, &resp)
+		if len(resp.Changes) != 1 || resp.Changes[0].PctDelta == nil || *resp.Changes[0].PctDelta != 9999900 {
+			t.Errorf("rise changes = %+v, want pctDelta 9999900", resp.Changes)
+		}
+	})
+}
+
+func TestSaveBulkPageDataStreamsResults(t *testing.T) {
+	forEachDBStore(t, func(t *testing.T, api *testAPI) {
+		// С Idempotency-Key тело тоже читается потоком: middleware хеширует его по мере чтения
+		for _, key := range []string{"", "bulk-stream"} {
+			t.Run("key="+key, func(t *testing.T) {
+				// loggingMiddleware как в runServe: ResponseController должен пройти через обертки
+				server := httptest.NewServer(loggingMiddleware(api.handler))
+				defer server.Close()
+
+				body, input := io.Pipe()
+				req, _ := http.NewRequest(http.MethodPost, server.URL+"/api/v1/page-data/bulk?batch_size=1", body)
+				req.Header.Set("Content-Type", "application/x-ndjson")
+				req.Header.Set("Authorization", "Bearer "+api.token)
+				if key != "" {
+					req.Header.Set(idempotencyHeader, key)
+				}
+
+				first, _ := json.Marshal(samplePageData())
+				second := samplePageData()
+				second.URL = "https://shop.example/kefir"
+				secondLine, _ := json.Marshal(second)
+
+				go func() {
+					input.Write(append(first, '\n'))
+				}()
+				// Без full duplex сервер ждет конца тела, а клиент - ответа: закрываем тело по таймауту
+				timer := time.AfterFunc(10*time.Second, func() { input.CloseWithError(io.ErrUnexpectedEOF) })
+				defer timer.Stop()
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatalf("POST bulk: %v", err)
+				}
+				defer resp.Body.Close()
+
+				// Результат первой строки приходит до того, как клиент дописал тело запроса
+				reader := bufio.NewReader(resp.Body)
+				prefix := make([]byte, len(`{"results":[{"line":1,`))
+				if _, err := io.ReadFull(reader, prefix); err != nil || string(prefix) != `{"results":[{"line":1,` {
+					t.Fatalf("streamed prefix = %q, %v", prefix, err)
+				}
+
+				input.Write([]byte("{not json\n"))
+				input.Write(append(secondLine, '\n'))
+				input.Close()
+
+				rest, err := io.ReadAll(reader)
+				if err != nil {
+					t.Fatalf("read response: %v", err)
+				}
+				var result BulkSaveResponse
+				if err := json.Unmarshal(append(prefix, rest...), &result); err != nil {
+					t.Fatalf("decode %s%s: %v", prefix, rest, err)
+				}
+				if result.Total != 3 || result.Created != 2 || result.Failed != 1 || result.Success {
+					t.Errorf("summary = %+v", result.bulkSummary)
+				}
+				for i, res := range result.Results {
+					if res.Line != i+1 {
+						t.Errorf("results[%d].line = %d", i, res.Line)
+					}
+				}
+				if len(result.Results) == 3 && (result.Results[1].Status != "error" || result.Results[2].ID == "") {
+					t.Errorf("results = %+v", result.Results)
+				}
+			})
+		}
+	})
+}
+
+func TestSaveBulkPageDataIdempotencyKey(t *testing.T) {
+	forEachDBStore(t, func(t *testing.T, api *testAPI) {
+		line, _ := json.Marshal(samplePageData())
+		post := func(key, body string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/page-data/bulk", strings.NewReader(body))
+			r.Header.Set("Content-Type", "application/x-ndjson")
+			r.Header.Set("Authorization", "Bearer "+api.token)
+			r.Header.Set(idempotencyHeader, key)
+			w := httptest.NewRecorder()
+			api.handler.ServeHTTP(w, r)
+			return w
+		}
+
+		body := string(line) + "\n"
+		expectStatus(t, post("bulk", body), http.StatusOK)
+		w := post("bulk", body)
+		expectStatus(t, w, http.StatusOK)
+		if w.Header().Get(idempotencyReplayedHeader) != "true" {
+			t.Error("bulk response was not replayed")
+		}
+		expectStatus(t, post("bulk", body+body), http.StatusUnprocessableEntity)
+
+		// Строки, не сохраненные из-за ошибки базы, не должны повторяться до истечения ключа
+		if err := api.app.db.Migrator().DropTable("snapshot_products"); err != nil {
+			t.Fatalf("drop snapshot_products: %v", err)
+		}
+		w = post("broken", body)
+		expectStatus(t, w, http.StatusOK)
+		var result BulkSaveResponse
+		decodeBody(t, w, &result)
+		if result.Failed != 1 {
+			t.Fatalf("summary = %+v, want the line to fail", result.bulkSummary)
+		}
+		var stored int64
+		api.app.db.Model(&IdempotencyKey{}).Where("key = ?", "broken").Count(&stored)
+		if stored != 0 {
+			t.Error("response with failed lines was stored under the idempotency key")
+		}
+	})
+}
+
+func TestIdempotencyKeyLeaseTakeover(t *testing.T) {
+	forEachDBStore(t, func(t *testing.T, api *testAPI) {
+		body, _ := json.Marshal(samplePageData())
+		post := func(key string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/page-data", bytes.NewReader(body))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("Authorization", "Bearer "+api.token)
+			r.Header.Set(idempotencyHeader, key)
+			w := httptest.NewRecorder()
+			api.handler.ServeHTTP(w, r)
+			return w
+		}
+
+		// Ключ, занятый обработчиком, который упал, не сохранив ответ
+		h := newIdempotencyHash(httptest.NewRequest(http.MethodPost, "/api/v1/page-data", nil))
+		h.Write(body)
+		hash := hex.EncodeToString(h.Sum(nil))
+		stuck := IdempotencyKey{
+			Owner:       "jwt:test-scraper",
+			Key:         "stuck",
+			RequestHash: hash,
+			StartedAt:   time.Now().Add(-time.Minute),
+			ExpiresAt:   time.Now().Add(time.Hour),
+		}
+		if err := api.app.db.Create(&stuck).Error; err != nil {
+			t.Fatalf("create stuck key: %v", err)
+		}
+
+		expectStatus(t, post("stuck"), http.StatusConflict)
+
+		// Аренда истекла - повтор занимает ключ и выполняется
+		err := api.app.db.Model(&IdempotencyKey{}).Where("key = ?", "stuck").
+			Update("started_at", time.Now().Add(-api.app.idempotencyLease-time.Minute)).Error
+		if err != nil {
+			t.Fatalf("age stuck key: %v", err)
+		}
+		w := post("stuck")
+		expectStatus(t, w, http.StatusCreated)
+		if w.Header().Get(idempotencyReplayedHeader) != "" {
+			t.Errorf("taken over key was replayed")
+		}
+
+		w = post("stuck")
+		expectStatus(t, w, http.StatusCreated)
+		if w.Header().Get(idempotencyReplayedHeader) != "true" {
+			t.Errorf("response after takeover was not saved")
+		}
+	})
+}
+
+func TestCreateAPIKeyDuplicateName(t *testing.T) {
+	forEachDBStore(t, func(t *testing.T, api *testAPI) {
+		admin := signTestToken(t, ScopeAdmin)
+		create := func() *httptest.ResponseRecorder {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/admin/api-keys", strings.NewReader(`{"name": "worker-1", "scopes": ["ingest"]}`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("Authorization", "Bearer "+admin)
+			w := httptest.NewRecorder()
+			api.handler.ServeHTTP(w, r)
+			return w
+		}
+
+		expectStatus(t, create(), http.StatusCreated)
+		expectStatus(t, create(), http.StatusConflict)
+
+		r := httptest.NewRequest(http.MethodDelete, "/api/v1/admin/api-keys?id=worker-1", nil)
+		r.Header.Set("Authorization", "Bearer "+admin)
+		w := httptest.NewRecorder()
+		api.handler.ServeHTTP(w, r)
+		expectStatus(t, w, http.StatusBadRequest)
+	})
+}
+
+func TestGetStatistics(t *testing.T) {
+	forEachDBStore(t, func(t *testing.T, api *testAPI) {
+		expectStatus(t, api.do(t, http.MethodPost, "/api/v1/page-data", samplePageData(), true), http.StatusCreated)
+
+		w := api.do(t, http.MethodGet, "/api/v1/statistics?source=shop", nil, true)
+		expectStatus(t, w, http.StatusOK)
+		var resp GetStatisticsResponse
+		decodeBody(t, w, &resp)
+
+		global := resp.Stats.Global
+		if global.UniquePages != 1 || global.Snapshots != 1 || global.Last24h != 1 || global.LastSnapshotAt == nil {
+			t.Errorf("global snapshots = %+v", global.IngestWindows)
+		}
+		want := PriceAggregates{
+			Products: 3, MinPrice: 65, MaxPrice: 120, AvgPrice: 91.66333333333334,
+			Median: 89.99, P25: 77.495, P75: 104.995, P90: 113.998, P95: 116.999,
+			WithDiscount: 1, DiscountShare: 1.0 / 3, WithWeight: 2,
+		}
+		if !priceAggregatesEqual(global.PriceAggregates, want) {
+			t.Errorf("global prices = %+v, want %+v", global.PriceAggregates, want)
+		}
+
+		if len(resp.Stats.BySource) != 1 {
+			t.Fatalf("bySource = %+v", resp.Stats.BySource)
+		}
+		if src := resp.Stats.BySource[0]; src.Source != "shop" || src.Observations24h != 3 || !priceAggregatesEqual(src.PriceAggregates, want) {
+			t.Errorf("bySource = %+v", src)
+		}
+		if len(resp.Stats.ByPageURL) != 1 {
+			t.Fatalf("byPageUrl = %+v", resp.Stats.ByPageURL)
+		}
+		if page := resp.Stats.ByPageURL[0]; page.PageURL != "https://shop.example/milk" || page.Snapshots != 1 || page.LastSnapshotAt == nil || page.Median != 89.99 {
+			t.Errorf("byPageUrl = %+v", page)
+		}
+	})
+}
+
+// Сравнение с допуском: Postgres и SQLite по-разному округляют AVG и перцентили
+func priceAggregatesEqual(a, b PriceAggregates) bool {
+	near := func(x, y float64) bool { return math.Abs(x-y) < 1e-6 }
+	return a.Products == b.Products && a.WithDiscount == b.WithDiscount && a.WithWeight == b.WithWeight &&
+		near(a.MinPrice, b.MinPrice) && near(a.MaxPrice, b.MaxPrice) && near(a.AvgPrice, b.AvgPrice) &&
+		near(a.Median, b.Median) && near(a.P25, b.P25) && near(a.P75, b.P75) && near(a.P90, b.P90) &&
+		near(a.P95, b.P95) && near(a.DiscountShare, b.DiscountShare)
+}
+
+func TestGetPriceHistoryInterval(t *testing.T) {
+	forEachDBStore(t, func(t *testing.T, api *testAPI) {
+		for i, price := range []float64{100, 80, 120} {
+			pd := samplePageData()
+			pd.Products = pd.Products[:1]
+			pd.Products[0].Price = price
+			pd.Products[0].Timestamp = time.Date(2024, 3, 4+i/2, 9+i, 0, 0, 0, time.UTC)
+			expectStatus(t, api.do(t, http.MethodPost, "/api/v1/page-data", pd, true), http.StatusCreated)
+		}
+
+		w := api.do(t, http.MethodGet, "/api/v1/product/history?url=https://shop.example/p/1&interval=day", nil, true)
+		expectStatus(t, w, http.StatusOK)
+		var resp GetPriceHistoryResponse
+		decodeBody(t, w, &resp)
+
+		if len(resp.Buckets) != 2 {
+			t.Fatalf("buckets = %+v, want 2", resp.Buckets)
+		}
+		first := resp.Buckets[0]
+		if !first.Bucket.Equal(time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)) || first.Count != 2 ||
+			first.Min != 80 || first.Max != 100 || first.Avg != 90 || first.Last != 80 {
+			t.Errorf("first bucket = %+v", first)
+		}
+		if second := resp.Buckets[1]; second.Count != 1 || second.Last != 120 {
+			t.Errorf("second bucket = %+v", second)
+		}
+
+		w = api.do(t, http.MethodGet, "/api/v1/product/history?url=https://shop.example/p/1&interval=month", nil, true)
+		expectStatus(t, w, http.StatusBadRequest)
+	})
+}
+
+func TestGetPriceHistoryDateRange(t *testing.T) {
+	forEachDBStore(t, func(t *testing.T, api *testAPI) {
+		for i, price := range []float64{100, 80, 120} {
+			pd := samplePageData()
+			pd.Products = pd.Products[:1]
+			pd.Products[0].Price = price
+			pd.Products[0].Timestamp = time.Date(2024, 3, 4+i/2, 9+i, 0, 0, 0, time.UTC)
+			expectStatus(t, api.do(t, http.MethodPost, "/api/v1/page-data", pd, true), http.StatusCreated)
+		}
+
+		tests := []struct {
+			query string
+			want  []float64
+		}{
+			// Дата без времени в to включает весь день, а не только его полночь
+			{"to=2024-03-04", []float64{100, 80}},
+			{"from=2024-03-04&to=2024-03-05", []float64{100, 80, 120}},
+			{"from=2024-03-05", []float64{120}},
+			// Время в to - включительная граница
+			{"to=2024-03-04T09:00:00Z", []float64{100}},
+			{"to=2024-03-03", []float64{}},
+		}
+		for _, tt := range tests {
+			w := api.do(t, http.MethodGet, "/api/v1/product/history?url=https://shop.example/p/1&"+tt.query, nil, true)
+			expectStatus(t, w, http.StatusOK)
+			var resp GetPriceHistoryResponse
+			decodeBody(t, w, &resp)
+			got := []float64{}
+			for _, p := range resp.Points {
+				got = append(got, p.Price)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s: prices = %v, want %v", tt.query, got, tt.want)
+			}
+		}
+
+		expectStatus(t, api.do(t, http.MethodGet, "/api/v1/product/history?url=https://shop.example/p/1&to=04.03.2024", nil, true), http.StatusBadRequest)
+		expectStatus(t, api.do(t, http.MethodGet, "/api/v1/product/history?id=1", nil, true), http.StatusBadRequest)
+	})
+}
+
+func TestSearchProducts(t *testing.T) {
+	forEachDBStore(t, func(t *testing.T, api *testAPI) {
+		expectStatus(t, api.do(t, http.MethodPost, "/api/v1/page-data", samplePageData(), true), http.StatusCreated)
+
+		search := func(query string) SearchProductsResponse {
+			t.Helper()
+			w := api.do(t, http.MethodGet, "/api/v1/search/products?"+query, nil, true)
+			expectStatus(t, w, http.StatusOK)
+			var resp SearchProductsResponse
+			decodeBody(t, w, &resp)
+			return resp
+		}
+		names := func(resp SearchProductsResponse) []string {
+			got := []string{}
+			for _, r := range resp.Results {
+				got = append(got, r.Name)
+			}
+			return got
+		}
+
+		resp := search("q=" + url.QueryEscape("молока") + "&sort=price_asc")
+		if got := names(resp); resp.Mode != "fts" || resp.Total != 2 || len(got) != 2 ||
+			got[0] != "Молоко Простоквашино 3,2% 930 мл" || !strings.Contains(resp.Results[0].Snippet, "<b>") {
+			t.Errorf("fts search = %s %d %v %+v", resp.Mode, resp.Total, got, resp.Results)
+		}
+
+		resp = search("q=" + url.QueryEscape("молоко -домик"))
+		if got := names(resp); resp.Total != 1 || got[0] != "Молоко Простоквашино 3,2% 930 мл" {
+			t.Errorf("excluded word: %v", got)
+		}
+
+		// Не та раскладка: полнотекстовый поиск ничего не находит, auto переходит к нечеткому
+		resp = search("q=rjrntqkm")
+		if got := names(resp); resp.Mode != "fuzzy" || len(got) != 1 || got[0] != "Молочный коктейль" {
+			t.Errorf("fuzzy search = %s %v", resp.Mode, got)
+		}
+
+		w := api.do(t, http.MethodGet, "/api/v1/search/suggest?q=vjkjrj", nil, true)
+		expectStatus(t, w, http.StatusOK)
+		var suggest SuggestResponse
+		decodeBody(t, w, &suggest)
+		if len(suggest.Suggestions) != 2 || suggest.Suggestions[0].Name != "Молоко Домик в деревне 2,5%" ||
+			suggest.Suggestions[0].Score != 1 {
+			t.Errorf("suggestions = %+v", suggest.Suggestions)
+		}
+	})
+}
+
+func TestSavePageDataAsync(t *testing.T) {
+	forEachDBStore(t, func(t *testing.T, api *testAPI) {
+		w := api.do(t, http.MethodPost, "/api/v1/page-data?async=true", samplePageData(), true)
+		expectStatus(t, w, http.StatusAccepted)
+		var enqueued EnqueuePageDataResponse
+		decodeBody(t, w, &enqueued)
+
+		job, err := api.app.claimJob()
+		if err != nil || job == nil || job.ID != enqueued.JobID || job.Attempts != 1 {
+			t.Fatalf("claimJob = %+v, %v", job, err)
+		}
+		if next, err := api.app.claimJob(); err != nil || next != nil {
+			t.Fatalf("second claimJob = %+v, %v", next, err)
+		}
+		api.app.processJob(job)
+
+		w = api.do(t, http.MethodGet, enqueued.StatusURL, nil, true)
+		expectStatus(t, w, http.StatusOK)
+		var resp GetJobResponse
+		decodeBody(t, w, &resp)
+		if resp.Job.Status != JobSucceeded || resp.Job.PageDataID == nil || resp.Job.FinishedAt == nil {
+			t.Fatalf("job = %+v", resp.Job)
+		}
+		if _, err := api.app.getSnapshot(*resp.Job.PageDataID); err != nil {
+			t.Errorf("snapshot of job: %v", err)
+		}
+	})
+}
+
+func TestReclaimedJobKeepsNewerAttemptStatus(t *testing.T) {
+	forEachDBStore(t, func(t *testing.T, api *testAPI) {
+		w := api.do(t, http.MethodPost, "/api/v1/page-data?async=true", samplePageData(), true)
+		expectStatus(t, w, http.StatusAccepted)
+		var enqueued EnqueuePageDataResponse
+		decodeBody(t, w, &enqueued)
+
+		stale, err := api.app.claimJob()
+		if err != nil || stale == nil {
+			t.Fatalf("claimJob = %+v, %v", stale, err)
+		}
+		// Первый воркер завис дольше jobVisibilityTimeout, задачу забирает второй
+		api.app.db.Model(&IngestJob{}).Where("id = ?", stale.ID).
+			Update("started_at", time.Now().Add(-jobVisibilityTimeout-time.Minute))
+		current, err := api.app.claimJob()
+		if err != nil || current == nil || current.Attempts != 2 {
+			t.Fatalf("reclaim = %+v, %v", current, err)
+		}
+
+		jobStatus := func() IngestJob {
+			t.Helper()
+			w := api.do(t, http.MethodGet, enqueued.StatusURL, nil, true)
+			expectStatus(t, w, http.StatusOK)
+			var resp GetJobResponse
+			decodeBody(t, w, &resp)
+			return *resp.Job
+		}
+
+		api.app.processJob(stale)
+		if job := jobStatus(); job.Status != JobRunning || job.Attempts != 2 || job.FinishedAt != nil {
+			t.Fatalf("after the stale attempt: %+v, want still running attempt 2", job)
+		}
+
+		api.app.processJob(current)
+		if job := jobStatus(); job.Status != JobSucceeded || job.PageDataID == nil {
+			t.Errorf("after the current attempt: %+v", job)
+		}
+
+		expectStatus(t, api.do(t, http.MethodGet, "/api/v1/jobs/42", nil, true), http.StatusBadRequest)
+	})
+}
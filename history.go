@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PriceHistoryBucket - агрегированная точка истории цен за день или неделю
+type PriceHistoryBucket struct {
+	Bucket time.Time `json:"bucket"`
+	Min    float64   `json:"min"`
+	Max    float64   `json:"max"`
+	Avg    float64   `json:"avg"`
+	Last   float64   `json:"last"`
+	Count  int64     `json:"count"`
+}
+
+type GetPriceHistoryResponse struct {
+	Success  bool                 `json:"success"`
+	Product  *Product             `json:"product"`
+	Interval string               `json:"interval,omitempty"`
+	From     *time.Time           `json:"from,omitempty"`
+	To       *time.Time           `json:"to,omitempty"`
+	Points   []PriceObservation   `json:"points,omitempty"`
+	Buckets  []PriceHistoryBucket `json:"buckets,omitempty"`
+}
+
+// Обработчик для получения истории цен продукта по ID или URL
+func (app *Application) getPriceHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		app.respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	id := query.Get("id")
+	url := query.Get("url")
+
+	if id == "" && url == "" {
+		app.respondWithError(w, http.StatusBadRequest, "ID or URL parameter is required")
+		return
+	}
+	if id != "" && !isUUID(id) {
+		app.respondWithError(w, http.StatusBadRequest, "id must be a UUID")
+		return
+	}
+
+	// Интервал агрегации: пусто - сырые точки, day/week - свертка
+	interval := query.Get("interval")
+	if interval != "" && interval != "day" && interval != "week" {
+		app.respondWithError(w, http.StatusBadRequest, "interval must be day or week")
+		return
+	}
+	from, err := parseTimeParam(query.Get("from"))
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid from parameter")
+		return
+	}
+	to, err := parseTimeParam(query.Get("to"))
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid to parameter")
+		return
+	}
+	period := priceHistoryPeriod{From: from, To: to, WholeDay: isDateOnly(query.Get("to"))}
+
+	var product Product
+	if id != "" {
+		err = app.db.First(&product, "id = ?", id).Error
+	} else {
+		err = app.db.First(&product, "url = ?", url).Error
+	}
+
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			app.respondWithError(w, http.StatusNotFound, "Product not found")
+		} else {
+			log.Printf("Error getting product: %v", err)
+			app.respondWithError(w, http.StatusInternalServerError, "Failed to get product")
+		}
+		return
+	}
+
+	response := GetPriceHistoryResponse{
+		Success:  true,
+		Product:  &product,
+		Interval: interval,
+		From:     from,
+		To:       to,
+	}
+
+	if interval == "" {
+		response.Points, err = app.getPriceObservations(product.ID, period)
+	} else {
+		response.Buckets, err = app.getPriceHistoryBuckets(product.ID, interval, period)
+	}
+
+	if err != nil {
+		log.Printf("Error getting price history: %v", err)
+		app.respondWithError(w, http.StatusInternalServerError, "Failed to get price history")
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, response)
+}
+
+func (app *Application) getPriceObservations(productID string, period priceHistoryPeriod) ([]PriceObservation, error) {
+	points := []PriceObservation{}
+	err := priceHistoryScope(app.db.Model(&PriceObservation{}), productID, period).
+		Order("observed_at ASC").
+		Find(&points).Error
+	return points, err
+}
+
+func (app *Application) getPriceHistoryBuckets(productID, interval string, period priceHistoryPeriod) ([]PriceHistoryBucket, error) {
+	// В SQLite нет date_trunc и array_agg: свертка по сырым точкам
+	if !app.isPostgres() {
+		points, err := app.getPriceObservations(productID, period)
+		if err != nil {
+			return nil, err
+		}
+		return bucketPriceObservations(points, interval), nil
+	}
+
+	buckets := []PriceHistoryBucket{}
+	bucketExpr := fmt.Sprintf("date_trunc('%s', observed_at)", interval)
+	err := priceHistoryScope(app.db.Model(&PriceObservation{}), productID, period).
+		Select(bucketExpr + " AS bucket, MIN(price) AS min, MAX(price) AS max, AVG(price) AS avg, " +
+			"(array_agg(price ORDER BY observed_at DESC))[1] AS last, COUNT(*) AS count").
+		Group("bucket").
+		Order("bucket ASC").
+		Scan(&buckets).Error
+	return buckets, err
+}
+
+// Сворачивает точки, упорядоченные по observed_at, по дням или неделям (с понедельника) в UTC -
+// так же, как date_trunc в сессии Postgres с TimeZone=UTC
+func bucketPriceObservations(points []PriceObservation, interval string) []PriceHistoryBucket {
+	buckets := []PriceHistoryBucket{}
+	var sum float64
+	for _, p := range points {
+		t := p.ObservedAt.UTC()
+		start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		if interval == "week" {
+			start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+		}
+
+		if n := len(buckets); n == 0 || !buckets[n-1].Bucket.Equal(start) {
+			sum = 0
+			buckets = append(buckets, PriceHistoryBucket{Bucket: start, Min: p.Price, Max: p.Price})
+		}
+		b := &buckets[len(buckets)-1]
+		b.Min = math.Min(b.Min, p.Price)
+		b.Max = math.Max(b.Max, p.Price)
+		b.Last = p.Price
+		b.Count++
+		sum += p.Price
+		b.Avg = sum / float64(b.Count)
+	}
+	return buckets
+}
+
+// Период истории цен; обе границы включительные
+type priceHistoryPeriod struct {
+	From *time.Time
+	To   *time.Time
+	// To задан датой без времени: в период входит весь этот день
+	WholeDay bool
+}
+
+func priceHistoryScope(query *gorm.DB, productID string, period priceHistoryPeriod) *gorm.DB {
+	query = query.Where("product_id = ?", productID)
+	if period.From != nil {
+		query = query.Where("observed_at >= ?", *period.From)
+	}
+	switch {
+	case period.To == nil:
+	case period.WholeDay:
+		query = query.Where("observed_at < ?", period.To.Add(24*time.Hour))
+	default:
+		query = query.Where("observed_at <= ?", *period.To)
+	}
+	return query
+}
+
+// Дописывает по одному наблюдению цены на каждый продукт снимка
+func savePriceObservations(tx *gorm.DB, pageData *PageData) error {
+	if len(pageData.Products) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	observations := make([]PriceObservation, 0, len(pageData.Products))
+	for _, p := range pageData.Products {
+		observedAt := p.Timestamp
+		if observedAt.IsZero() {
+			observedAt = now
+		}
+		observations = append(observations, PriceObservation{
+			ProductID:  p.ID,
+			PageDataID: &pageData.ID,
+			Price:      p.Price,
+			OldPrice:   p.OldPrice,
+			Discount:   p.Discount,
+			Weight:     p.Weight,
+			Unit:       p.Unit,
+			Source:     p.Source,
+			ObservedAt: observedAt,
+		})
+	}
+
+	if err := tx.CreateInBatches(observations, 500).Error; err != nil {
+		return fmt.Errorf("ошибка сохранения истории цен: %w", err)
+	}
+	return nil
+}
+
+// Разбирает время в формате RFC3339 или YYYY-MM-DD, пустая строка - нет ограничения
+func parseTimeParam(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Значение, которое parseTimeParam разобрал как YYYY-MM-DD (RFC3339 длиннее)
+func isDateOnly(value string) bool {
+	return len(value) == len("2006-01-02")
+}
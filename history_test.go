@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBucketPriceObservations(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	points := []PriceObservation{
+		{Price: 100, ObservedAt: at("2024-03-04T09:00:00Z")}, // понедельник
+		{Price: 80, ObservedAt: at("2024-03-04T21:00:00Z")},
+		// 01:00 по Москве - еще 5 марта в UTC
+		{Price: 90, ObservedAt: at("2024-03-06T01:00:00+03:00")},
+		{Price: 120, ObservedAt: at("2024-03-10T23:00:00Z")}, // воскресенье
+		{Price: 110, ObservedAt: at("2024-03-11T08:00:00Z")},
+	}
+
+	tests := []struct {
+		interval string
+		want     []PriceHistoryBucket
+	}{
+		{"day", []PriceHistoryBucket{
+			{Bucket: at("2024-03-04T00:00:00Z"), Min: 80, Max: 100, Avg: 90, Last: 80, Count: 2},
+			{Bucket: at("2024-03-05T00:00:00Z"), Min: 90, Max: 90, Avg: 90, Last: 90, Count: 1},
+			{Bucket: at("2024-03-10T00:00:00Z"), Min: 120, Max: 120, Avg: 120, Last: 120, Count: 1},
+			{Bucket: at("2024-03-11T00:00:00Z"), Min: 110, Max: 110, Avg: 110, Last: 110, Count: 1},
+		}},
+		{"week", []PriceHistoryBucket{
+			{Bucket: at("2024-03-04T00:00:00Z"), Min: 80, Max: 120, Avg: 97.5, Last: 120, Count: 4},
+			{Bucket: at("2024-03-11T00:00:00Z"), Min: 110, Max: 110, Avg: 110, Last: 110, Count: 1},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.interval, func(t *testing.T) {
+			if got := bucketPriceObservations(points, tt.interval); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buckets = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if got := bucketPriceObservations(nil, "day"); len(got) != 0 || got == nil {
+		t.Errorf("no points: %#v", got)
+	}
+}
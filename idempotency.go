@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	// Заголовок ответа, повторенного из сохраненного
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	// Как часто удаляются истекшие ключи
+	idempotencyCleanupInterval = time.Hour
+	// Ответ больше этого размера не сохраняется (например, результаты большой пакетной загрузки):
+	// повтор с тем же ключом выполнит запрос заново
+	maxIdempotentResponseSize = 1 << 20
+	// Сколько тела, не прочитанного обработчиком, дочитывается ради хеша запроса
+	maxIdempotencyDrainSize = 1 << 20
+)
+
+// IdempotencyKey - ключ повторяемого запроса и сохраненный ответ на него.
+// StatusCode == 0 - запрос еще обрабатывается; если с StartedAt прошло больше idempotencyLease,
+// обработчик считается упавшим и ключ может занять повтор. RequestHash заполняется вместе
+// с ответом: тело хешируется по мере чтения обработчиком.
+type IdempotencyKey struct {
+	Owner        string    `gorm:"type:varchar(300);primaryKey"` // клиент: API-ключ или JWT subject
+	Key          string    `gorm:"type:varchar(255);primaryKey"`
+	RequestHash  string    `gorm:"type:char(64);not null"`
+	StatusCode   int       `gorm:"not null;default:0"`
+	ContentType  string    `gorm:"type:varchar(100)"`
+	ResponseBody []byte    `gorm:"type:bytea"`
+	StartedAt    time.Time `gorm:"type:timestamptz"` // когда ключ занят текущим обработчиком
+	CreatedAt    time.Time `gorm:"autoCreateTime"`
+	ExpiresAt    time.Time `gorm:"type:timestamptz;not null;index"`
+}
+
+// idempotencyRecorder пропускает ответ клиенту и запоминает его для сохранения
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+	discard    bool // ответ не сохраняется: больше maxIdempotentResponseSize или так решил обработчик
+}
+
+func (rec *idempotencyRecorder) WriteHeader(code int) {
+	rec.statusCode = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.statusCode == 0 {
+		rec.statusCode = http.StatusOK
+	}
+	if !rec.discard {
+		if rec.body.Len()+len(b) > maxIdempotentResponseSize {
+			rec.discard = true
+			rec.body = bytes.Buffer{}
+		} else {
+			rec.body.Write(b)
+		}
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+type idempotencyContextKey struct{}
+
+// Не сохранять ответ под Idempotency-Key: часть запроса не выполнена из-за ошибки сервера,
+// и повтор с тем же ключом должен выполнить ее заново, а не получить сохраненные ошибки
+func discardIdempotentResponse(r *http.Request) {
+	if rec, ok := r.Context().Value(idempotencyContextKey{}).(*idempotencyRecorder); ok {
+		rec.discard = true
+	}
+}
+
+// hashingBody считает хеш тела запроса по мере того, как его читает обработчик,
+// поэтому тело не нужно держать в памяти целиком
+type hashingBody struct {
+	io.ReadCloser
+	hash hash.Hash
+	eof  bool
+}
+
+func (b *hashingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.hash.Write(p[:n])
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+// Дочитывает то, что не прочитал обработчик, и возвращает хеш. false - тело не дочитано
+// до конца (длиннее maxIdempotencyDrainSize или ошибка чтения), и хешу верить нельзя.
+func (b *hashingBody) finish() (string, bool) {
+	if !b.eof {
+		io.Copy(io.Discard, io.LimitReader(b, maxIdempotencyDrainSize))
+	}
+	return hex.EncodeToString(b.hash.Sum(nil)), b.eof
+}
+
+// Клиент, в пределах которого уникален ключ
+func idempotencyOwner(principal *Principal) string {
+	if principal == nil {
+		return "anonymous"
+	}
+	if principal.APIKeyID != "" {
+		return "key:" + principal.APIKeyID
+	}
+	return "jwt:" + principal.Name
+}
+
+// Хеш запроса: метод, путь с параметрами и тело, которое дописывается в возвращенный хеш
+func newIdempotencyHash(r *http.Request) hash.Hash {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", r.Method, r.URL.RequestURI())
+	return h
+}
+
+// Middleware повторяемых запросов: при повторе с тем же Idempotency-Key и тем же телом
+// возвращает сохраненный ответ, с другим телом - 422, пока первый запрос выполняется - 409.
+// Должен стоять после authMiddleware.
+func (app *Application) idempotencyMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if app.db == nil {
+			app.respondNotSupported(w)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			app.respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s must be at most %d characters", idempotencyHeader, maxIdempotencyKeyLength))
+			return
+		}
+
+		// Точность - микросекунды, как у timestamptz: по StartedAt обработчик узнает свою запись
+		now := time.Now().Truncate(time.Microsecond)
+		record := IdempotencyKey{
+			Owner:     idempotencyOwner(principalFromContext(r)),
+			Key:       key,
+			StartedAt: now,
+			ExpiresAt: now.Add(app.idempotencyTTL),
+		}
+
+		acquired, existing, err := app.acquireIdempotencyKey(&record)
+		if err != nil {
+			log.Printf("Error checking idempotency key: %v", err)
+			app.respondWithError(w, http.StatusInternalServerError, "Failed to check idempotency key")
+			return
+		}
+
+		if !acquired {
+			if existing.StatusCode == 0 {
+				app.respondWithError(w, http.StatusConflict, "A request with this Idempotency-Key is still being processed")
+				return
+			}
+
+			h := newIdempotencyHash(r)
+			if _, err := io.Copy(h, r.Body); err != nil {
+				app.respondWithError(w, http.StatusBadRequest, "Failed to read request body")
+				return
+			}
+			switch {
+			case existing.RequestHash != hex.EncodeToString(h.Sum(nil)):
+				app.respondWithError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request body")
+			default:
+				if existing.ContentType != "" {
+					w.Header().Set("Content-Type", existing.ContentType)
+				}
+				w.Header().Set(idempotencyReplayedHeader, "true")
+				w.WriteHeader(existing.StatusCode)
+				w.Write(existing.ResponseBody)
+			}
+			return
+		}
+
+		body := &hashingBody{ReadCloser: r.Body, hash: newIdempotencyHash(r)}
+		r.Body = body
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		next(rec, r.WithContext(context.WithValue(r.Context(), idempotencyContextKey{}, rec)))
+		requestHash, hashed := body.finish()
+
+		// Если обработка заняла больше аренды и ключ уже занял повтор, его запись не трогаем
+		owned := app.db.Where("owner = ? AND key = ? AND started_at = ?", record.Owner, record.Key, record.StartedAt)
+
+		// Ошибки сервера не сохраняем, чтобы повтор мог пройти заново, как и ответ, который
+		// не сохранить целиком, и запрос, тело которого не дочитано до конца для хеша
+		if rec.statusCode == 0 || rec.statusCode >= http.StatusInternalServerError || rec.discard || !hashed {
+			if err := owned.Delete(&IdempotencyKey{}).Error; err != nil {
+				log.Printf("Error releasing idempotency key: %v", err)
+			}
+			return
+		}
+
+		err = owned.Model(&IdempotencyKey{}).
+			Updates(map[string]interface{}{
+				"request_hash":  requestHash,
+				"status_code":   rec.statusCode,
+				"content_type":  rec.Header().Get("Content-Type"),
+				"response_body": rec.body.Bytes(),
+			}).Error
+		if err != nil {
+			log.Printf("Error saving idempotent response: %v", err)
+		}
+	}
+}
+
+// Занимает ключ под новый запрос. Если ключ уже есть и не истек, возвращает его запись.
+func (app *Application) acquireIdempotencyKey(record *IdempotencyKey) (bool, *IdempotencyKey, error) {
+	var acquired bool
+	var existing IdempotencyKey
+
+	err := app.db.Transaction(func(tx *gorm.DB) error {
+		// Истекший ключ можно использовать заново, как и ключ, брошенный упавшим обработчиком
+		now := time.Now()
+		err := tx.Where("owner = ? AND key = ? AND (expires_at <= ? OR (status_code = 0 AND started_at <= ?))",
+			record.Owner, record.Key, now, now.Add(-app.idempotencyLease)).
+			Delete(&IdempotencyKey{}).Error
+		if err != nil {
+			return err
+		}
+
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 1 {
+			acquired = true
+			return nil
+		}
+
+		return tx.Where("owner = ? AND key = ?", record.Owner, record.Key).First(&existing).Error
+	})
+
+	return acquired, &existing, err
+}
+
+// Периодически удаляет истекшие ключи
+func (app *Application) startIdempotencyCleanup() {
+	go func() {
+		ticker := time.NewTicker(idempotencyCleanupInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			result := app.db.Where("expires_at <= ?", time.Now()).Delete(&IdempotencyKey{})
+			if result.Error != nil {
+				log.Printf("Error deleting expired idempotency keys: %v", result.Error)
+			} else if result.RowsAffected > 0 {
+				log.Printf("Deleted %d expired idempotency keys", result.RowsAffected)
+			}
+		}
+	}()
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// importer загружает PageData из файлов тем же путем, что и POST /api/v1/page-data:
+// проверка, заполнение значений по умолчанию и Store.SavePageData
+type importer struct {
+	store     Store // nil при -dry-run: записи только проверяются
+	userAgent string
+
+	imported int
+	rejected int
+}
+
+// Подкоманда import: файлы JSON (объект или массив PageData) и NDJSON, "-" - stdin
+func runImportCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	userAgent := fs.String("user-agent", "simple-api import", "userAgent для записей, в которых он не указан")
+	dryRun := fs.Bool("dry-run", false, "только проверить записи, ничего не сохраняя")
+
+	config, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return usageErrorf("использование: import [флаги] <файл.json|файл.ndjson|-> ...")
+	}
+
+	im := &importer{userAgent: *userAgent}
+	if !*dryRun {
+		db, err := openCommandDatabase(config)
+		if err != nil {
+			return err
+		}
+		im.store = newStore(config, db)
+	}
+
+	for _, name := range fs.Args() {
+		if err := im.importFile(name); err != nil {
+			return fmt.Errorf("%w (сохранено %d записей)", err, im.imported)
+		}
+	}
+
+	verb := "imported"
+	if *dryRun {
+		verb = "valid"
+	}
+	fmt.Printf("%s %d, rejected %d\n", verb, im.imported, im.rejected)
+	if im.rejected > 0 {
+		return fmt.Errorf("%w: %d", errPartial, im.rejected)
+	}
+	return nil
+}
+
+// Загружает один файл. Непрочитанный файл и отклоненные записи учитываются в rejected;
+// ошибка возвращается, только если не удалось сохранить запись - продолжать нет смысла.
+func (im *importer) importFile(name string) error {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			log.Printf("%v", err)
+			im.rejected++
+			return nil
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var saveErr error
+	err := decodePageDataStream(r, func(n int, raw json.RawMessage) error {
+		saveErr = im.importRecord(name, n, raw)
+		return saveErr
+	})
+	if saveErr != nil {
+		return saveErr
+	}
+	if err != nil {
+		log.Printf("%s: чтение прервано: %v", name, err)
+		im.rejected++
+	}
+	return nil
+}
+
+func (im *importer) importRecord(name string, n int, raw json.RawMessage) error {
+	var pageData PageData
+	if err := json.Unmarshal(raw, &pageData); err != nil {
+		log.Printf("%s: запись %d: %s", name, n, summarizeErrors([]FieldError{decodeError(err)}))
+		im.rejected++
+		return nil
+	}
+	if errs := validatePageData(&pageData); len(errs) > 0 {
+		log.Printf("%s: запись %d: %s", name, n, summarizeErrors(errs))
+		im.rejected++
+		return nil
+	}
+
+	normalizePageData(&pageData, im.userAgent)
+	if im.store != nil {
+		if err := im.store.SavePageData(&pageData); err != nil {
+			return fmt.Errorf("%s: запись %d: не удалось сохранить: %w", name, n, err)
+		}
+	}
+	im.imported++
+	return nil
+}
+
+// Читает записи PageData из r: массив JSON или объекты подряд (один объект, NDJSON).
+// Записи не загружаются в память целиком; fn получает номер записи с 1.
+func decodePageDataStream(r io.Reader, fn func(n int, raw json.RawMessage) error) error {
+	br := bufio.NewReader(r)
+	first, err := peekNonSpace(br)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(br)
+	n := 0
+	next := func() error {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		n++
+		return fn(n, raw)
+	}
+
+	if first != '[' {
+		for {
+			if err := next(); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	for dec.More() {
+		if err := next(); err != nil {
+			return err
+		}
+	}
+	_, err = dec.Token()
+	return err
+}
+
+// Первый непробельный байт, не извлекая его из буфера
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			br.ReadByte()
+		default:
+			return b[0], nil
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Состояния задачи асинхронного сохранения
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+const (
+	// Задача в состоянии running дольше этого срока считается брошенной (воркер упал) и берется заново
+	jobVisibilityTimeout = 10 * time.Minute
+	// Потолок экспоненциальной задержки между попытками
+	maxJobRetryBackoff = time.Hour
+)
+
+// IngestJob - отложенное сохранение PageData. Payload - уже проверенные и нормализованные данные,
+// а не тело запроса как есть: нормализация подставляет User-Agent запроса и время приема,
+// и воркер, повторив ее позже, записал бы время обработки и потерял бы заголовок.
+type IngestJob struct {
+	ID          string          `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	Status      string          `json:"status" gorm:"type:varchar(20);not null;index"`
+	Payload     json.RawMessage `json:"-" gorm:"type:jsonb;not null"`
+	PageURL     string          `json:"pageUrl" gorm:"type:text"`
+	Principal   string          `json:"-" gorm:"type:varchar(255)"`
+	Attempts    int             `json:"attempts" gorm:"not null;default:0"`
+	MaxAttempts int             `json:"maxAttempts" gorm:"not null"`
+	LastError   string          `json:"lastError,omitempty" gorm:"type:text"`
+	PageDataID  *string         `json:"pageDataId,omitempty" gorm:"type:uuid"`
+	NextRunAt   time.Time       `json:"nextRunAt" gorm:"type:timestamptz;not null;index"`
+	StartedAt   *time.Time      `json:"startedAt,omitempty" gorm:"type:timestamptz"`
+	FinishedAt  *time.Time      `json:"finishedAt,omitempty" gorm:"type:timestamptz"`
+	CreatedAt   time.Time       `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt   time.Time       `json:"updatedAt" gorm:"autoUpdateTime"`
+}
+
+type EnqueuePageDataResponse struct {
+	Success   bool   `json:"success"`
+	Message   string `json:"message"`
+	JobID     string `json:"jobId"`
+	Status    string `json:"status"`
+	StatusURL string `json:"statusUrl"`
+}
+
+type GetJobResponse struct {
+	Success bool       `json:"success"`
+	Job     *IngestJob `json:"job"`
+}
+
+// jobWorkerConfig - параметры пула воркеров
+type jobWorkerConfig struct {
+	Workers      int
+	MaxAttempts  int
+	RetryBackoff time.Duration
+	PollInterval time.Duration
+}
+
+// Ставит проверенные данные в очередь и отвечает 202 со ссылкой на статус задачи
+func (app *Application) enqueuePageData(w http.ResponseWriter, r *http.Request, pageData *PageData) {
+	payload, err := json.Marshal(pageData)
+	if err != nil {
+		log.Printf("Error encoding job payload: %v", err)
+		app.respondWithError(w, http.StatusInternalServerError, "Failed to enqueue page data")
+		return
+	}
+
+	principal := ""
+	if p := principalFromContext(r); p != nil {
+		principal = p.Name
+	}
+
+	job := IngestJob{
+		Status:      JobQueued,
+		Payload:     payload,
+		PageURL:     pageData.URL,
+		Principal:   principal,
+		MaxAttempts: app.jobs.MaxAttempts,
+		NextRunAt:   time.Now(),
+	}
+	if err := app.db.Create(&job).Error; err != nil {
+		log.Printf("Error enqueuing page data: %v", err)
+		app.respondWithError(w, http.StatusInternalServerError, "Failed to enqueue page data")
+		return
+	}
+
+	statusURL := "/api/v1/jobs/" + job.ID
+	w.Header().Set("Location", statusURL)
+	app.respondWithJSON(w, http.StatusAccepted, EnqueuePageDataResponse{
+		Success:   true,
+		Message:   "Page data accepted for processing",
+		JobID:     job.ID,
+		Status:    job.Status,
+		StatusURL: statusURL,
+	})
+}
+
+// Обработчик статуса задачи
+func (app *Application) getJobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		app.respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	id := r.PathValue("id")
+	if !isUUID(id) {
+		app.respondWithError(w, http.StatusBadRequest, "id must be a UUID")
+		return
+	}
+
+	var job IngestJob
+	if err := app.db.Omit("Payload").First(&job, "id = ?", id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			app.respondWithError(w, http.StatusNotFound, "Job not found")
+		} else {
+			log.Printf("Error getting job: %v", err)
+			app.respondWithError(w, http.StatusInternalServerError, "Failed to get job")
+		}
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, GetJobResponse{
+		Success: true,
+		Job:     &job,
+	})
+}
+
+// Запускает пул воркеров; Workers <= 0 отключает асинхронную обработку в этом процессе
+func (app *Application) startJobWorkers() {
+	for i := 0; i < app.jobs.Workers; i++ {
+		go app.runJobWorker()
+	}
+}
+
+func (app *Application) runJobWorker() {
+	for {
+		job, err := app.claimJob()
+		if err != nil {
+			log.Printf("Error claiming ingest job: %v", err)
+		}
+		if job == nil {
+			time.Sleep(app.jobs.PollInterval)
+			continue
+		}
+		app.processJob(job)
+	}
+}
+
+// Забирает следующую готовую задачу. В Postgres SKIP LOCKED позволяет воркерам разных процессов
+// не мешать друг другу; в SQLite запись и так идет через одно соединение и UPDATE атомарен.
+func (app *Application) claimJob() (*IngestJob, error) {
+	lock := ""
+	if app.isPostgres() {
+		lock = "FOR UPDATE SKIP LOCKED"
+	}
+
+	now := time.Now()
+	var jobs []IngestJob
+	err := app.db.Raw(`UPDATE ingest_jobs SET status = ?, attempts = attempts + 1, started_at = ?, updated_at = ?
+		WHERE id = (
+			SELECT id FROM ingest_jobs
+			WHERE (status = ? AND next_run_at <= ?) OR (status = ? AND started_at < ?)
+			ORDER BY next_run_at
+			LIMIT 1
+			`+lock+`
+		)
+		RETURNING *`, JobRunning, now, now, JobQueued, now, JobRunning, now.Add(-jobVisibilityTimeout)).
+		Scan(&jobs).Error
+	if err != nil || len(jobs) == 0 {
+		return nil, err
+	}
+	return &jobs[0], nil
+}
+
+func (app *Application) processJob(job *IngestJob) {
+	var pageData PageData
+	err := json.Unmarshal(job.Payload, &pageData)
+	if err == nil {
+		err = app.store.SavePageData(&pageData)
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{"updated_at": now}
+
+	switch {
+	case err == nil:
+		updates["status"] = JobSucceeded
+		updates["page_data_id"] = pageData.ID
+		updates["last_error"] = ""
+		updates["finished_at"] = now
+	case job.Attempts < job.MaxAttempts && !isPermanentJobError(err):
+		backoff := jobRetryBackoff(app.jobs.RetryBackoff, job.Attempts)
+		log.Printf("Ingest job %s failed (attempt %d/%d), retrying in %s: %v", job.ID, job.Attempts, job.MaxAttempts, backoff, err)
+		updates["status"] = JobQueued
+		updates["last_error"] = err.Error()
+		updates["next_run_at"] = now.Add(backoff)
+	default:
+		log.Printf("Ingest job %s failed permanently after %d attempts: %v", job.ID, job.Attempts, err)
+		updates["status"] = JobFailed
+		updates["last_error"] = err.Error()
+		updates["finished_at"] = now
+	}
+
+	// Если попытка длилась дольше jobVisibilityTimeout, задачу мог забрать другой воркер:
+	// attempts у нее уже другой, и итог этой попытки не должен затереть его статус
+	result := app.db.Model(&IngestJob{}).
+		Where("id = ? AND status = ? AND attempts = ?", job.ID, JobRunning, job.Attempts).
+		Updates(updates)
+	if result.Error != nil {
+		log.Printf("Error updating ingest job %s: %v", job.ID, result.Error)
+	} else if result.RowsAffected == 0 {
+		log.Printf("Ingest job %s was reclaimed by another worker, result of attempt %d discarded", job.ID, job.Attempts)
+	}
+}
+
+// Испорченный payload повтором не исправить
+func isPermanentJobError(err error) bool {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	return errors.As(err, &syntaxErr) || errors.As(err, &typeErr)
+}
+
+// Экспоненциальная задержка: base, 2*base, 4*base... не больше maxJobRetryBackoff
+func jobRetryBackoff(base time.Duration, attempt int) time.Duration {
+	backoff := base
+	for i := 1; i < attempt && backoff < maxJobRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxJobRetryBackoff {
+		backoff = maxJobRetryBackoff
+	}
+	return backoff
+}
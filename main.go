@@ -46,12 +46,13 @@ package main
 import (
 	"database/sql/driver"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
-	"strings"
 	"time"
 
 	"gorm.io/driver/postgres"
@@ -1225,10 +1226,23 @@ type Product struct {
 	Timestamp   time.Time `json:"timestamp" gorm:"type:timestamptz;index"`
 	Unit        string    `json:"unit" gorm:"type:varchar(50)"`
 	URL         string    `json:"url" gorm:"type:text;uniqueIndex"`
-	Weight      *float64  `json:"weight,omitempty" gorm:"type:decimal(10,2)"`
+	Weight      *float64  `json:"weight,omitempty" gorm:"type:decimal(11,3)"`
 	CreatedAt   time.Time `json:"createdAt" gorm:"autoCreateTime"`
 	UpdatedAt   time.Time `json:"updatedAt" gorm:"autoUpdateTime"`
 	PageDataID  *string   `json:"-" gorm:"type:uuid;index"`
+	GTIN        string    `json:"gtin,omitempty" gorm:"type:varchar(14);index"`
+	SKU         string    `json:"sku,omitempty" gorm:"type:varchar(100)"`
+
+	// Цена за кг, литр или штуку (см. UnitDimension), считается при сохранении
+	UnitPrice     *float64 `json:"unitPrice,omitempty" gorm:"type:decimal(12,2);index"`
+	UnitDimension string   `json:"unitDimension,omitempty" gorm:"type:varchar(10);index"`
+
+	// supplied - вес и единицу прислал парсер, inferred - извлечены из названия
+	WeightSource string `json:"weightSource,omitempty" gorm:"type:varchar(10)"`
+	UnitSource   string `json:"unitSource,omitempty" gorm:"type:varchar(10)"`
+
+	CanonicalProductID *string `json:"canonicalProductId,omitempty" gorm:"type:uuid;index"`
+	CanonicalLocked    bool    `json:"-" gorm:"not null;default:false"` // привязка задана вручную
 }
 
 type Stats struct {
@@ -1257,16 +1271,59 @@ func (s *Stats) Scan(value interface{}) error {
 
 type PageData struct {
 	ID        string    `json:"id,omitempty" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	PageID    *string   `json:"pageId,omitempty" gorm:"type:uuid;index"`
 	PageInfo  PageInfo  `json:"pageInfo" gorm:"type:jsonb"`
 	PageTitle string    `json:"pageTitle" gorm:"type:text"`
 	Products  []Product `json:"products" gorm:"foreignKey:PageDataID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
 	Stats     Stats     `json:"stats" gorm:"type:jsonb"`
 	Success   bool      `json:"success" gorm:"default:true"`
 	Timestamp string    `json:"timestamp" gorm:"type:text"`
-	URL       string    `json:"url" gorm:"type:text;uniqueIndex"`
+	URL       string    `json:"url" gorm:"type:text"`
 	UserAgent string    `json:"userAgent" gorm:"type:text"`
 	CreatedAt time.Time `json:"createdAt" gorm:"autoCreateTime"`
 	UpdatedAt time.Time `json:"updatedAt" gorm:"autoUpdateTime"`
+
+	// Stats пересчитывается сервером; присланная парсером статистика хранится для сравнения
+	ReportedStats   *ReportedStats `json:"reportedStats,omitempty" gorm:"type:jsonb"`
+	StatsDivergent  bool           `json:"statsDivergent" gorm:"not null;default:false;index"`
+	StatsDivergence string         `json:"statsDivergence,omitempty" gorm:"type:text"` // расходящиеся поля через запятую
+}
+
+// Page - стабильная запись о странице категории, к которой привязаны все её снимки (PageData)
+type Page struct {
+	ID            string    `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	URL           string    `json:"url" gorm:"type:text;uniqueIndex;not null"`
+	Title         string    `json:"title" gorm:"type:text"`
+	SnapshotCount int64     `json:"snapshotCount" gorm:"not null;default:0"`
+	FirstSeenAt   time.Time `json:"firstSeenAt" gorm:"type:timestamptz"`
+	LastSeenAt    time.Time `json:"lastSeenAt" gorm:"type:timestamptz"`
+	CreatedAt     time.Time `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt     time.Time `json:"updatedAt" gorm:"autoUpdateTime"`
+}
+
+// SnapshotProduct - состав снимка: какой продукт и по какой цене был на странице в момент парсинга
+type SnapshotProduct struct {
+	PageDataID string   `gorm:"type:uuid;primaryKey"`
+	Position   int      `gorm:"primaryKey"`
+	ProductID  string   `gorm:"type:uuid;not null;index"`
+	Price      float64  `gorm:"type:decimal(10,2);not null"`
+	OldPrice   *float64 `gorm:"type:decimal(10,2)"`
+	Discount   *float64 `gorm:"type:decimal(10,2)"`
+}
+
+// PriceObservation - неизменяемая запись о цене продукта, дописывается при каждом сохранении.
+// PageDataID - снимок, в котором цена замечена; nil, если снимок удален prune, а история осталась.
+type PriceObservation struct {
+	ID         uint64    `json:"-" gorm:"primaryKey;autoIncrement"`
+	ProductID  string    `json:"productId" gorm:"type:uuid;not null;index"`
+	PageDataID *string   `json:"pageDataId,omitempty" gorm:"type:uuid;index"`
+	Price      float64   `json:"price" gorm:"type:decimal(10,2);not null"`
+	OldPrice   *float64  `json:"oldPrice,omitempty" gorm:"type:decimal(10,2)"`
+	Discount   *float64  `json:"discount,omitempty" gorm:"type:decimal(10,2)"`
+	Weight     *float64  `json:"weight,omitempty" gorm:"type:decimal(11,3)"`
+	Unit       string    `json:"unit" gorm:"type:varchar(50)"`
+	Source     string    `json:"source" gorm:"type:varchar(100)"`
+	ObservedAt time.Time `json:"observedAt" gorm:"type:timestamptz;not null"`
 }
 
 // Request/Response структуры
@@ -1274,6 +1331,7 @@ type SavePageDataResponse struct {
 	Success   bool   `json:"success"`
 	Message   string `json:"message"`
 	ID        string `json:"id,omitempty"`
+	PageID    string `json:"pageId,omitempty"`
 	CreatedAt string `json:"createdAt,omitempty"`
 }
 
@@ -1303,38 +1361,70 @@ type ErrorResponse struct {
 	Error   string `json:"error"`
 }
 
-// Конфигурация
+// Конфигурация (заполняется loadConfig из флагов, переменных окружения и .env)
 type Config struct {
-	Host     string `json:"host"`
-	Port     int    `json:"port"`
-	User     string `json:"user"`
-	Password string `json:"password"`
-	DBName   string `json:"dbname"`
-	SSLMode  string `json:"sslmode"`
-	APIPort  string `json:"apiPort"`
+	Host      string `json:"host"`
+	Port      int    `json:"port"`
+	User      string `json:"user"`
+	Password  string `json:"password"`
+	DBName    string `json:"dbname"`
+	SSLMode   string `json:"sslmode"`
+	APIPort   int    `json:"apiPort"`
+	Env       string `json:"env"`
+	JWTSecret string `json:"jwtSecret"`
+	JWTIssuer string `json:"jwtIssuer"`
+	// Требовать скоуп read на эндпоинтах чтения; по умолчанию они публичные
+	RequireReadAuth bool `json:"requireReadAuth"`
+	// Период фоновой привязки продуктов к каноническим товарам; 0 - отключена
+	CanonicalMatchInterval time.Duration `json:"canonicalMatchInterval"`
+	// Срок хранения ответов на запросы с Idempotency-Key
+	IdempotencyTTL time.Duration `json:"idempotencyTtl"`
+	// Сколько запрос может удерживать Idempotency-Key; после этого ключ считается брошенным
+	// (процесс упал, не дописав ответ) и его может занять повтор
+	IdempotencyLease time.Duration `json:"idempotencyLease"`
+	// Асинхронное сохранение: число воркеров (0 - не обрабатывать очередь в этом процессе),
+	// попытки, начальная задержка повтора и период опроса очереди
+	JobWorkers      int           `json:"jobWorkers"`
+	JobMaxAttempts  int           `json:"jobMaxAttempts"`
+	JobRetryBackoff time.Duration `json:"jobRetryBackoff"`
+	JobPollInterval time.Duration `json:"jobPollInterval"`
+	// Хранилище: postgres или sqlite (файл SQLitePath) для одного узла и офлайн-работы
+	DBDriver   string `json:"dbDriver"`
+	SQLitePath string `json:"sqlitePath"`
+	// Сроки хранения для подкоманды prune: снимки и история цен; 0 - хранить всегда
+	SnapshotRetention     time.Duration `json:"snapshotRetention"`
+	PriceHistoryRetention time.Duration `json:"priceHistoryRetention"`
 }
 
 var (
-	cfg = Config{
-		Host:     "jadatabmarel.beget.app",
-		Port:     5432,
-		User:     "cloud_user",
-		Password: "wKtK%vSXgci7",
-		DBName:   "api_db",
-		SSLMode:  "disable",
-		APIPort:  "8080",
-	}
+	cfg Config
 
 	db *gorm.DB
 )
 
 // Application структура с HTTP обработчиками
 type Application struct {
-	db *gorm.DB
+	store            Store
+	db               *gorm.DB        // для возможностей вне Store; nil у MemoryStore
+	idempotencyTTL   time.Duration   // сколько хранится ответ на запрос с Idempotency-Key
+	idempotencyLease time.Duration   // через сколько необработанный ключ может занять повтор
+	jobs             jobWorkerConfig // пул воркеров асинхронного сохранения
 }
 
-func NewApplication(db *gorm.DB) *Application {
-	return &Application{db: db}
+func NewApplication(store Store) *Application {
+	app := &Application{
+		store:            store,
+		idempotencyTTL:   defaultConfig.IdempotencyTTL,
+		idempotencyLease: defaultConfig.IdempotencyLease,
+		jobs:             defaultConfig.jobWorkerConfig(),
+	}
+	switch s := store.(type) {
+	case *PostgresStore:
+		app.db = s.db
+	case *SQLiteStore:
+		app.db = s.db
+	}
+	return app
 }
 
 // HTTP Handlers
@@ -1344,64 +1434,41 @@ func (app *Application) savePageDataHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Проверяем Content-Type
-	contentType := r.Header.Get("Content-Type")
-	if contentType != "application/json" {
-		app.respondWithError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
-		return
-	}
-
-	var pageData PageData
-
-	// Декодируем тело запроса напрямую в PageData (без обертки pageData)
-	if err := json.NewDecoder(r.Body).Decode(&pageData); err != nil {
-		fmt.Println(err)
-		app.respondWithError(w, http.StatusBadRequest, "Invalid JSON format")
+	// Тело запроса - сам PageData (без обертки pageData)
+	pageData, errs, ok := app.decodePageDataRequest(w, r)
+	if !ok {
 		return
 	}
-
-	// Валидация
-	if pageData.URL == "" {
-		app.respondWithError(w, http.StatusBadRequest, "URL is required")
+	if len(errs) > 0 {
+		app.respondWithValidationErrors(w, errs)
 		return
 	}
 
-	if len(pageData.Products) == 0 {
-		app.respondWithError(w, http.StatusBadRequest, "At least one product is required")
+	// Ключ, выданный конкретному парсеру, может писать только свой источник
+	if err := checkSourceRestriction(principalFromContext(r), pageData); err != nil {
+		app.respondWithError(w, http.StatusForbidden, err.Error())
 		return
 	}
 
-	// Устанавливаем timestamp если не указан
-	if pageData.Timestamp == "" {
-		pageData.Timestamp = time.Now().Format(time.RFC3339)
-	}
-
-	// Устанавливаем UserAgent из заголовков если не указан
-	if pageData.UserAgent == "" {
-		pageData.UserAgent = r.UserAgent()
-	}
+	// Заполняем значения по умолчанию
+	normalizePageData(pageData, r.UserAgent())
 
-	// Устанавливаем PageTitle для каждого продукта если не указан
-	for i := range pageData.Products {
-		if pageData.Products[i].PageTitle == "" {
-			pageData.Products[i].PageTitle = pageData.PageTitle
-		}
-		if pageData.Products[i].PageURL == "" {
-			pageData.Products[i].PageURL = pageData.URL
+	// Большие страницы можно сохранить в фоне: ответ 202 с ID задачи
+	if r.URL.Query().Get("async") == "true" {
+		if app.db == nil {
+			app.respondNotSupported(w)
+			return
 		}
+		app.enqueuePageData(w, r, pageData)
+		return
 	}
 
 	// Сохраняем данные
-	err := app.savePageData(&pageData)
+	err := app.store.SavePageData(pageData)
 	if err != nil {
 		log.Printf("Error saving page data: %v", err)
 
-		// Проверяем конкретные ошибки
-		if strings.Contains(err.Error(), "duplicate key") {
-			app.respondWithError(w, http.StatusConflict, "Page data already exists")
-		} else {
-			app.respondWithError(w, http.StatusInternalServerError, "Failed to save page data")
-		}
+		app.respondWithError(w, http.StatusInternalServerError, "Failed to save page data")
 		return
 	}
 
@@ -1410,6 +1477,7 @@ func (app *Application) savePageDataHandler(w http.ResponseWriter, r *http.Reque
 		Success:   true,
 		Message:   "Page data saved successfully",
 		ID:        pageData.ID,
+		PageID:    derefString(pageData.PageID),
 		CreatedAt: pageData.CreatedAt.Format(time.RFC3339),
 	}
 
@@ -1428,21 +1496,19 @@ func (app *Application) getPageDataHandler(w http.ResponseWriter, r *http.Reques
 	page := app.getQueryInt(query, "page", 1)
 	perPage := app.getQueryInt(query, "per_page", 20)
 
-	var pageDataList []PageData
-	var total int64
-
-	// Подсчет общего количества
-	app.db.Model(&PageData{}).Count(&total)
-
-	// Получаем данные с пагинацией
-	offset := (page - 1) * perPage
-	err := app.db.
-		Preload("Products").
-		Order("created_at DESC").
-		Offset(offset).
-		Limit(perPage).
-		Find(&pageDataList).Error
+	// Фильтр по расхождению присланной статистики с пересчитанной
+	pageDataQuery := PageDataQuery{Page: page, PerPage: perPage, StatsField: query.Get("stats_field")}
+	switch query.Get("stats_divergent") {
+	case "":
+	case "true", "false":
+		divergent := query.Get("stats_divergent") == "true"
+		pageDataQuery.StatsDivergent = &divergent
+	default:
+		app.respondWithError(w, http.StatusBadRequest, "stats_divergent must be true or false")
+		return
+	}
 
+	pageDataList, _, err := app.store.ListPageData(pageDataQuery)
 	if err != nil {
 		log.Printf("Error getting page data: %v", err)
 		app.respondWithError(w, http.StatusInternalServerError, "Failed to get page data")
@@ -1472,18 +1538,22 @@ func (app *Application) getProductHandler(w http.ResponseWriter, r *http.Request
 		app.respondWithError(w, http.StatusBadRequest, "ID or URL parameter is required")
 		return
 	}
+	if id != "" && !isUUID(id) {
+		app.respondWithError(w, http.StatusBadRequest, "id must be a UUID")
+		return
+	}
 
-	var product Product
+	var product *Product
 	var err error
 
 	if id != "" {
-		err = app.db.First(&product, "id = ?", id).Error
+		product, err = app.store.GetProductByID(id)
 	} else {
-		err = app.db.First(&product, "url = ?", url).Error
+		product, err = app.store.GetProductByURL(url)
 	}
 
 	if err != nil {
-		if err == gorm.ErrRecordNotFound {
+		if errors.Is(err, ErrNotFound) {
 			app.respondWithError(w, http.StatusNotFound, "Product not found")
 		} else {
 			log.Printf("Error getting product: %v", err)
@@ -1494,12 +1564,22 @@ func (app *Application) getProductHandler(w http.ResponseWriter, r *http.Request
 
 	response := GetProductsResponse{
 		Success: true,
-		Product: &product,
+		Product: product,
 	}
 
 	app.respondWithJSON(w, http.StatusOK, response)
 }
 
+// Допустимые варианты сортировки продуктов категории
+var categorySortOrders = map[string]string{
+	"":                "created_at DESC",
+	"newest":          "created_at DESC",
+	"price_asc":       "price ASC",
+	"price_desc":      "price DESC",
+	"unit_price_asc":  "unit_price ASC NULLS LAST, price ASC",
+	"unit_price_desc": "unit_price DESC NULLS LAST, price DESC",
+}
+
 // Обработчик для получения продуктов по page_url (категория)
 func (app *Application) getCategoryHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -1515,25 +1595,29 @@ func (app *Application) getCategoryHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	sortKey := query.Get("sort")
+	if _, ok := categorySortOrders[sortKey]; !ok {
+		app.respondWithError(w, http.StatusBadRequest, "sort must be one of newest, price_asc, price_desc, unit_price_asc, unit_price_desc")
+		return
+	}
+
+	filter := app.parseSearchFilter(query)
+	if err := filter.validate(); err != nil {
+		app.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	// Параметры пагинации
 	page := app.getQueryInt(query, "page", 1)
 	perPage := app.getQueryInt(query, "per_page", 20)
 
-	var products []Product
-	var total int64
-
-	// Подсчет общего количества
-	app.db.Model(&Product{}).Where("page_url = ?", pageURL).Count(&total)
-
-	// Получаем продукты с пагинацией
-	offset := (page - 1) * perPage
-	err := app.db.
-		Where("page_url = ?", pageURL).
-		Order("created_at DESC").
-		Offset(offset).
-		Limit(perPage).
-		Find(&products).Error
-
+	// Получаем продукты с пагинацией и общее количество
+	products, total, err := app.store.ListProducts(ProductQuery{
+		Filter:  filter,
+		Sort:    sortKey,
+		Page:    page,
+		PerPage: perPage,
+	})
 	if err != nil {
 		log.Printf("Error getting category products: %v", err)
 		app.respondWithError(w, http.StatusInternalServerError, "Failed to get category products")
@@ -1591,13 +1675,27 @@ func (app *Application) getQueryInt(query map[string][]string, key string, defau
 	return intValue
 }
 
+func (app *Application) getQueryFloat(query map[string][]string, key string, defaultValue float64) float64 {
+	values, ok := query[key]
+	if !ok || len(values) == 0 {
+		return defaultValue
+	}
+
+	floatValue, err := strconv.ParseFloat(values[0], 64)
+	if err != nil {
+		return defaultValue
+	}
+
+	return floatValue
+}
+
 // CORS middleware
 func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Устанавливаем заголовки CORS
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, Idempotency-Key")
 		w.Header().Set("Access-Control-Max-Age", "3600")
 
 		// Обработка preflight запросов
@@ -1610,65 +1708,121 @@ func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-// Методы работы с данными
-func (app *Application) savePageData(pageData *PageData) error {
-	return app.db.Transaction(func(tx *gorm.DB) error {
-		// Сначала сохраняем PageData
-		if err := tx.Create(pageData).Error; err != nil {
-			return fmt.Errorf("ошибка сохранения PageData: %w", err)
-		}
+// Заполняет незаданные поля PageData и продуктов значениями по умолчанию
+func normalizePageData(pageData *PageData, userAgent string) {
+	// Устанавливаем timestamp если не указан
+	if pageData.Timestamp == "" {
+		pageData.Timestamp = time.Now().Format(time.RFC3339)
+	}
+
+	// Устанавливаем UserAgent из заголовков если не указан
+	if pageData.UserAgent == "" {
+		pageData.UserAgent = userAgent
+	}
 
-		// Затем сохраняем продукты
-		for i := range pageData.Products {
-			pageData.Products[i].PageDataID = &pageData.ID
-
-			// Проверяем существование продукта
-			var existingProduct Product
-			err := tx.Where("url = ?", pageData.Products[i].URL).First(&existingProduct).Error
-
-			if err == nil {
-				// Обновляем существующий
-				pageData.Products[i].ID = existingProduct.ID
-				if err := tx.Save(&pageData.Products[i]).Error; err != nil {
-					return fmt.Errorf("ошибка обновления продукта: %w", err)
-				}
-			} else if err == gorm.ErrRecordNotFound {
-				// Создаем новый
-				if err := tx.Create(&pageData.Products[i]).Error; err != nil {
-					return fmt.Errorf("ошибка создания продукта: %w", err)
-				}
-			} else {
-				return fmt.Errorf("ошибка проверки продукта: %w", err)
-			}
+	// Устанавливаем PageTitle для каждого продукта если не указан
+	for i := range pageData.Products {
+		if pageData.Products[i].PageTitle == "" {
+			pageData.Products[i].PageTitle = pageData.PageTitle
+		}
+		if pageData.Products[i].PageURL == "" {
+			pageData.Products[i].PageURL = pageData.URL
 		}
+		pageData.Products[i].inferSize()
+		pageData.Products[i].applyUnitPrice()
+	}
 
-		return nil
-	})
+	pageData.verifyStats()
+}
+
+// Методы работы с данными
+// Сохраняет снимок в рамках переданной транзакции
+func savePageDataTx(tx *gorm.DB, pageData *PageData) error {
+	// Находим или создаем стабильную запись страницы
+	pageID, err := upsertPage(tx, pageData)
+	if err != nil {
+		return err
+	}
+	pageData.PageID = &pageID
+
+	// Предыдущий снимок страницы нужен для определения возврата товара в наличие
+	prevSnapshotID, err := previousSnapshotID(tx, pageID)
+	if err != nil {
+		return err
+	}
+
+	// Каждый POST сохраняется как новый снимок; продукты сохраняем ниже отдельно
+	if err := tx.Omit("Products").Create(pageData).Error; err != nil {
+		return fmt.Errorf("ошибка сохранения PageData: %w", err)
+	}
+
+	// Затем сохраняем продукты пачками через INSERT ... ON CONFLICT (url) DO UPDATE
+	changes, err := upsertProducts(tx, pageData, prevSnapshotID)
+	if err != nil {
+		return err
+	}
+
+	// Фиксируем состав снимка
+	if err := saveSnapshotProducts(tx, pageData); err != nil {
+		return err
+	}
+
+	// Дописываем наблюдения цен в историю
+	if err := savePriceObservations(tx, pageData); err != nil {
+		return err
+	}
+
+	if err := savePriceChanges(tx, pageData, changes); err != nil {
+		return err
+	}
+
+	// Сравниваем снимок с базовой линией страницы, чтобы заметить сломавшийся парсер
+	return detectQualityAnomalies(tx, pageData)
 }
 
-// Инициализация базы данных
+// Подключается к базе и проверяет, что схема не отстает от миграций
 func initDatabase() (*gorm.DB, error) {
-	dsn := fmt.Sprintf(
-		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s TimeZone=UTC",
-		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.DBName, cfg.SSLMode,
-	)
+	db, err := openDatabase(cfg, logger.Info)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSchema(db); err != nil {
+		return nil, err
+	}
+
+	log.Println("База данных успешно инициализирована")
+	return db, nil
+}
 
+// Подключение к базе без проверки схемы (нужно и серверу, и подкоманде migrate).
+// Подкоманды передают logger.Silent: SQL-лог идет в stdout и смешался бы с их выводом.
+func openDatabase(c Config, logLevel logger.LogLevel) (*gorm.DB, error) {
 	gormLogger := logger.New(
 		log.New(os.Stdout, "\r\n", log.LstdFlags),
 		logger.Config{
 			SlowThreshold:             time.Second,
-			LogLevel:                  logger.Info,
+			LogLevel:                  logLevel,
 			IgnoreRecordNotFoundError: true,
 			Colorful:                  true,
 		},
 	)
 
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
-		Logger:                                   gormLogger,
-		PrepareStmt:                              true,
-		DisableForeignKeyConstraintWhenMigrating: true,
-	})
+	// TranslateError: нарушения ограничений приходят как gorm.ErrDuplicatedKey и т.п. для обоих драйверов
+	gormConfig := &gorm.Config{
+		Logger:         gormLogger,
+		PrepareStmt:    true,
+		TranslateError: true,
+	}
 
+	if c.DBDriver == driverSQLite {
+		return openSQLite(c.SQLitePath, gormConfig)
+	}
+
+	dsn := fmt.Sprintf(
+		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s TimeZone=UTC",
+		c.Host, c.Port, c.User, c.Password, c.DBName, c.SSLMode,
+	)
+	db, err := gorm.Open(postgres.Open(dsn), gormConfig)
 	if err != nil {
 		return nil, fmt.Errorf("не удалось подключиться к базе данных: %w", err)
 	}
@@ -1684,41 +1838,9 @@ func initDatabase() (*gorm.DB, error) {
 	sqlDB.SetConnMaxLifetime(time.Hour)
 	sqlDB.SetConnMaxIdleTime(30 * time.Minute)
 
-	// Выполнение миграций
-	if err := runMigrations(db); err != nil {
-		return nil, fmt.Errorf("ошибка миграции: %w", err)
-	}
-
-	log.Println("База данных успешно инициализирована")
 	return db, nil
 }
 
-func runMigrations(db *gorm.DB) error {
-	db.Exec("CREATE EXTENSION IF NOT EXISTS \"pgcrypto\";")
-
-	err := db.AutoMigrate(&PageData{}, &Product{})
-	if err != nil {
-		return fmt.Errorf("ошибка AutoMigrate: %w", err)
-	}
-
-	indexes := []string{
-		"CREATE INDEX IF NOT EXISTS idx_page_data_url_created ON page_data(url, created_at DESC);",
-		"CREATE INDEX IF NOT EXISTS idx_page_data_success ON page_data(success) WHERE success = true;",
-		"CREATE INDEX IF NOT EXISTS idx_products_price ON products(price);",
-		"CREATE INDEX IF NOT EXISTS idx_products_name_lower ON products(LOWER(name));",
-		"CREATE INDEX IF NOT EXISTS idx_products_created_at ON products(created_at DESC);",
-		"CREATE INDEX IF NOT EXISTS idx_products_page_data_id ON products(page_data_id);",
-		"CREATE INDEX IF NOT EXISTS idx_products_discount ON products((discount IS NOT NULL)) WHERE discount IS NOT NULL;",
-		"CREATE INDEX IF NOT EXISTS idx_products_page_url ON products(page_url);",
-	}
-
-	for _, idx := range indexes {
-		db.Exec(idx)
-	}
-
-	return nil
-}
-
 // Маршрутизатор
 func setupRouter(app *Application) *http.ServeMux {
 	mux := http.NewServeMux()
@@ -1727,16 +1849,49 @@ func setupRouter(app *Application) *http.ServeMux {
 	mux.HandleFunc("/api/v1/page-data", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodPost:
-			corsMiddleware(app.savePageDataHandler)(w, r)
+			corsMiddleware(app.authMiddleware(ScopeIngest, app.idempotencyMiddleware(app.savePageDataHandler)))(w, r)
 		case http.MethodGet:
-			corsMiddleware(app.getPageDataHandler)(w, r)
+			corsMiddleware(app.readMiddleware(app.getPageDataHandler))(w, r)
 		default:
 			app.respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		}
 	})
 
-	mux.HandleFunc("/api/v1/product", corsMiddleware(app.getProductHandler))
-	mux.HandleFunc("/api/v1/category", corsMiddleware(app.getCategoryHandler))
+	mux.HandleFunc("/api/v1/page-data/bulk", corsMiddleware(app.requireDB(app.authMiddleware(ScopeIngest, app.idempotencyMiddleware(app.saveBulkPageDataHandler)))))
+	mux.HandleFunc("/api/v1/page-data/validate", corsMiddleware(app.authMiddleware(ScopeIngest, app.validatePageDataHandler)))
+	mux.HandleFunc("/api/v1/jobs/{id}", corsMiddleware(app.requireDB(app.authMiddleware(ScopeIngest, app.getJobHandler))))
+	mux.HandleFunc("/api/v1/page-data/snapshots", corsMiddleware(app.requireDB(app.readMiddleware(app.getSnapshotsHandler))))
+	mux.HandleFunc("/api/v1/page-data/snapshot", corsMiddleware(app.requireDB(app.readMiddleware(app.getSnapshotHandler))))
+	mux.HandleFunc("/api/v1/product", corsMiddleware(app.readMiddleware(app.getProductHandler)))
+	mux.HandleFunc("/api/v1/product/history", corsMiddleware(app.requireDB(app.readMiddleware(app.getPriceHistoryHandler))))
+	mux.HandleFunc("/api/v1/category", corsMiddleware(app.readMiddleware(app.getCategoryHandler)))
+	mux.HandleFunc("/api/v1/changes", corsMiddleware(app.requireDB(app.readMiddleware(app.getPriceChangesHandler))))
+	mux.HandleFunc("/api/v1/statistics", corsMiddleware(app.requireDB(app.readMiddleware(app.getStatisticsHandler))))
+	mux.HandleFunc("/api/v1/quality/anomalies", corsMiddleware(app.requireDB(app.readMiddleware(app.getQualityAnomaliesHandler))))
+	mux.HandleFunc("/api/v1/search/products", corsMiddleware(app.requireDB(app.readMiddleware(app.searchProductsHandler))))
+	mux.HandleFunc("/api/v1/search/suggest", corsMiddleware(app.requireDB(app.readMiddleware(app.suggestHandler))))
+
+	// Канонические товары
+	mux.HandleFunc("/api/v1/canonical/{id}", corsMiddleware(app.requireDB(app.readMiddleware(app.getCanonicalHandler))))
+	mux.HandleFunc("/api/v1/canonical/match", corsMiddleware(app.requireDB(app.authMiddleware(ScopeAdmin, app.runCanonicalMatchHandler))))
+	mux.HandleFunc("/api/v1/canonical/merge", corsMiddleware(app.requireDB(app.authMiddleware(ScopeAdmin, app.mergeCanonicalHandler))))
+	mux.HandleFunc("/api/v1/canonical/split", corsMiddleware(app.requireDB(app.authMiddleware(ScopeAdmin, app.splitCanonicalHandler))))
+
+	// Управление API-ключами
+	mux.HandleFunc("/api/v1/admin/api-keys", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			corsMiddleware(app.requireDB(app.authMiddleware(ScopeAdmin, app.createAPIKeyHandler)))(w, r)
+		case http.MethodGet:
+			corsMiddleware(app.requireDB(app.authMiddleware(ScopeAdmin, app.listAPIKeysHandler)))(w, r)
+		case http.MethodDelete:
+			corsMiddleware(app.requireDB(app.authMiddleware(ScopeAdmin, app.revokeAPIKeyHandler)))(w, r)
+		case http.MethodOptions:
+			corsMiddleware(nil)(w, r)
+		default:
+			app.respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	})
 
 	// Главная страница с документацией
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -1752,8 +1907,26 @@ func setupRouter(app *Application) *http.ServeMux {
 			"endpoints": []map[string]string{
 				{"method": "POST", "path": "/api/v1/page-data", "description": "Сохранение данных парсинга"},
 				{"method": "GET", "path": "/api/v1/page-data", "description": "Получение данных о всех продуктах"},
+				{"method": "POST", "path": "/api/v1/page-data/bulk", "description": "Пакетное сохранение данных парсинга (NDJSON)"},
+				{"method": "POST", "path": "/api/v1/page-data/validate", "description": "Проверка данных парсинга без сохранения"},
+				{"method": "GET", "path": "/api/v1/jobs/{id}", "description": "Статус асинхронного сохранения (POST /api/v1/page-data?async=true)"},
+				{"method": "GET", "path": "/api/v1/page-data/snapshots", "description": "Список снимков страницы по url"},
+				{"method": "GET", "path": "/api/v1/page-data/snapshot", "description": "Получение снимка по id вместе с продуктами"},
 				{"method": "GET", "path": "/api/v1/product", "description": "Получение 1 продукта"},
+				{"method": "GET", "path": "/api/v1/product/history", "description": "История цен продукта по id или url"},
 				{"method": "GET", "path": "/api/v1/category", "description": "Получение всех продуктов по указанному page_url"},
+				{"method": "GET", "path": "/api/v1/changes", "description": "Лента изменений цен"},
+				{"method": "GET", "path": "/api/v1/statistics", "description": "Статистика по источникам, страницам и ценам"},
+				{"method": "GET", "path": "/api/v1/quality/anomalies", "description": "Аномалии качества парсинга"},
+				{"method": "GET", "path": "/api/v1/search/products", "description": "Полнотекстовый поиск продуктов"},
+				{"method": "GET", "path": "/api/v1/search/suggest", "description": "Автодополнение названий продуктов"},
+				{"method": "GET", "path": "/api/v1/canonical/{id}", "description": "Канонический товар с ценами всех магазинов"},
+				{"method": "POST", "path": "/api/v1/canonical/match", "description": "Запуск сопоставления продуктов (admin)"},
+				{"method": "POST", "path": "/api/v1/canonical/merge", "description": "Объединение канонических товаров (admin)"},
+				{"method": "POST", "path": "/api/v1/canonical/split", "description": "Выделение продуктов в отдельный канонический товар (admin)"},
+				{"method": "POST", "path": "/api/v1/admin/api-keys", "description": "Создание API-ключа (admin)"},
+				{"method": "GET", "path": "/api/v1/admin/api-keys", "description": "Список API-ключей (admin)"},
+				{"method": "DELETE", "path": "/api/v1/admin/api-keys", "description": "Отзыв API-ключа по id (admin)"},
 			},
 		}
 
@@ -1773,7 +1946,11 @@ func loggingMiddleware(next http.Handler) http.Handler {
 
 		next.ServeHTTP(ww, r)
 
-		log.Printf("%s %s %d %v", r.Method, r.URL.Path, ww.statusCode, time.Since(start))
+		principal := ww.principal
+		if principal == "" {
+			principal = "-"
+		}
+		log.Printf("%s %s %d %v %s", r.Method, r.URL.Path, ww.statusCode, time.Since(start), principal)
 	})
 }
 
@@ -1781,6 +1958,7 @@ func loggingMiddleware(next http.Handler) http.Handler {
 type responseWriterWrapper struct {
 	http.ResponseWriter
 	statusCode int
+	principal  string // имя JWT-клиента или API-ключа, заполняет authMiddleware
 }
 
 func (rw *responseWriterWrapper) WriteHeader(code int) {
@@ -1788,17 +1966,44 @@ func (rw *responseWriterWrapper) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
-// Главная функция
+// Нужен http.ResponseController, чтобы добраться до Flush и дедлайнов соединения
+func (rw *responseWriterWrapper) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Главная функция: подкоманды описаны в cli.go
 func main() {
-	// Инициализация базы данных
+	os.Exit(runCLI(os.Args[1:]))
+}
+
+// Подкоманда serve: запуск HTTP сервера
+func runServe(args []string) error {
+	// Загрузка конфигурации
 	var err error
+	cfg, err = loadConfig(flag.NewFlagSet("serve", flag.ContinueOnError), args)
+	if err != nil {
+		return err
+	}
+	log.Printf("Configuration: %s", cfg)
+
+	// Инициализация базы данных
 	db, err = initDatabase()
 	if err != nil {
-		log.Fatal("Failed to initialize database:", err)
+		return fmt.Errorf("failed to initialize database: %w", err)
 	}
 
 	// Создаем приложение
-	app := NewApplication(db)
+	app := NewApplication(newStore(cfg, db))
+	app.idempotencyTTL = cfg.IdempotencyTTL
+	app.idempotencyLease = cfg.IdempotencyLease
+	app.jobs = cfg.jobWorkerConfig()
+	app.startIdempotencyCleanup()
+
+	// Воркеры асинхронного сохранения
+	app.startJobWorkers()
+
+	// Фоновая привязка продуктов к каноническим товарам
+	app.startCanonicalMatcher(cfg.CanonicalMatchInterval)
 
 	// Настраиваем маршрутизатор
 	router := setupRouter(app)
@@ -1807,14 +2012,30 @@ func main() {
 	handler := loggingMiddleware(router)
 
 	// Запускаем сервер
-	serverAddr := ":" + cfg.APIPort
+	serverAddr := fmt.Sprintf(":%d", cfg.APIPort)
 	log.Printf("Starting server on %s", serverAddr)
-	log.Printf("Database: %s@%s:%d/%s", cfg.User, cfg.Host, cfg.Port, cfg.DBName)
+	log.Printf("Database: %s", cfg.database())
 	log.Printf("API endpoints:")
 	log.Printf("  POST   /api/v1/page-data         - Сохранение данных парсинга")
 	log.Printf("  GET    /api/v1/page-data         - Получение данных о всех продуктах")
+	log.Printf("  POST   /api/v1/page-data/bulk    - Пакетное сохранение данных парсинга (NDJSON)")
+	log.Printf("  POST   /api/v1/page-data/validate - Проверка данных парсинга без сохранения")
+	log.Printf("  GET    /api/v1/jobs/{id}         - Статус асинхронного сохранения")
+	log.Printf("  GET    /api/v1/page-data/snapshots - Список снимков страницы по url")
+	log.Printf("  GET    /api/v1/page-data/snapshot  - Получение снимка по id вместе с продуктами")
 	log.Printf("  GET    /api/v1/product           - Получение 1 продукта")
+	log.Printf("  GET    /api/v1/product/history   - История цен продукта по id или url")
 	log.Printf("  GET    /api/v1/category          - Получение всех продуктов по указанному page_url")
+	log.Printf("  GET    /api/v1/changes           - Лента изменений цен")
+	log.Printf("  GET    /api/v1/statistics        - Статистика по источникам, страницам и ценам")
+	log.Printf("  GET    /api/v1/quality/anomalies - Аномалии качества парсинга")
+	log.Printf("  GET    /api/v1/search/products   - Полнотекстовый поиск продуктов")
+	log.Printf("  GET    /api/v1/search/suggest    - Автодополнение названий продуктов")
+	log.Printf("  GET    /api/v1/canonical/{id}    - Канонический товар с ценами всех магазинов")
+	log.Printf("  POST   /api/v1/canonical/match   - Запуск сопоставления продуктов (admin)")
+	log.Printf("  POST   /api/v1/canonical/merge   - Объединение канонических товаров (admin)")
+	log.Printf("  POST   /api/v1/canonical/split   - Выделение продуктов в отдельный канонический товар (admin)")
+	log.Printf("  *      /api/v1/admin/api-keys    - Управление API-ключами (admin)")
 
 	server := &http.Server{
 		Addr:         serverAddr,
@@ -1825,6 +2046,7 @@ func main() {
 	}
 
 	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatal("Server failed:", err)
+		return fmt.Errorf("server failed: %w", err)
 	}
+	return nil
 }
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Миграции лежат в migrations/<драйвер>/NNNN_имя.up.sql и NNNN_имя.down.sql
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Ключ pg_advisory_lock: одновременно миграции выполняет только один процесс
+const migrationLockKey = 7_104_215_388
+
+var (
+	migrationFileRe = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+	migrationNameRe = regexp.MustCompile(`^[a-z0-9_]+$`)
+)
+
+type migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+	// Перенос данных на Go, выполняется после Up в той же транзакции
+	UpGo func(tx *gorm.DB) error
+}
+
+// Переносы данных, которые проще написать на Go, чем на SQL, по имени миграции.
+// Файлы миграции все равно нужны: они задают версию и описывают, что делает перенос.
+var goMigrations = map[string]func(tx *gorm.DB) error{
+	"backfill_stats": backfillStats,
+}
+
+type migrationStatus struct {
+	migration
+	AppliedAt *time.Time
+}
+
+// Загружает миграции драйвера, отсортированные по версии
+func loadMigrations(driver string) ([]migration, error) {
+	dir := path.Join("migrations", driver)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("нет миграций для %s: %w", driver, err)
+	}
+
+	byVersion := map[int64]*migration{}
+	for _, entry := range entries {
+		m := migrationFileRe.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("%s: имя файла миграции должно быть NNNN_имя.up.sql или NNNN_имя.down.sql", entry.Name())
+		}
+		version, _ := strconv.ParseInt(m[1], 10, 64)
+		body, err := fs.ReadFile(migrationFiles, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("версия %d: разные имена %q и %q", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("миграция %04d_%s: нет файла up", mig.Version, mig.Name)
+		}
+		mig.UpGo = goMigrations[mig.Name]
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// migrator применяет и откатывает миграции на одном соединении: на нем же держится advisory lock
+type migrator struct {
+	driver     string
+	migrations []migration
+	db         *gorm.DB
+	conn       *sql.Conn
+}
+
+// Открывает соединение, берет блокировку и создает schema_migrations. Вызывающий обязан вызвать close.
+func newMigrator(ctx context.Context, db *gorm.DB) (*migrator, error) {
+	driver := db.Dialector.Name()
+	migrations, err := loadMigrations(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m := &migrator{driver: driver, migrations: migrations, db: db, conn: conn}
+
+	// В SQLite блокировок уровня сессии нет; запись в файл и так сериализуется,
+	// а повторное применение версии упрется в первичный ключ schema_migrations
+	if driver == driverPostgres {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("не удалось получить блокировку миграций: %w", err)
+		}
+	}
+
+	if _, err := conn.ExecContext(ctx, m.schemaTableDDL()); err != nil {
+		m.close(ctx)
+		return nil, fmt.Errorf("не удалось создать schema_migrations: %w", err)
+	}
+	return m, nil
+}
+
+func (m *migrator) close(ctx context.Context) {
+	if m.driver == driverPostgres {
+		if _, err := m.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockKey); err != nil {
+			log.Printf("Error releasing migration lock: %v", err)
+		}
+	}
+	m.conn.Close()
+}
+
+func (m *migrator) schemaTableDDL() string {
+	appliedAt := "timestamptz"
+	if m.driver == driverSQLite {
+		appliedAt = "DATETIME"
+	}
+	return `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint PRIMARY KEY,
+		name text NOT NULL,
+		applied_at ` + appliedAt + ` NOT NULL
+	)`
+}
+
+// Плейсхолдер n-го параметра в синтаксисе драйвера
+func (m *migrator) param(n int) string {
+	if m.driver == driverPostgres {
+		return "$" + strconv.Itoa(n)
+	}
+	return "?"
+}
+
+func (m *migrator) applied(ctx context.Context) (map[int64]time.Time, error) {
+	rows, err := m.conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+func (m *migrator) status(ctx context.Context) ([]migrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]migrationStatus, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		s := migrationStatus{migration: mig}
+		if at, ok := applied[mig.Version]; ok {
+			at := at
+			s.AppliedAt = &at
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+// Применяет все непримененные миграции по возрастанию версии, каждую в своей транзакции
+func (m *migrator) up(ctx context.Context) ([]migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []migration
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.Version]; ok {
+			continue
+		}
+		record := fmt.Sprintf("INSERT INTO schema_migrations (version, name, applied_at) VALUES (%s, %s, %s)", m.param(1), m.param(2), m.param(3))
+		if err := m.exec(ctx, mig.Up, mig.UpGo, record, mig.Version, mig.Name, time.Now()); err != nil {
+			return done, fmt.Errorf("миграция %04d_%s: %w", mig.Version, mig.Name, err)
+		}
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+// Откатывает steps последних примененных миграций
+func (m *migrator) down(ctx context.Context, steps int) ([]migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []migration
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		mig := m.migrations[i]
+		if _, ok := applied[mig.Version]; !ok {
+			continue
+		}
+		if mig.Down == "" {
+			return done, fmt.Errorf("миграция %04d_%s: нет файла down", mig.Version, mig.Name)
+		}
+		record := fmt.Sprintf("DELETE FROM schema_migrations WHERE version = %s", m.param(1))
+		if err := m.exec(ctx, mig.Down, nil, record, mig.Version); err != nil {
+			return done, fmt.Errorf("откат %04d_%s: %w", mig.Version, mig.Name, err)
+		}
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+// Выполняет текст миграции, перенос на Go и запись в schema_migrations в одной транзакции.
+// Текст без параметров: и pgx, и SQLite выполняют в одном Exec несколько команд.
+func (m *migrator) exec(ctx context.Context, script string, goStep func(tx *gorm.DB) error, record string, args ...interface{}) error {
+	tx, err := m.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if goStep != nil {
+		// GORM поверх той же транзакции, как это делает gorm.DB.Begin
+		gormTx := m.db.Session(&gorm.Session{Context: ctx, NewDB: true})
+		gormTx.Statement.ConnPool = tx
+		if err := goStep(gormTx); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Непримененные миграции; без schema_migrations непримененными считаются все
+func pendingMigrations(db *gorm.DB) ([]migration, error) {
+	migrations, err := loadMigrations(db.Dialector.Name())
+	if err != nil {
+		return nil, err
+	}
+	if !db.Migrator().HasTable("schema_migrations") {
+		return migrations, nil
+	}
+
+	var versions []int64
+	if err := db.Table("schema_migrations").Pluck("version", &versions).Error; err != nil {
+		return nil, fmt.Errorf("ошибка чтения schema_migrations: %w", err)
+	}
+	applied := make(map[int64]bool, len(versions))
+	for _, v := range versions {
+		applied[v] = true
+	}
+
+	var pending []migration
+	for _, mig := range migrations {
+		if !applied[mig.Version] {
+			pending = append(pending, mig)
+		}
+	}
+	return pending, nil
+}
+
+// Проверяет перед запуском сервера, что схема не отстает от миграций в бинарнике
+func checkSchema(db *gorm.DB) error {
+	pending, err := pendingMigrations(db)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		names := make([]string, 0, len(pending))
+		for _, mig := range pending {
+			names = append(names, fmt.Sprintf("%04d_%s", mig.Version, mig.Name))
+		}
+		return fmt.Errorf("%w: не применены миграции %s; выполните `simple-api migrate up`", errSchemaBehind, strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// Применяет все непримененные миграции
+func migrateUp(db *gorm.DB) ([]migration, error) {
+	ctx := context.Background()
+	m, err := newMigrator(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	defer m.close(ctx)
+	return m.up(ctx)
+}
+
+// Подкоманда migrate: up, down [-steps N], status, create <имя>
+func runMigrateCommand(args []string) error {
+	if len(args) == 0 {
+		return usageErrorf("использование: migrate up|down|status|create")
+	}
+	action, args := args[0], args[1:]
+
+	if action == "create" {
+		return runMigrateCreate(args)
+	}
+
+	fs := flag.NewFlagSet("migrate "+action, flag.ContinueOnError)
+	steps := 0
+	if action == "down" {
+		fs.IntVar(&steps, "steps", 1, "сколько последних миграций откатить")
+	}
+
+	config, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	db, err := openDatabase(config, logger.Silent)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch action {
+	case "up":
+		done, err := migrateUp(db)
+		for _, mig := range done {
+			fmt.Printf("applied %04d_%s\n", mig.Version, mig.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		return err
+
+	case "down":
+		if steps < 1 {
+			return usageErrorf("-steps должен быть не меньше 1")
+		}
+		m, err := newMigrator(ctx, db)
+		if err != nil {
+			return err
+		}
+		defer m.close(ctx)
+
+		done, err := m.down(ctx, steps)
+		for _, mig := range done {
+			fmt.Printf("reverted %04d_%s\n", mig.Version, mig.Name)
+		}
+		return err
+
+	case "status":
+		m, err := newMigrator(ctx, db)
+		if err != nil {
+			return err
+		}
+		defer m.close(ctx)
+
+		statuses, err := m.status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		return w.Flush()
+	}
+
+	return usageErrorf("неизвестное действие %q: ожидается up, down, status или create", action)
+}
+
+// Создает пустые файлы up/down со следующей версией для каждого драйвера
+func runMigrateCreate(args []string) error {
+	fs := flag.NewFlagSet("migrate create", flag.ContinueOnError)
+	dir := fs.String("dir", "migrations", "каталог с миграциями в исходниках")
+	if err := fs.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if fs.NArg() != 1 {
+		return usageErrorf("использование: migrate create [-dir migrations] <имя>")
+	}
+	name := strings.ToLower(fs.Arg(0))
+	if !migrationNameRe.MatchString(name) {
+		return usageErrorf("имя миграции %q: допустимы только латинские буквы, цифры и _", name)
+	}
+
+	// Версии общие для всех драйверов, чтобы одна и та же миграция имела один номер
+	drivers := []string{driverPostgres, driverSQLite}
+	var next int64 = 1
+	for _, driver := range drivers {
+		entries, err := os.ReadDir(filepath.Join(*dir, driver))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		for _, entry := range entries {
+			if m := migrationFileRe.FindStringSubmatch(entry.Name()); m != nil {
+				if version, _ := strconv.ParseInt(m[1], 10, 64); version >= next {
+					next = version + 1
+				}
+			}
+		}
+	}
+
+	for _, driver := range drivers {
+		if err := os.MkdirAll(filepath.Join(*dir, driver), 0o755); err != nil {
+			return err
+		}
+		for _, direction := range []string{"up", "down"} {
+			file := filepath.Join(*dir, driver, fmt.Sprintf("%04d_%s.%s.sql", next, name, direction))
+			body := fmt.Sprintf("-- %04d_%s (%s): %s\n", next, name, driver, direction)
+			if err := os.WriteFile(file, []byte(body), 0o644); err != nil {
+				return err
+			}
+			fmt.Println("created", file)
+		}
+	}
+	return nil
+}
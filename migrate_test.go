@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	for _, driver := range []string{driverPostgres, driverSQLite} {
+		migrations, err := loadMigrations(driver)
+		if err != nil {
+			t.Fatalf("%s: %v", driver, err)
+		}
+		if len(migrations) == 0 || migrations[0].Version != 1 {
+			t.Fatalf("%s: migrations = %+v, want version 1 first", driver, migrations)
+		}
+		for _, mig := range migrations {
+			if strings.TrimSpace(mig.Down) == "" {
+				t.Errorf("%s: %04d_%s has no down migration", driver, mig.Version, mig.Name)
+			}
+		}
+	}
+}
+
+func TestMigrateUpDownSQLite(t *testing.T) {
+	db := openTestSQLite(t)
+	ctx := context.Background()
+
+	if err := checkSchema(db); err == nil {
+		t.Fatal("checkSchema on empty database: want error")
+	}
+
+	done, err := migrateUp(db)
+	if err != nil {
+		t.Fatalf("migrateUp: %v", err)
+	}
+	all, _ := loadMigrations(driverSQLite)
+	if len(done) != len(all) {
+		t.Fatalf("applied %d migrations, want %d", len(done), len(all))
+	}
+	if err := checkSchema(db); err != nil {
+		t.Fatalf("checkSchema after up: %v", err)
+	}
+	if !db.Migrator().HasTable("products") {
+		t.Fatal("products table was not created")
+	}
+
+	// Повторный up ничего не делает
+	if done, err := migrateUp(db); err != nil || len(done) != 0 {
+		t.Fatalf("second migrateUp = %d, %v; want nothing to apply", len(done), err)
+	}
+
+	m, err := newMigrator(ctx, db)
+	if err != nil {
+		t.Fatalf("newMigrator: %v", err)
+	}
+	reverted, err := m.down(ctx, len(all))
+	if err != nil {
+		t.Fatalf("down: %v", err)
+	}
+	statuses, err := m.status(ctx)
+	m.close(ctx)
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+
+	if len(reverted) != len(all) || reverted[0].Version != all[len(all)-1].Version {
+		t.Errorf("reverted %+v, want all migrations newest first", reverted)
+	}
+	for _, s := range statuses {
+		if s.AppliedAt != nil {
+			t.Errorf("%04d_%s still applied after down", s.Version, s.Name)
+		}
+	}
+	if db.Migrator().HasTable("products") {
+		t.Error("products table still exists after down")
+	}
+	if err := checkSchema(db); err == nil {
+		t.Error("checkSchema after down: want error")
+	}
+}
+
+// Схема Postgres, которую создавали AutoMigrate(&PageData{}, &Product{}) и runMigrations
+// до появления версионных миграций
+const baselinePostgresSchema = `
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+
+CREATE TABLE page_data (
+    id uuid DEFAULT gen_random_uuid(),
+    page_info jsonb,
+    page_title text,
+    stats jsonb,
+    success boolean DEFAULT true,
+    "timestamp" text,
+    url text,
+    user_agent text,
+    created_at timestamptz,
+    updated_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_page_data_url ON page_data(url);
+
+CREATE TABLE products (
+    id uuid DEFAULT gen_random_uuid(),
+    discount decimal(10,2),
+    element_text text,
+    image text,
+    name varchar(255) NOT NULL,
+    old_price decimal(10,2),
+    page_title text,
+    page_url text,
+    price decimal(10,2) NOT NULL,
+    source varchar(100) NOT NULL,
+    "timestamp" timestamptz,
+    unit varchar(50),
+    url text,
+    weight decimal(10,2),
+    created_at timestamptz,
+    updated_at timestamptz,
+    page_data_id uuid,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_page_data_products FOREIGN KEY (page_data_id) REFERENCES page_data(id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE INDEX idx_products_name ON products(name);
+CREATE INDEX idx_products_page_url ON products(page_url);
+CREATE INDEX idx_products_price ON products(price);
+CREATE INDEX idx_products_source ON products(source);
+CREATE INDEX idx_products_timestamp ON products("timestamp");
+CREATE UNIQUE INDEX idx_products_url ON products(url);
+CREATE INDEX idx_products_page_data_id ON products(page_data_id);
+
+CREATE INDEX idx_page_data_url_created ON page_data(url, created_at DESC);
+CREATE INDEX idx_page_data_success ON page_data(success) WHERE success = true;
+CREATE INDEX idx_products_name_lower ON products(LOWER(name));
+CREATE INDEX idx_products_created_at ON products(created_at DESC);
+CREATE INDEX idx_products_discount ON products((discount IS NOT NULL)) WHERE discount IS NOT NULL;
+`
+
+func TestMigrateUpFromBaselinePostgres(t *testing.T) {
+	db := openTestPostgres(t)
+	if err := db.Exec(baselinePostgresSchema).Error; err != nil {
+		t.Fatalf("baseline schema: %v", err)
+	}
+
+	// Данные до появления снимков: один page_data на url, продукты ссылаются на него
+	const snapshotID = "8d3f7a52-2f5e-4a8e-9c1b-6f0c2d4e9a10"
+	seed := []string{
+		`INSERT INTO page_data (id, url, page_title, stats, created_at, updated_at)
+			VALUES ('` + snapshotID + `', 'https://shop.example/milk', 'Молоко', '{"totalProducts": 3, "avgPrice": 75}', NOW() - interval '1 day', NOW())`,
+		`INSERT INTO products (name, url, page_url, price, source, weight, unit, page_data_id, created_at, updated_at) VALUES
+			('Молоко 500 г', 'https://shop.example/p/1', 'https://shop.example/milk', 100, 'shop', 0.5, 'кг', '` + snapshotID + `', NOW(), NOW()),
+			('Кефир', 'https://shop.example/p/2', 'https://shop.example/milk', 50, 'shop', NULL, NULL, '` + snapshotID + `', NOW(), NOW())`,
+	}
+	for _, q := range seed {
+		if err := db.Exec(q).Error; err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+	}
+
+	if _, err := migrateUp(db); err != nil {
+		t.Fatalf("migrateUp on baseline: %v", err)
+	}
+	if err := checkSchema(db); err != nil {
+		t.Fatalf("checkSchema: %v", err)
+	}
+
+	var snapshot PageData
+	if err := db.First(&snapshot, "id = ?", snapshotID).Error; err != nil {
+		t.Fatalf("load snapshot: %v", err)
+	}
+	if snapshot.PageID == nil {
+		t.Error("snapshot is not linked to a page")
+	}
+	if snapshot.ReportedStats == nil || snapshot.ReportedStats.TotalProducts == nil || *snapshot.ReportedStats.TotalProducts != 3 ||
+		snapshot.Stats.TotalProducts != 2 || snapshot.StatsDivergence != "totalProducts" {
+		t.Errorf("stats = %+v, reported %+v, divergence %q", snapshot.Stats, snapshot.ReportedStats, snapshot.StatsDivergence)
+	}
+
+	var items, observations int64
+	db.Model(&SnapshotProduct{}).Where("page_data_id = ?", snapshotID).Count(&items)
+	db.Model(&PriceObservation{}).Where("page_data_id = ?", snapshotID).Count(&observations)
+	if items != 2 || observations != 2 {
+		t.Errorf("snapshot products = %d, observations = %d, want 2 and 2", items, observations)
+	}
+
+	var product Product
+	db.First(&product, "url = ?", "https://shop.example/p/1")
+	if product.UnitPrice == nil || *product.UnitPrice != 200 || product.WeightSource != SizeSupplied {
+		t.Errorf("product = %+v, want unit price 200 from the supplied weight", product)
+	}
+
+	// Каскадного внешнего ключа из AutoMigrate больше нет: удаление снимка не удаляет продукты
+	if err := db.Exec("DELETE FROM page_data WHERE id = ?", snapshotID).Error; err != nil {
+		t.Fatalf("delete snapshot: %v", err)
+	}
+	var products int64
+	db.Model(&Product{}).Count(&products)
+	if products != 2 {
+		t.Errorf("products after deleting the legacy snapshot = %d, want 2", products)
+	}
+
+	// Новые данные сохраняются в обновленную схему
+	pageData := samplePageData()
+	if err := NewPostgresStore(db).SavePageData(&pageData); err != nil {
+		t.Fatalf("SavePageData after migration: %v", err)
+	}
+}
@@ -0,0 +1,248 @@
+-- Исходная схема: то, что раньше создавали AutoMigrate и runMigrations.
+-- В базах, созданных AutoMigrate до появления миграций, уже есть page_data и products,
+-- но без колонок, добавленных с тех пор: CREATE TABLE IF NOT EXISTS их не трогает, поэтому
+-- новые колонки этих двух таблиц добавляются отдельно через ADD COLUMN IF NOT EXISTS.
+
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE TABLE IF NOT EXISTS pages (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    url text NOT NULL,
+    title text,
+    snapshot_count bigint NOT NULL DEFAULT 0,
+    first_seen_at timestamptz,
+    last_seen_at timestamptz,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS page_data (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    page_info jsonb,
+    page_title text,
+    stats jsonb,
+    success boolean DEFAULT true,
+    timestamp text,
+    url text,
+    user_agent text,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+
+ALTER TABLE page_data
+    ADD COLUMN IF NOT EXISTS page_id uuid,
+    ADD COLUMN IF NOT EXISTS reported_stats jsonb,
+    ADD COLUMN IF NOT EXISTS stats_divergent boolean NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS stats_divergence text;
+
+-- Раньше page_data.url был уникальным; теперь каждый POST - отдельный снимок
+DROP INDEX IF EXISTS idx_page_data_url;
+
+CREATE TABLE IF NOT EXISTS products (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    discount decimal(10,2),
+    element_text text,
+    image text,
+    name varchar(255) NOT NULL,
+    old_price decimal(10,2),
+    page_title text,
+    page_url text,
+    price decimal(10,2) NOT NULL,
+    source varchar(100) NOT NULL,
+    timestamp timestamptz,
+    unit varchar(50),
+    url text,
+    weight decimal(10,2),
+    created_at timestamptz,
+    updated_at timestamptz,
+    page_data_id uuid
+);
+
+ALTER TABLE products
+    ADD COLUMN IF NOT EXISTS gtin varchar(14),
+    ADD COLUMN IF NOT EXISTS sku varchar(100),
+    ADD COLUMN IF NOT EXISTS unit_price decimal(12,2),
+    ADD COLUMN IF NOT EXISTS unit_dimension varchar(10),
+    ADD COLUMN IF NOT EXISTS weight_source varchar(10),
+    ADD COLUMN IF NOT EXISTS unit_source varchar(10),
+    ADD COLUMN IF NOT EXISTS canonical_product_id uuid,
+    ADD COLUMN IF NOT EXISTS canonical_locked boolean NOT NULL DEFAULT false;
+
+-- AutoMigrate создавал внешний ключ с ON DELETE CASCADE: продукт теперь общий для всех снимков,
+-- и удаление снимка (например, при очистке старых) не должно удалять продукт
+ALTER TABLE products DROP CONSTRAINT IF EXISTS fk_page_data_products;
+
+-- Полнотекстовый поиск по названию (вес A) и тексту карточки (вес B) с русской морфологией
+ALTER TABLE products ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (
+        setweight(to_tsvector('russian', coalesce(name, '')), 'A') ||
+        setweight(to_tsvector('russian', coalesce(element_text, '')), 'B')
+    ) STORED;
+
+CREATE TABLE IF NOT EXISTS snapshot_products (
+    page_data_id uuid NOT NULL,
+    position bigint NOT NULL,
+    product_id uuid NOT NULL,
+    price decimal(10,2) NOT NULL,
+    old_price decimal(10,2),
+    discount decimal(10,2),
+    PRIMARY KEY (page_data_id, position)
+);
+
+CREATE TABLE IF NOT EXISTS price_observations (
+    id bigserial PRIMARY KEY,
+    product_id uuid NOT NULL,
+    page_data_id uuid,
+    price decimal(10,2) NOT NULL,
+    old_price decimal(10,2),
+    discount decimal(10,2),
+    weight decimal(10,2),
+    unit varchar(50),
+    source varchar(100),
+    observed_at timestamptz NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS price_changes (
+    id bigserial PRIMARY KEY,
+    product_id uuid NOT NULL,
+    page_data_id uuid,
+    source varchar(100),
+    page_url text,
+    name varchar(255),
+    url text,
+    direction varchar(20) NOT NULL,
+    previous_price decimal(10,2),
+    price decimal(10,2) NOT NULL,
+    abs_delta decimal(10,2),
+    pct_delta numeric,
+    detected_at timestamptz NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    name varchar(100) NOT NULL,
+    key_hash char(64) NOT NULL,
+    key_hint varchar(20),
+    scopes text NOT NULL,
+    source varchar(100),
+    request_count bigint NOT NULL DEFAULT 0,
+    last_used_at timestamptz,
+    revoked_at timestamptz,
+    created_at timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS canonical_products (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    name varchar(255) NOT NULL,
+    normalized_name varchar(255),
+    weight decimal(10,2),
+    unit varchar(50),
+    gtin varchar(14),
+    match_key text,
+    merged_into_id uuid,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS quality_anomalies (
+    id bigserial PRIMARY KEY,
+    page_id uuid NOT NULL,
+    page_data_id uuid NOT NULL,
+    page_url text,
+    kind varchar(30) NOT NULL,
+    baseline decimal(12,2),
+    value decimal(12,2),
+    pct_change numeric,
+    samples bigint,
+    detected_at timestamptz NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    owner varchar(300) NOT NULL,
+    key varchar(255) NOT NULL,
+    request_hash char(64) NOT NULL,
+    status_code bigint NOT NULL DEFAULT 0,
+    content_type varchar(100),
+    response_body bytea,
+    created_at timestamptz,
+    -- Когда ключ занят обработчиком: без ответа за IDEMPOTENCY_LEASE ключ считается брошенным
+    started_at timestamptz,
+    expires_at timestamptz NOT NULL,
+    PRIMARY KEY (owner, key)
+);
+
+CREATE TABLE IF NOT EXISTS ingest_jobs (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    status varchar(20) NOT NULL,
+    payload jsonb NOT NULL,
+    page_url text,
+    principal varchar(255),
+    attempts bigint NOT NULL DEFAULT 0,
+    max_attempts bigint NOT NULL,
+    last_error text,
+    page_data_id uuid,
+    next_run_at timestamptz NOT NULL,
+    started_at timestamptz,
+    finished_at timestamptz,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_pages_url ON pages(url);
+
+CREATE INDEX IF NOT EXISTS idx_page_data_page_id ON page_data(page_id);
+CREATE INDEX IF NOT EXISTS idx_page_data_stats_divergent ON page_data(stats_divergent);
+CREATE INDEX IF NOT EXISTS idx_page_data_url_created ON page_data(url, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_page_data_page_created ON page_data(page_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_page_data_success ON page_data(success) WHERE success = true;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_products_url ON products(url);
+CREATE INDEX IF NOT EXISTS idx_products_name ON products(name);
+CREATE INDEX IF NOT EXISTS idx_products_name_lower ON products(LOWER(name));
+CREATE INDEX IF NOT EXISTS idx_products_name_trgm ON products USING GIN (LOWER(name) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_products_search_vector ON products USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_products_page_url ON products(page_url);
+CREATE INDEX IF NOT EXISTS idx_products_page_url_unit_price ON products(page_url, unit_dimension, unit_price);
+CREATE INDEX IF NOT EXISTS idx_products_price ON products(price);
+CREATE INDEX IF NOT EXISTS idx_products_source ON products(source);
+CREATE INDEX IF NOT EXISTS idx_products_timestamp ON products(timestamp);
+CREATE INDEX IF NOT EXISTS idx_products_created_at ON products(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_products_page_data_id ON products(page_data_id);
+CREATE INDEX IF NOT EXISTS idx_products_gtin ON products(gtin);
+CREATE INDEX IF NOT EXISTS idx_products_unit_price ON products(unit_price);
+CREATE INDEX IF NOT EXISTS idx_products_unit_dimension ON products(unit_dimension);
+CREATE INDEX IF NOT EXISTS idx_products_canonical_product_id ON products(canonical_product_id);
+CREATE INDEX IF NOT EXISTS idx_products_discount ON products((discount IS NOT NULL)) WHERE discount IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_snapshot_products_product_id ON snapshot_products(product_id);
+
+CREATE INDEX IF NOT EXISTS idx_price_observations_product_id ON price_observations(product_id);
+CREATE INDEX IF NOT EXISTS idx_price_observations_page_data_id ON price_observations(page_data_id);
+CREATE INDEX IF NOT EXISTS idx_price_observations_product_observed ON price_observations(product_id, observed_at DESC);
+
+CREATE INDEX IF NOT EXISTS idx_price_changes_product_id ON price_changes(product_id);
+CREATE INDEX IF NOT EXISTS idx_price_changes_page_data_id ON price_changes(page_data_id);
+CREATE INDEX IF NOT EXISTS idx_price_changes_source ON price_changes(source);
+CREATE INDEX IF NOT EXISTS idx_price_changes_page_url ON price_changes(page_url);
+CREATE INDEX IF NOT EXISTS idx_price_changes_direction ON price_changes(direction);
+CREATE INDEX IF NOT EXISTS idx_price_changes_detected ON price_changes(detected_at DESC);
+CREATE INDEX IF NOT EXISTS idx_price_changes_abs_pct ON price_changes((ABS(pct_delta)));
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_name ON api_keys(name);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_key_hash ON api_keys(key_hash);
+
+-- Ключ сопоставления уникален: matcher опирается на ON CONFLICT; пустой ключ - у созданных вручную
+CREATE UNIQUE INDEX IF NOT EXISTS idx_canonical_products_match_key ON canonical_products(match_key) WHERE match_key <> '';
+CREATE INDEX IF NOT EXISTS idx_canonical_products_merged_into_id ON canonical_products(merged_into_id);
+
+CREATE INDEX IF NOT EXISTS idx_quality_anomalies_page_id ON quality_anomalies(page_id);
+CREATE INDEX IF NOT EXISTS idx_quality_anomalies_page_data_id ON quality_anomalies(page_data_id);
+CREATE INDEX IF NOT EXISTS idx_quality_anomalies_page_url ON quality_anomalies(page_url);
+CREATE INDEX IF NOT EXISTS idx_quality_anomalies_kind ON quality_anomalies(kind);
+CREATE INDEX IF NOT EXISTS idx_quality_anomalies_detected_at ON quality_anomalies(detected_at);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
+
+CREATE INDEX IF NOT EXISTS idx_ingest_jobs_status ON ingest_jobs(status);
+CREATE INDEX IF NOT EXISTS idx_ingest_jobs_next_run_at ON ingest_jobs(next_run_at);
@@ -0,0 +1,4 @@
+-- Необратима: перенесенные строки (pages, page_id снимков, snapshot_products, price_observations,
+-- weight_source/unit_source и unit_price продуктов) не отличить от сохраненных после переноса,
+-- поэтому откат их не удаляет и только снимает отметку о версии. Повторный up безопасен:
+-- каждая команда переноса пропускает уже перенесенные строки.
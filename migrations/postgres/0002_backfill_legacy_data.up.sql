@@ -0,0 +1,48 @@
+-- Перенос данных, сохраненных до появления снимков, истории цен, источников размера
+-- и цены за единицу. На новой базе ничего не делает.
+
+-- Привязываем снимки, сохраненные до появления таблицы pages, к страницам
+INSERT INTO pages (url, title, snapshot_count, first_seen_at, last_seen_at, created_at, updated_at)
+SELECT url, (array_agg(page_title ORDER BY created_at DESC))[1], COUNT(*), MIN(created_at), MAX(created_at), NOW(), NOW()
+FROM page_data WHERE page_id IS NULL GROUP BY url
+ON CONFLICT (url) DO NOTHING;
+
+UPDATE page_data SET page_id = pages.id FROM pages
+WHERE page_data.page_id IS NULL AND page_data.url = pages.url;
+
+INSERT INTO snapshot_products (page_data_id, position, product_id, price, old_price, discount)
+SELECT p.page_data_id, ROW_NUMBER() OVER (PARTITION BY p.page_data_id ORDER BY p.created_at) - 1, p.id, p.price, p.old_price, p.discount
+FROM products p
+WHERE p.page_data_id IS NOT NULL
+AND NOT EXISTS (SELECT 1 FROM snapshot_products sp WHERE sp.page_data_id = p.page_data_id);
+
+-- История цен из уже сохраненных снимков
+INSERT INTO price_observations (product_id, page_data_id, price, old_price, discount, weight, unit, source, observed_at)
+SELECT sp.product_id, sp.page_data_id, sp.price, sp.old_price, sp.discount, p.weight, p.unit, p.source, pd.created_at
+FROM snapshot_products sp
+JOIN products p ON p.id = sp.product_id
+JOIN page_data pd ON pd.id = sp.page_data_id
+WHERE NOT EXISTS (SELECT 1 FROM price_observations);
+
+-- Вес и единица, сохраненные до появления weight_source/unit_source, прислал парсер
+UPDATE products SET
+    weight_source = CASE WHEN weight IS NOT NULL AND weight > 0 THEN 'supplied' END,
+    unit_source = CASE WHEN unit IS NOT NULL AND trim(unit) <> '' THEN 'supplied' END
+WHERE weight_source IS NULL AND unit_source IS NULL
+    AND ((weight IS NOT NULL AND weight > 0) OR (unit IS NOT NULL AND trim(unit) <> ''));
+
+-- Цена за кг, литр или штуку; единицы и множители - unitDefinitions на момент миграции
+UPDATE products p SET
+    unit_dimension = u.dimension,
+    unit_price = ROUND(p.price / (p.weight * u.factor), 2)
+FROM (VALUES
+    ('мг', 'mass', 0.000001), ('mg', 'mass', 0.000001),
+    ('г', 'mass', 0.001), ('гр', 'mass', 0.001), ('g', 'mass', 0.001), ('gr', 'mass', 0.001),
+    ('кг', 'mass', 1), ('kg', 'mass', 1),
+    ('мл', 'volume', 0.001), ('ml', 'volume', 0.001),
+    ('сл', 'volume', 0.01), ('cl', 'volume', 0.01),
+    ('л', 'volume', 1), ('l', 'volume', 1),
+    ('шт', 'count', 1), ('pcs', 'count', 1), ('pc', 'count', 1)
+) AS u(unit, dimension, factor)
+WHERE p.unit_price IS NULL AND p.weight > 0 AND p.price > 0
+    AND lower(rtrim(trim(p.unit), '.')) = u.unit;
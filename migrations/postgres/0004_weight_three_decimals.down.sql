@@ -0,0 +1,4 @@
+-- Вес снова округляется до двух знаков; цена за единицу не пересчитывается
+ALTER TABLE products ALTER COLUMN weight TYPE decimal(10,2);
+ALTER TABLE price_observations ALTER COLUMN weight TYPE decimal(10,2);
+ALTER TABLE canonical_products ALTER COLUMN weight TYPE decimal(10,2);
@@ -0,0 +1,21 @@
+-- Вес бывает с тремя знаками (0,125 кг из названия, 0,33 л от парсера), а decimal(10,2)
+-- округлял его при записи, и сохраненная цена за единицу не совпадала с весом.
+-- Целая часть остается восьмизначной, как была.
+ALTER TABLE products ALTER COLUMN weight TYPE decimal(11,3);
+ALTER TABLE price_observations ALTER COLUMN weight TYPE decimal(11,3);
+ALTER TABLE canonical_products ALTER COLUMN weight TYPE decimal(11,3);
+
+-- Третий знак у уже сохраненных строк потерян: пересчитываем цену за единицу по
+-- сохраненному весу, чтобы строки были согласованы до следующего парсинга
+UPDATE products p SET unit_price = ROUND(p.price / (p.weight * u.factor), 2)
+FROM (VALUES
+    ('мг', 0.000001), ('mg', 0.000001),
+    ('г', 0.001), ('гр', 0.001), ('g', 0.001), ('gr', 0.001),
+    ('кг', 1), ('kg', 1),
+    ('мл', 0.001), ('ml', 0.001),
+    ('сл', 0.01), ('cl', 0.01),
+    ('л', 1), ('l', 1),
+    ('шт', 1), ('pcs', 1), ('pc', 1)
+) AS u(unit, factor)
+WHERE p.unit_price IS NOT NULL AND p.weight > 0 AND p.price > 0
+    AND lower(rtrim(trim(p.unit), '.')) = u.unit;
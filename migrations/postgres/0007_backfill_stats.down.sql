@@ -0,0 +1,3 @@
+-- Необратима: пересчитанная статистика заменила присланную в stats, а присланная сохранена
+-- в reported_stats так же, как у новых снимков. Откат только снимает отметку о версии,
+-- данные остаются; повторный up пропускает снимки, у которых reported_stats уже заполнен.
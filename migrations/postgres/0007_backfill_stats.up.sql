@@ -0,0 +1,4 @@
+-- Снимки, сохраненные до появления проверки статистики: stats пересчитывается по составу
+-- снимка, присланная статистика переносится в reported_stats и сравнивается с пересчитанной.
+-- Перенос выполняется на Go (backfillStats в stats.go) после этого текста в той же транзакции.
+-- На новой базе ничего не делает.
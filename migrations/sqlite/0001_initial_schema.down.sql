@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS ingest_jobs;
+DROP TABLE IF EXISTS idempotency_keys;
+DROP TABLE IF EXISTS quality_anomalies;
+DROP TABLE IF EXISTS canonical_products;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS price_changes;
+DROP TABLE IF EXISTS price_observations;
+DROP TABLE IF EXISTS snapshot_products;
+DROP TABLE IF EXISTS products;
+DROP TABLE IF EXISTS page_data;
+DROP TABLE IF EXISTS pages;
@@ -0,0 +1,221 @@
+-- Схема, эквивалентная 0001 для Postgres: uuid и varchar - TEXT, decimal - REAL,
+-- timestamptz - DATETIME, jsonb - TEXT с JSON. gen_random_uuid() заменяет выражение
+-- над randomblob, которое дает UUID версии 4.
+
+CREATE TABLE IF NOT EXISTS pages (
+    id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+    url TEXT NOT NULL UNIQUE,
+    title TEXT,
+    snapshot_count INTEGER NOT NULL DEFAULT 0,
+    first_seen_at DATETIME,
+    last_seen_at DATETIME,
+    created_at DATETIME,
+    updated_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS page_data (
+    id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+    page_id TEXT,
+    page_info TEXT,
+    page_title TEXT,
+    stats TEXT,
+    success BOOLEAN DEFAULT true,
+    timestamp TEXT,
+    url TEXT,
+    user_agent TEXT,
+    created_at DATETIME,
+    updated_at DATETIME,
+    reported_stats TEXT,
+    stats_divergent BOOLEAN NOT NULL DEFAULT false,
+    stats_divergence TEXT
+);
+
+-- seq - целочисленный ключ для полнотекстового индекса (0006): неявный rowid таблицы
+-- с ключом TEXT может смениться при VACUUM, а INTEGER PRIMARY KEY - нет
+CREATE TABLE IF NOT EXISTS products (
+    seq INTEGER PRIMARY KEY,
+    id TEXT NOT NULL UNIQUE DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+    discount REAL,
+    element_text TEXT,
+    image TEXT,
+    name TEXT NOT NULL,
+    old_price REAL,
+    page_title TEXT,
+    page_url TEXT,
+    price REAL NOT NULL,
+    source TEXT NOT NULL,
+    timestamp DATETIME,
+    unit TEXT,
+    url TEXT UNIQUE,
+    weight REAL,
+    created_at DATETIME,
+    updated_at DATETIME,
+    page_data_id TEXT,
+    gtin TEXT,
+    sku TEXT,
+    unit_price REAL,
+    unit_dimension TEXT,
+    weight_source TEXT,
+    unit_source TEXT,
+    canonical_product_id TEXT,
+    canonical_locked BOOLEAN NOT NULL DEFAULT false
+);
+
+CREATE TABLE IF NOT EXISTS snapshot_products (
+    page_data_id TEXT NOT NULL,
+    position INTEGER NOT NULL,
+    product_id TEXT NOT NULL,
+    price REAL NOT NULL,
+    old_price REAL,
+    discount REAL,
+    PRIMARY KEY (page_data_id, position)
+);
+
+CREATE TABLE IF NOT EXISTS price_observations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    product_id TEXT NOT NULL,
+    page_data_id TEXT,
+    price REAL NOT NULL,
+    old_price REAL,
+    discount REAL,
+    weight REAL,
+    unit TEXT,
+    source TEXT,
+    observed_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS price_changes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    product_id TEXT NOT NULL,
+    page_data_id TEXT,
+    source TEXT,
+    page_url TEXT,
+    name TEXT,
+    url TEXT,
+    direction TEXT NOT NULL,
+    previous_price REAL,
+    price REAL NOT NULL,
+    abs_delta REAL,
+    pct_delta REAL,
+    detected_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+    name TEXT NOT NULL UNIQUE,
+    key_hash TEXT NOT NULL UNIQUE,
+    key_hint TEXT,
+    scopes TEXT NOT NULL,
+    source TEXT,
+    request_count INTEGER NOT NULL DEFAULT 0,
+    last_used_at DATETIME,
+    revoked_at DATETIME,
+    created_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS canonical_products (
+    id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+    name TEXT NOT NULL,
+    normalized_name TEXT,
+    weight REAL,
+    unit TEXT,
+    gtin TEXT,
+    match_key TEXT,
+    merged_into_id TEXT,
+    created_at DATETIME,
+    updated_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS quality_anomalies (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    page_id TEXT NOT NULL,
+    page_data_id TEXT NOT NULL,
+    page_url TEXT,
+    kind TEXT NOT NULL,
+    baseline REAL,
+    value REAL,
+    pct_change REAL,
+    samples INTEGER,
+    detected_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    owner TEXT NOT NULL,
+    key TEXT NOT NULL,
+    request_hash TEXT NOT NULL,
+    status_code INTEGER NOT NULL DEFAULT 0,
+    content_type TEXT,
+    response_body BLOB,
+    created_at DATETIME,
+    -- Когда ключ занят обработчиком: без ответа за IDEMPOTENCY_LEASE ключ считается брошенным
+    started_at DATETIME,
+    expires_at DATETIME NOT NULL,
+    PRIMARY KEY (owner, key)
+);
+
+CREATE TABLE IF NOT EXISTS ingest_jobs (
+    id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+    status TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    page_url TEXT,
+    principal TEXT,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL,
+    last_error TEXT,
+    page_data_id TEXT,
+    next_run_at DATETIME NOT NULL,
+    started_at DATETIME,
+    finished_at DATETIME,
+    created_at DATETIME,
+    updated_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_page_data_page_id ON page_data(page_id);
+CREATE INDEX IF NOT EXISTS idx_page_data_stats_divergent ON page_data(stats_divergent);
+CREATE INDEX IF NOT EXISTS idx_page_data_url_created ON page_data(url, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_page_data_page_created ON page_data(page_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_page_data_success ON page_data(success) WHERE success = true;
+
+CREATE INDEX IF NOT EXISTS idx_products_name ON products(name);
+CREATE INDEX IF NOT EXISTS idx_products_name_lower ON products(LOWER(name));
+CREATE INDEX IF NOT EXISTS idx_products_page_url ON products(page_url);
+CREATE INDEX IF NOT EXISTS idx_products_page_url_unit_price ON products(page_url, unit_dimension, unit_price);
+CREATE INDEX IF NOT EXISTS idx_products_price ON products(price);
+CREATE INDEX IF NOT EXISTS idx_products_source ON products(source);
+CREATE INDEX IF NOT EXISTS idx_products_timestamp ON products(timestamp);
+CREATE INDEX IF NOT EXISTS idx_products_created_at ON products(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_products_page_data_id ON products(page_data_id);
+CREATE INDEX IF NOT EXISTS idx_products_gtin ON products(gtin);
+CREATE INDEX IF NOT EXISTS idx_products_unit_price ON products(unit_price);
+CREATE INDEX IF NOT EXISTS idx_products_unit_dimension ON products(unit_dimension);
+CREATE INDEX IF NOT EXISTS idx_products_canonical_product_id ON products(canonical_product_id);
+CREATE INDEX IF NOT EXISTS idx_products_discount ON products((discount IS NOT NULL)) WHERE discount IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_snapshot_products_product_id ON snapshot_products(product_id);
+
+CREATE INDEX IF NOT EXISTS idx_price_observations_product_id ON price_observations(product_id);
+CREATE INDEX IF NOT EXISTS idx_price_observations_page_data_id ON price_observations(page_data_id);
+CREATE INDEX IF NOT EXISTS idx_price_observations_product_observed ON price_observations(product_id, observed_at DESC);
+
+CREATE INDEX IF NOT EXISTS idx_price_changes_product_id ON price_changes(product_id);
+CREATE INDEX IF NOT EXISTS idx_price_changes_page_data_id ON price_changes(page_data_id);
+CREATE INDEX IF NOT EXISTS idx_price_changes_source ON price_changes(source);
+CREATE INDEX IF NOT EXISTS idx_price_changes_page_url ON price_changes(page_url);
+CREATE INDEX IF NOT EXISTS idx_price_changes_direction ON price_changes(direction);
+CREATE INDEX IF NOT EXISTS idx_price_changes_detected ON price_changes(detected_at DESC);
+CREATE INDEX IF NOT EXISTS idx_price_changes_abs_pct ON price_changes((ABS(pct_delta)));
+
+-- Ключ сопоставления уникален: matcher опирается на ON CONFLICT; пустой ключ - у созданных вручную
+CREATE UNIQUE INDEX IF NOT EXISTS idx_canonical_products_match_key ON canonical_products(match_key) WHERE match_key <> '';
+CREATE INDEX IF NOT EXISTS idx_canonical_products_merged_into_id ON canonical_products(merged_into_id);
+
+CREATE INDEX IF NOT EXISTS idx_quality_anomalies_page_id ON quality_anomalies(page_id);
+CREATE INDEX IF NOT EXISTS idx_quality_anomalies_page_data_id ON quality_anomalies(page_data_id);
+CREATE INDEX IF NOT EXISTS idx_quality_anomalies_page_url ON quality_anomalies(page_url);
+CREATE INDEX IF NOT EXISTS idx_quality_anomalies_kind ON quality_anomalies(kind);
+CREATE INDEX IF NOT EXISTS idx_quality_anomalies_detected_at ON quality_anomalies(detected_at);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
+
+CREATE INDEX IF NOT EXISTS idx_ingest_jobs_status ON ingest_jobs(status);
+CREATE INDEX IF NOT EXISTS idx_ingest_jobs_next_run_at ON ingest_jobs(next_run_at);
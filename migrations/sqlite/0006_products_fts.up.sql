@@ -0,0 +1,24 @@
+-- Полнотекстовый индекс продуктов для /api/v1/search/products (в Postgres - search_vector).
+-- Внешнее содержимое: индекс хранит только токены, текст берется из products по seq -
+-- псевдониму rowid, который, в отличие от неявного rowid, не меняется при VACUUM.
+CREATE VIRTUAL TABLE IF NOT EXISTS products_fts USING fts5(
+    name, element_text,
+    content='products', content_rowid='seq',
+    tokenize='unicode61 remove_diacritics 2'
+);
+
+CREATE TRIGGER IF NOT EXISTS products_fts_insert AFTER INSERT ON products BEGIN
+    INSERT INTO products_fts(rowid, name, element_text) VALUES (new.seq, new.name, new.element_text);
+END;
+
+CREATE TRIGGER IF NOT EXISTS products_fts_delete AFTER DELETE ON products BEGIN
+    INSERT INTO products_fts(products_fts, rowid, name, element_text) VALUES ('delete', old.seq, old.name, old.element_text);
+END;
+
+CREATE TRIGGER IF NOT EXISTS products_fts_update AFTER UPDATE OF name, element_text ON products BEGIN
+    INSERT INTO products_fts(products_fts, rowid, name, element_text) VALUES ('delete', old.seq, old.name, old.element_text);
+    INSERT INTO products_fts(rowid, name, element_text) VALUES (new.seq, new.name, new.element_text);
+END;
+
+-- Продукты, сохраненные до индекса
+INSERT INTO products_fts(products_fts) VALUES ('rebuild');
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Размер пачки ID в DELETE ... WHERE id IN (...)
+const pruneBatchSize = 500
+
+// pruneResult - сколько строк удалено (при -dry-run - было бы удалено) в каждой таблице
+type pruneResult struct {
+	Snapshots        int64
+	SnapshotProducts int64
+	Anomalies        int64
+	Jobs             int64
+	Observations     int64
+	Changes          int64
+	IdempotencyKeys  int64
+}
+
+// Откатывает транзакцию prune в режиме -dry-run
+var errDryRun = errors.New("dry run")
+
+// Подкоманда prune: удаляет снимки и историю цен старше сроков хранения
+func runPruneCommand(args []string) error {
+	fs := flag.NewFlagSet("prune", flag.ContinueOnError)
+	snapshots := fs.Duration("snapshots-older-than", 0, "удалить снимки старше этого срока (SNAPSHOT_RETENTION)")
+	history := fs.Duration("history-older-than", 0, "удалить историю цен старше этого срока (PRICE_HISTORY_RETENTION)")
+	dryRun := fs.Bool("dry-run", false, "только посчитать, что будет удалено")
+
+	config, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "snapshots-older-than":
+			config.SnapshotRetention = *snapshots
+		case "history-older-than":
+			config.PriceHistoryRetention = *history
+		}
+	})
+	if config.SnapshotRetention < 0 || config.PriceHistoryRetention < 0 {
+		return usageErrorf("срок хранения не может быть отрицательным")
+	}
+	if config.SnapshotRetention == 0 && config.PriceHistoryRetention == 0 {
+		return usageErrorf("срок хранения не задан: SNAPSHOT_RETENTION, PRICE_HISTORY_RETENTION или флаги -snapshots-older-than, -history-older-than")
+	}
+
+	db, err := openCommandDatabase(config)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var snapshotsBefore, historyBefore time.Time
+	if config.SnapshotRetention > 0 {
+		snapshotsBefore = now.Add(-config.SnapshotRetention)
+	}
+	if config.PriceHistoryRetention > 0 {
+		historyBefore = now.Add(-config.PriceHistoryRetention)
+	}
+
+	res, err := pruneData(db, snapshotsBefore, historyBefore, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		fmt.Println("dry run: nothing was deleted")
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TABLE\tROWS")
+	fmt.Fprintf(w, "page_data\t%d\n", res.Snapshots)
+	fmt.Fprintf(w, "snapshot_products\t%d\n", res.SnapshotProducts)
+	fmt.Fprintf(w, "quality_anomalies\t%d\n", res.Anomalies)
+	fmt.Fprintf(w, "ingest_jobs\t%d\n", res.Jobs)
+	fmt.Fprintf(w, "price_observations\t%d\n", res.Observations)
+	fmt.Fprintf(w, "price_changes\t%d\n", res.Changes)
+	fmt.Fprintf(w, "idempotency_keys\t%d\n", res.IdempotencyKeys)
+	return w.Flush()
+}
+
+// Удаляет в одной транзакции снимки старше snapshotsBefore и историю цен старше historyBefore
+// (нулевое время - не удалять), а также просроченные ключи идемпотентности.
+// Сроки независимы: история может пережить свой снимок.
+func pruneData(db *gorm.DB, snapshotsBefore, historyBefore time.Time, dryRun bool) (pruneResult, error) {
+	var res pruneResult
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if !historyBefore.IsZero() {
+			result := tx.Where("observed_at < ?", historyBefore).Delete(&PriceObservation{})
+			if result.Error != nil {
+				return fmt.Errorf("ошибка удаления истории цен: %w", result.Error)
+			}
+			res.Observations = result.RowsAffected
+
+			result = tx.Where("detected_at < ?", historyBefore).Delete(&PriceChange{})
+			if result.Error != nil {
+				return fmt.Errorf("ошибка удаления изменений цен: %w", result.Error)
+			}
+			res.Changes = result.RowsAffected
+		}
+
+		if !snapshotsBefore.IsZero() {
+			if err := pruneSnapshots(tx, snapshotsBefore, &res); err != nil {
+				return err
+			}
+		}
+
+		result := tx.Where("expires_at <= ?", time.Now()).Delete(&IdempotencyKey{})
+		if result.Error != nil {
+			return fmt.Errorf("ошибка удаления ключей идемпотентности: %w", result.Error)
+		}
+		res.IdempotencyKeys = result.RowsAffected
+
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if errors.Is(err, errDryRun) {
+		err = nil
+	}
+	return res, err
+}
+
+// Удаляет старые снимки вместе с составом и аномалиями. Последний снимок страницы
+// и снимки, на которые ссылаются продукты (page_data_id), сохраняются всегда.
+// История и изменения цен остаются, их ссылка на удаленный снимок обнуляется.
+func pruneSnapshots(tx *gorm.DB, before time.Time, res *pruneResult) error {
+	var ids []string
+	err := tx.Model(&PageData{}).
+		Where("created_at < ?", before).
+		Where("EXISTS (SELECT 1 FROM page_data newer WHERE newer.page_id = page_data.page_id AND newer.created_at > page_data.created_at)").
+		Where("NOT EXISTS (SELECT 1 FROM products WHERE products.page_data_id = page_data.id)").
+		Pluck("id", &ids).Error
+	if err != nil {
+		return fmt.Errorf("ошибка выбора снимков: %w", err)
+	}
+
+	for start := 0; start < len(ids); start += pruneBatchSize {
+		end := start + pruneBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		batch := ids[start:end]
+
+		result := tx.Where("page_data_id IN ?", batch).Delete(&SnapshotProduct{})
+		if result.Error != nil {
+			return fmt.Errorf("ошибка удаления состава снимков: %w", result.Error)
+		}
+		res.SnapshotProducts += result.RowsAffected
+
+		result = tx.Where("page_data_id IN ?", batch).Delete(&QualityAnomaly{})
+		if result.Error != nil {
+			return fmt.Errorf("ошибка удаления аномалий: %w", result.Error)
+		}
+		res.Anomalies += result.RowsAffected
+
+		// Внешних ключей нет: без этого история ссылалась бы на удаленные снимки
+		for _, model := range []interface{}{&PriceObservation{}, &PriceChange{}} {
+			if err := tx.Model(model).Where("page_data_id IN ?", batch).Update("page_data_id", nil).Error; err != nil {
+				return fmt.Errorf("ошибка отвязки истории цен от снимков: %w", err)
+			}
+		}
+
+		result = tx.Where("id IN ?", batch).Delete(&PageData{})
+		if result.Error != nil {
+			return fmt.Errorf("ошибка удаления снимков: %w", result.Error)
+		}
+		res.Snapshots += result.RowsAffected
+	}
+
+	// Счетчик снимков страницы должен совпадать с тем, что осталось в page_data
+	if res.Snapshots > 0 {
+		err := tx.Exec("UPDATE pages SET snapshot_count = (SELECT COUNT(*) FROM page_data WHERE page_data.page_id = pages.id)").Error
+		if err != nil {
+			return fmt.Errorf("ошибка пересчета snapshot_count: %w", err)
+		}
+	}
+
+	result := tx.Where("status IN ? AND finished_at < ?", []string{JobSucceeded, JobFailed}, before).Delete(&IngestJob{})
+	if result.Error != nil {
+		return fmt.Errorf("ошибка удаления завершенных задач: %w", result.Error)
+	}
+	res.Jobs = result.RowsAffected
+	return nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Виды аномалий качества парсинга
+const (
+	AnomalyProductYieldDrop     = "product-yield-drop"
+	AnomalyProductElementsDrop  = "product-elements-drop"
+	AnomalyPriceElementsDrop    = "price-elements-drop"
+	AnomalyTotalElementsDrop    = "total-elements-drop"
+	AnomalyStructuredDataLost   = "structured-data-lost"
+	AnomalyStructuredDataGained = "structured-data-gained"
+)
+
+const (
+	// Сколько последних снимков страницы входит в базовую линию
+	qualityBaselineWindow = 10
+	// Меньше снимков - базовой линии еще нет, аномалии не ищем
+	qualityMinBaseline = 3
+	// Резкое падение: значение ниже этой доли от медианы базовой линии
+	qualityDropRatio = 0.5
+)
+
+// QualityAnomaly - снимок, метрики которого резко отклонились от базовой линии страницы
+type QualityAnomaly struct {
+	ID         uint64    `json:"id" gorm:"primaryKey;autoIncrement"`
+	PageID     string    `json:"pageId" gorm:"type:uuid;not null;index"`
+	PageDataID string    `json:"pageDataId" gorm:"type:uuid;not null;index"`
+	PageURL    string    `json:"pageUrl" gorm:"type:text;index"`
+	Kind       string    `json:"kind" gorm:"type:varchar(30);not null;index"`
+	Baseline   float64   `json:"baseline" gorm:"type:decimal(12,2)"`
+	Value      float64   `json:"value" gorm:"type:decimal(12,2)"`
+	PctChange  *float64  `json:"pctChange,omitempty" gorm:"type:numeric"`
+	Samples    int       `json:"samples"` // снимков в базовой линии
+	DetectedAt time.Time `json:"detectedAt" gorm:"type:timestamptz;not null;index"`
+}
+
+type GetQualityAnomaliesResponse struct {
+	Success   bool             `json:"success"`
+	Anomalies []QualityAnomaly `json:"anomalies"`
+	Total     int64            `json:"total"`
+	Page      int              `json:"page"`
+	PerPage   int              `json:"perPage"`
+}
+
+// Числовые метрики снимка, для которых отслеживается резкое падение
+var qualityMetrics = []struct {
+	kind  string
+	value func(pd *PageData) float64
+}{
+	{AnomalyProductYieldDrop, func(pd *PageData) float64 { return float64(pd.Stats.TotalProducts) }},
+	{AnomalyProductElementsDrop, func(pd *PageData) float64 { return float64(pd.PageInfo.ProductElements) }},
+	{AnomalyPriceElementsDrop, func(pd *PageData) float64 { return float64(pd.PageInfo.PriceElements) }},
+	{AnomalyTotalElementsDrop, func(pd *PageData) float64 { return float64(pd.PageInfo.TotalElements) }},
+}
+
+// Сравнивает снимок с последними успешными снимками той же страницы и сохраняет найденные аномалии
+func detectQualityAnomalies(tx *gorm.DB, pageData *PageData) error {
+	if pageData.PageID == nil {
+		return nil
+	}
+
+	var baseline []PageData
+	err := tx.Select("page_info", "stats").
+		Where("page_id = ? AND id <> ? AND success", *pageData.PageID, pageData.ID).
+		Order("created_at DESC").
+		Limit(qualityBaselineWindow).
+		Find(&baseline).Error
+	if err != nil {
+		return fmt.Errorf("ошибка получения базовой линии качества: %w", err)
+	}
+
+	anomalies := findQualityAnomalies(pageData, baseline)
+	if len(anomalies) == 0 {
+		return nil
+	}
+	if err := tx.Create(&anomalies).Error; err != nil {
+		return fmt.Errorf("ошибка сохранения аномалий качества: %w", err)
+	}
+	return nil
+}
+
+func findQualityAnomalies(pageData *PageData, baseline []PageData) []QualityAnomaly {
+	if len(baseline) < qualityMinBaseline {
+		return nil
+	}
+
+	now := time.Now()
+	newAnomaly := func(kind string, base, value float64) QualityAnomaly {
+		a := QualityAnomaly{
+			PageID:     *pageData.PageID,
+			PageDataID: pageData.ID,
+			PageURL:    pageData.URL,
+			Kind:       kind,
+			Baseline:   base,
+			Value:      value,
+			Samples:    len(baseline),
+			DetectedAt: now,
+		}
+		if base != 0 {
+			pct := roundCents((value - base) / base * 100)
+			a.PctChange = &pct
+		}
+		return a
+	}
+
+	var anomalies []QualityAnomaly
+	for _, m := range qualityMetrics {
+		values := make([]float64, 0, len(baseline))
+		for i := range baseline {
+			values = append(values, m.value(&baseline[i]))
+		}
+		base := median(values)
+		value := m.value(pageData)
+		if base > 0 && value < base*qualityDropRatio {
+			anomalies = append(anomalies, newAnomaly(m.kind, base, value))
+		}
+	}
+
+	// Структурированные данные: сравниваем с тем, что было у большинства снимков базовой линии
+	withStructured := 0
+	for _, b := range baseline {
+		if b.PageInfo.HasStructuredData {
+			withStructured++
+		}
+	}
+	share := float64(withStructured) / float64(len(baseline))
+	switch {
+	case share > 0.5 && !pageData.PageInfo.HasStructuredData:
+		anomalies = append(anomalies, newAnomaly(AnomalyStructuredDataLost, share, 0))
+	case share < 0.5 && pageData.PageInfo.HasStructuredData:
+		anomalies = append(anomalies, newAnomaly(AnomalyStructuredDataGained, share, 1))
+	}
+
+	return anomalies
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// Обработчик списка аномалий качества парсинга
+func (app *Application) getQualityAnomaliesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		app.respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	page := app.getQueryInt(query, "page", 1)
+	perPage := app.getQueryInt(query, "per_page", 50)
+
+	since, err := parseTimeParam(query.Get("since"))
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid since parameter")
+		return
+	}
+
+	// Фильтры
+	dbQuery := app.db.Model(&QualityAnomaly{})
+	if pageURL := query.Get("page_url"); pageURL != "" {
+		dbQuery = dbQuery.Where("page_url = ?", pageURL)
+	}
+	if kind := query.Get("kind"); kind != "" {
+		dbQuery = dbQuery.Where("kind = ?", kind)
+	}
+	if since != nil {
+		dbQuery = dbQuery.Where("detected_at >= ?", *since)
+	}
+
+	var total int64
+	if err := dbQuery.Count(&total).Error; err != nil {
+		log.Printf("Error counting quality anomalies: %v", err)
+		app.respondWithError(w, http.StatusInternalServerError, "Failed to get quality anomalies")
+		return
+	}
+
+	anomalies := []QualityAnomaly{}
+	offset := (page - 1) * perPage
+	err = dbQuery.
+		Order("detected_at DESC, id DESC").
+		Offset(offset).
+		Limit(perPage).
+		Find(&anomalies).Error
+
+	if err != nil {
+		log.Printf("Error getting quality anomalies: %v", err)
+		app.respondWithError(w, http.StatusInternalServerError, "Failed to get quality anomalies")
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, GetQualityAnomaliesResponse{
+		Success:   true,
+		Anomalies: anomalies,
+		Total:     total,
+		Page:      page,
+		PerPage:   perPage,
+	})
+}
@@ -0,0 +1,144 @@
+package main
+
+import "testing"
+
+func TestMedian(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   float64
+	}{
+		{"empty", nil, 0},
+		{"single", []float64{7}, 7},
+		{"odd", []float64{30, 10, 20}, 20},
+		{"even", []float64{40, 10, 30, 20}, 25},
+		{"outlier does not shift", []float64{100, 100, 0, 100, 100}, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := median(tt.values); got != tt.want {
+				t.Errorf("median(%v) = %v, want %v", tt.values, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindQualityAnomalies(t *testing.T) {
+	pageID := "page-1"
+	snapshot := func(products, productElements int, structured bool) PageData {
+		return PageData{
+			ID:       "pd",
+			PageID:   &pageID,
+			Stats:    Stats{TotalProducts: products},
+			PageInfo: PageInfo{ProductElements: productElements, PriceElements: productElements, TotalElements: 1000, HasStructuredData: structured},
+		}
+	}
+	baselineOf := func(snapshots ...PageData) []PageData { return snapshots }
+
+	tests := []struct {
+		name     string
+		current  PageData
+		baseline []PageData
+		want     []string
+	}{
+		{
+			name:     "too few baseline snapshots",
+			current:  snapshot(0, 0, false),
+			baseline: baselineOf(snapshot(50, 50, true), snapshot(50, 50, true)),
+			want:     nil,
+		},
+		{
+			name:     "stable page",
+			current:  snapshot(48, 48, true),
+			baseline: baselineOf(snapshot(50, 50, true), snapshot(52, 52, true), snapshot(49, 49, true)),
+			want:     nil,
+		},
+		{
+			name:     "drop below half of median",
+			current:  snapshot(20, 48, true),
+			baseline: baselineOf(snapshot(50, 50, true), snapshot(52, 52, true), snapshot(49, 49, true)),
+			want:     []string{AnomalyProductYieldDrop},
+		},
+		{
+			name:    "median ignores one broken baseline snapshot",
+			current: snapshot(40, 40, true),
+			// Среднее было бы 37,5 и 40 не считалось бы падением; медиана - 50
+			baseline: baselineOf(snapshot(50, 50, true), snapshot(0, 0, true), snapshot(50, 50, true), snapshot(50, 50, true)),
+			want:     nil,
+		},
+		{
+			name:     "exactly half is not a drop",
+			current:  snapshot(25, 25, true),
+			baseline: baselineOf(snapshot(50, 50, true), snapshot(50, 50, true), snapshot(50, 50, true)),
+			want:     nil,
+		},
+		{
+			name:     "structured data lost",
+			current:  snapshot(50, 50, false),
+			baseline: baselineOf(snapshot(50, 50, true), snapshot(50, 50, true), snapshot(50, 50, false)),
+			want:     []string{AnomalyStructuredDataLost},
+		},
+		{
+			name:     "structured data gained",
+			current:  snapshot(50, 50, true),
+			baseline: baselineOf(snapshot(50, 50, false), snapshot(50, 50, false), snapshot(50, 50, true)),
+			want:     []string{AnomalyStructuredDataGained},
+		},
+		{
+			name:     "no majority in baseline",
+			current:  snapshot(50, 50, false),
+			baseline: baselineOf(snapshot(50, 50, true), snapshot(50, 50, false), snapshot(50, 50, true), snapshot(50, 50, false)),
+			want:     nil,
+		},
+		{
+			name:     "structured data kept",
+			current:  snapshot(50, 50, true),
+			baseline: baselineOf(snapshot(50, 50, true), snapshot(50, 50, true), snapshot(50, 50, false)),
+			want:     nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := tt.current
+			anomalies := findQualityAnomalies(&current, tt.baseline)
+
+			got := make([]string, 0, len(anomalies))
+			for _, a := range anomalies {
+				got = append(got, a.Kind)
+				if a.Samples != len(tt.baseline) || a.PageID != pageID {
+					t.Errorf("%s: samples = %d, pageId = %q", a.Kind, a.Samples, a.PageID)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("anomalies = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("anomalies = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFindQualityAnomaliesValues(t *testing.T) {
+	pageID := "page-1"
+	current := PageData{ID: "pd", PageID: &pageID, Stats: Stats{TotalProducts: 10}, PageInfo: PageInfo{ProductElements: 40, PriceElements: 40, TotalElements: 900}}
+	baseline := []PageData{}
+	for _, n := range []int{40, 60, 50} {
+		baseline = append(baseline, PageData{Stats: Stats{TotalProducts: n}, PageInfo: PageInfo{ProductElements: n, PriceElements: n, TotalElements: 900, HasStructuredData: true}})
+	}
+
+	anomalies := findQualityAnomalies(&current, baseline)
+	if len(anomalies) != 2 {
+		t.Fatalf("anomalies = %+v, want yield drop and structured data lost", anomalies)
+	}
+	yield := anomalies[0]
+	if yield.Kind != AnomalyProductYieldDrop || yield.Baseline != 50 || yield.Value != 10 || yield.PctChange == nil || *yield.PctChange != -80 {
+		t.Errorf("yield drop = %+v", yield)
+	}
+	lost := anomalies[1]
+	if lost.Kind != AnomalyStructuredDataLost || lost.Baseline != 1 || lost.Value != 0 {
+		t.Errorf("structured data lost = %+v", lost)
+	}
+}
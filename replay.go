@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Поля ответа, которые меняются от запуска к запуску и не сравниваются по умолчанию
+const defaultReplayIgnore = "id,pageId,pageDataId,jobId,statusUrl,createdAt,updatedAt"
+
+// capturedRequest - запись журнала запросов, одна на строку JSONL:
+//
+//	{"method": "POST", "path": "/api/v1/page-data", "headers": {"Content-Type": "application/json"},
+//	 "body": {...}, "response": {"status": 201, "body": {...}}}
+//
+// body - JSON-значение тела или строка с телом как есть; response необязателен,
+// без него запрос просто отправляется.
+type capturedRequest struct {
+	Method   string            `json:"method"`
+	Path     string            `json:"path"`
+	Headers  map[string]string `json:"headers"`
+	Body     json.RawMessage   `json:"body"`
+	Response *capturedResponse `json:"response"`
+}
+
+type capturedResponse struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// replayResult - результат одного запроса и расхождения с записанным ответом
+type replayResult struct {
+	Status int
+	Err    error
+	Diffs  []string
+}
+
+// replayTarget выполняет запрос и возвращает код и тело ответа
+type replayTarget func(method, path string, header http.Header, body []byte) (int, []byte, error)
+
+// Подкоманда replay: повторяет записанные запросы на сервере по URL или на обработчике
+// в этом процессе и сравнивает ответы с записанными
+func runReplayCommand(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	target := fs.String("target", "memory", "куда слать запросы: базовый URL сервера, db (в процессе, база из конфигурации) или memory (в процессе, хранилище в памяти)")
+	concurrency := fs.Int("concurrency", 1, "число одновременных запросов")
+	rate := fs.Float64("rate", 0, "не больше стольких запросов в секунду; 0 - без ограничения")
+	pathPrefix := fs.String("path-prefix", "", "повторять только запросы с таким началом пути")
+	authorization := fs.String("authorization", "", "заменить заголовок Authorization во всех запросах")
+	ignore := fs.String("ignore", defaultReplayIgnore, "поля ответа через запятую, которые не сравниваются")
+	timeout := fs.Duration("timeout", 30*time.Second, "таймаут запроса к серверу по URL")
+	verbose := fs.Bool("v", false, "печатать каждый запрос, а не только расхождения")
+
+	config, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageErrorf("использование: replay [флаги] <журнал.jsonl|->")
+	}
+	if *concurrency < 1 {
+		return usageErrorf("-concurrency должен быть не меньше 1")
+	}
+	if *rate < 0 {
+		return usageErrorf("-rate не может быть отрицательным")
+	}
+
+	captures, err := readCapturesFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *pathPrefix != "" {
+		filtered := captures[:0]
+		for _, c := range captures {
+			if strings.HasPrefix(c.Path, *pathPrefix) {
+				filtered = append(filtered, c)
+			}
+		}
+		captures = filtered
+	}
+
+	var send replayTarget
+	switch {
+	case strings.HasPrefix(*target, "http://"), strings.HasPrefix(*target, "https://"):
+		send = httpReplayTarget(strings.TrimSuffix(*target, "/"), &http.Client{Timeout: *timeout})
+	case *target == "memory":
+		cfg = config
+		send = handlerReplayTarget(setupRouter(NewApplication(NewMemoryStore())))
+	case *target == "db":
+		db, err := openCommandDatabase(config)
+		if err != nil {
+			return err
+		}
+		cfg = config
+		app := NewApplication(newStore(config, db))
+		app.idempotencyTTL = config.IdempotencyTTL
+		app.idempotencyLease = config.IdempotencyLease
+		send = handlerReplayTarget(setupRouter(app))
+	default:
+		return usageErrorf("-target: ожидается URL, db или memory, получено %q", *target)
+	}
+
+	ignored := map[string]bool{}
+	for _, field := range strings.Split(*ignore, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			ignored[field] = true
+		}
+	}
+
+	started := time.Now()
+	results := replayCaptures(captures, send, *authorization, ignored, *concurrency, *rate)
+
+	failed, mismatched := 0, 0
+	for i, res := range results {
+		c := captures[i]
+		prefix := fmt.Sprintf("#%d %s %s", i+1, c.Method, c.Path)
+		switch {
+		case res.Err != nil:
+			failed++
+			fmt.Printf("%s: %v\n", prefix, res.Err)
+		case len(res.Diffs) > 0:
+			mismatched++
+			for _, diff := range res.Diffs {
+				fmt.Printf("%s: %s\n", prefix, diff)
+			}
+		case *verbose:
+			fmt.Printf("%s: %d ok\n", prefix, res.Status)
+		}
+	}
+
+	fmt.Printf("replayed %d requests in %s: %d mismatched, %d failed\n",
+		len(results), time.Since(started).Round(time.Millisecond), mismatched, failed)
+	if failed > 0 || mismatched > 0 {
+		return fmt.Errorf("%w: %d не совпали, %d не выполнены", errPartial, mismatched, failed)
+	}
+	return nil
+}
+
+func readCapturesFile(name string) ([]capturedRequest, error) {
+	if name == "-" {
+		return readCaptures(os.Stdin)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readCaptures(f)
+}
+
+// Читает журнал JSONL; пустые строки пропускаются
+func readCaptures(r io.Reader) ([]capturedRequest, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBulkLineSize)
+
+	var captures []capturedRequest
+	line := 0
+	for scanner.Scan() {
+		line++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		var c capturedRequest
+		if err := json.Unmarshal(raw, &c); err != nil {
+			return nil, fmt.Errorf("строка %d: %w", line, err)
+		}
+		if c.Method == "" || !strings.HasPrefix(c.Path, "/") {
+			return nil, fmt.Errorf("строка %d: нужны method и path, начинающийся с /", line)
+		}
+		captures = append(captures, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("строка %d: %w", line+1, err)
+	}
+	return captures, nil
+}
+
+// Отправляет запросы пулом из concurrency воркеров не чаще rate в секунду.
+// Результаты идут в порядке журнала; при concurrency > 1 порядок отправки не гарантирован.
+func replayCaptures(captures []capturedRequest, send replayTarget, authorization string, ignored map[string]bool, concurrency int, rate float64) []replayResult {
+	results := make([]replayResult, len(captures))
+	queue := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				results[i] = replayOne(captures[i], send, authorization, ignored)
+			}
+		}()
+	}
+
+	var tick <-chan time.Time
+	if interval := time.Duration(float64(time.Second) / rate); rate > 0 && interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for i := range captures {
+		if tick != nil && i > 0 {
+			<-tick
+		}
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+	return results
+}
+
+func replayOne(c capturedRequest, send replayTarget, authorization string, ignored map[string]bool) replayResult {
+	header := http.Header{}
+	for name, value := range c.Headers {
+		switch http.CanonicalHeaderKey(name) {
+		case "Content-Length", "Host", "Connection", "Transfer-Encoding", "Accept-Encoding":
+			continue
+		}
+		header.Set(name, value)
+	}
+	if authorization != "" {
+		header.Set("Authorization", authorization)
+	}
+
+	status, body, err := send(c.Method, c.Path, header, captureBody(c.Body))
+	if err != nil {
+		return replayResult{Err: err}
+	}
+
+	res := replayResult{Status: status}
+	if c.Response == nil {
+		return res
+	}
+	if c.Response.Status != 0 && status != c.Response.Status {
+		res.Diffs = append(res.Diffs, fmt.Sprintf("status %d, recorded %d", status, c.Response.Status))
+	}
+	if len(c.Response.Body) > 0 {
+		if diff := diffBodies(captureBody(c.Response.Body), body, ignored); diff != "" {
+			res.Diffs = append(res.Diffs, "body differs: "+diff)
+		}
+	}
+	return res
+}
+
+// Тело из журнала: строка JSON - это тело как есть, остальные значения - JSON-тело
+func captureBody(raw json.RawMessage) []byte {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	var s string
+	if raw[0] == '"' && json.Unmarshal(raw, &s) == nil {
+		return []byte(s)
+	}
+	return raw
+}
+
+func httpReplayTarget(baseURL string, client *http.Client) replayTarget {
+	return func(method, path string, header http.Header, body []byte) (int, []byte, error) {
+		req, err := http.NewRequest(method, baseURL+path, bytes.NewReader(body))
+		if err != nil {
+			return 0, nil, err
+		}
+		req.Header = header
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, nil, err
+		}
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(resp.Body)
+		return resp.StatusCode, respBody, err
+	}
+}
+
+func handlerReplayTarget(handler http.Handler) replayTarget {
+	return func(method, path string, header http.Header, body []byte) (int, []byte, error) {
+		req, err := http.NewRequest(method, path, bytes.NewReader(body))
+		if err != nil {
+			return 0, nil, err
+		}
+		req.Header = header
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code, w.Body.Bytes(), nil
+	}
+}
+
+// Сравнивает тела: JSON - по значению без полей ignored на любой глубине, иначе - побайтно.
+// Возвращает описание первого расхождения или пустую строку.
+func diffBodies(recorded, actual []byte, ignored map[string]bool) string {
+	var want, got interface{}
+	if decodeJSONNumbers(recorded, &want) != nil || decodeJSONNumbers(actual, &got) != nil {
+		if bytes.Equal(bytes.TrimSpace(recorded), bytes.TrimSpace(actual)) {
+			return ""
+		}
+		return fmt.Sprintf("%q != recorded %q", truncate(string(actual), 200), truncate(string(recorded), 200))
+	}
+	return diffJSON("$", stripFields(want, ignored), stripFields(got, ignored))
+}
+
+func decodeJSONNumbers(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return fmt.Errorf("лишние данные после JSON")
+	}
+	return nil
+}
+
+func stripFields(v interface{}, ignored map[string]bool) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if ignored[key] {
+				delete(v, key)
+			} else {
+				v[key] = stripFields(value, ignored)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = stripFields(v[i], ignored)
+		}
+	}
+	return v
+}
+
+// Первое расхождение want и got в виде "$.products[0].price: 12 != recorded 10"
+func diffJSON(path string, want, got interface{}) string {
+	switch w := want.(type) {
+	case map[string]interface{}:
+		g, ok := got.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(w)+len(g))
+		for key := range w {
+			keys = append(keys, key)
+		}
+		for key := range g {
+			if _, ok := w[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			wv, inWant := w[key]
+			gv, inGot := g[key]
+			switch {
+			case !inGot:
+				return fmt.Sprintf("%s.%s: missing", path, key)
+			case !inWant:
+				return fmt.Sprintf("%s.%s: unexpected %s", path, key, jsonString(gv))
+			}
+			if diff := diffJSON(path+"."+key, wv, gv); diff != "" {
+				return diff
+			}
+		}
+		return ""
+
+	case []interface{}:
+		g, ok := got.([]interface{})
+		if !ok {
+			break
+		}
+		if len(w) != len(g) {
+			return fmt.Sprintf("%s: %d elements != recorded %d", path, len(g), len(w))
+		}
+		for i := range w {
+			if diff := diffJSON(fmt.Sprintf("%s[%d]", path, i), w[i], g[i]); diff != "" {
+				return diff
+			}
+		}
+		return ""
+	}
+
+	if reflect.DeepEqual(want, got) {
+		return ""
+	}
+	return fmt.Sprintf("%s: %s != recorded %s", path, jsonString(got), jsonString(want))
+}
+
+func jsonString(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return truncate(string(data), 200)
+}
+
+func truncate(s string, max int) string {
+	if runes := []rune(s); len(runes) > max {
+		return string(runes[:max]) + "..."
+	}
+	return s
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDiffBodies(t *testing.T) {
+	ignored := map[string]bool{"id": true, "createdAt": true}
+	cases := []struct {
+		name             string
+		recorded, actual string
+		want             string
+	}{
+		{"ignored fields", `{"success": true, "id": "a", "createdAt": "x"}`, `{"id": "b", "success": true}`, ""},
+		{"nested value", `{"products": [{"id": "1", "price": 10}]}`, `{"products": [{"id": "2", "price": 12}]}`, "$.products[0].price: 12 != recorded 10"},
+		{"missing field", `{"success": true, "message": "ok"}`, `{"success": true}`, "$.message: missing"},
+		{"array length", `[1, 2]`, `[1]`, "$: 1 elements != recorded 2"},
+		{"text", "not json", "not json\n", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := diffBodies([]byte(tc.recorded), []byte(tc.actual), ignored); got != tc.want {
+				t.Errorf("diffBodies = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReplayCapturesInProcess(t *testing.T) {
+	api := newTestAPI(t, NewMemoryStore())
+	body, _ := json.Marshal(samplePageData())
+
+	journal := strings.Join([]string{
+		// Записанный ответ совпадает с точностью до id и времени
+		`{"method": "POST", "path": "/api/v1/page-data", "headers": {"Content-Type": "application/json", "Content-Length": "999"}, "body": ` + string(body) + `, "response": {"status": 201, "body": {"success": true, "message": "Page data saved successfully", "id": "old", "pageId": "old", "createdAt": "2024-01-01T00:00:00Z"}}}`,
+		"",
+		// Раньше этот запрос отклонялся - расхождение по коду ответа
+		`{"method": "POST", "path": "/api/v1/page-data", "headers": {"Content-Type": "application/json"}, "body": ` + string(body) + `, "response": {"status": 400}}`,
+		// Тело строкой и без записанного ответа
+		`{"method": "GET", "path": "/api/v1/product?url=https://shop.example/p/1", "body": ""}`,
+	}, "\n")
+
+	captures, err := readCaptures(strings.NewReader(journal))
+	if err != nil {
+		t.Fatalf("readCaptures: %v", err)
+	}
+	if len(captures) != 3 {
+		t.Fatalf("captures = %d, want 3", len(captures))
+	}
+
+	ignored := map[string]bool{"id": true, "pageId": true, "createdAt": true}
+	results := replayCaptures(captures[:2], handlerReplayTarget(api.handler), "Bearer "+api.token, ignored, 2, 1000)
+	results = append(results, replayCaptures(captures[2:], handlerReplayTarget(api.handler), "Bearer "+api.token, ignored, 1, 0)...)
+
+	if res := results[0]; res.Err != nil || res.Status != 201 || len(res.Diffs) != 0 {
+		t.Errorf("matching request: %+v", res)
+	}
+	if res := results[1]; len(res.Diffs) != 1 || res.Diffs[0] != "status 201, recorded 400" {
+		t.Errorf("status mismatch: %+v", res)
+	}
+	if res := results[2]; res.Status != 200 || len(res.Diffs) != 0 {
+		t.Errorf("request without recorded response: %+v", res)
+	}
+
+	if _, err := readCaptures(strings.NewReader(`{"method": "GET", "path": "api/v1/product"}`)); err == nil {
+		t.Error("relative path: want error")
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+// Параметры ts_headline для подсветки совпадений
+const searchHeadlineOptions = "StartSel=<b>, StopSel=</b>, MaxWords=25, MinWords=8, MaxFragments=2, FragmentDelimiter= … "
+
+// Допустимые варианты сортировки результатов поиска
+var searchSortOrders = map[string]string{
+	"":           "rank DESC, products.created_at DESC",
+	"rank":       "rank DESC, products.created_at DESC",
+	"price_asc":  "products.price ASC",
+	"price_desc": "products.price DESC",
+	"newest":     "products.created_at DESC",
+
+	"unit_price_asc":  "products.unit_price ASC NULLS LAST, products.price ASC",
+	"unit_price_desc": "products.unit_price DESC NULLS LAST, products.price DESC",
+}
+
+// SearchResult - продукт с релевантностью и подсвеченным фрагментом
+type SearchResult struct {
+	Product
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+type SearchProductsResponse struct {
+	Success bool                   `json:"success"`
+	Query   string                 `json:"query"`
+	Mode    string                 `json:"mode"`
+	Results []SearchResult         `json:"results"`
+	Total   int64                  `json:"total"`
+	Page    int                    `json:"page"`
+	PerPage int                    `json:"perPage"`
+	Filters map[string]interface{} `json:"filters"`
+}
+
+// searchFilter - фильтры поиска, общие для полнотекстового и нечеткого поиска
+type searchFilter struct {
+	MinPrice     float64
+	MaxPrice     float64
+	Source       string
+	PageURL      string
+	WithDiscount bool
+	Dimension    string
+	MinUnitPrice float64
+	MaxUnitPrice float64
+}
+
+func (app *Application) parseSearchFilter(query map[string][]string) searchFilter {
+	get := func(key string) string {
+		if values := query[key]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	return searchFilter{
+		MinPrice:     app.getQueryFloat(query, "min_price", 0),
+		MaxPrice:     app.getQueryFloat(query, "max_price", 0),
+		Source:       get("source"),
+		PageURL:      get("page_url"),
+		WithDiscount: get("discount") == "true",
+		Dimension:    get("dimension"),
+		MinUnitPrice: app.getQueryFloat(query, "min_unit_price", 0),
+		MaxUnitPrice: app.getQueryFloat(query, "max_unit_price", 0),
+	}
+}
+
+func (f searchFilter) validate() error {
+	switch f.Dimension {
+	case "", DimensionMass, DimensionVolume, DimensionCount:
+		return nil
+	}
+	return fmt.Errorf("dimension must be one of mass, volume, count")
+}
+
+func (f searchFilter) apply(query *gorm.DB) *gorm.DB {
+	if f.MinPrice > 0 {
+		query = query.Where("products.price >= ?", f.MinPrice)
+	}
+	if f.MaxPrice > 0 {
+		query = query.Where("products.price <= ?", f.MaxPrice)
+	}
+	if f.Source != "" {
+		query = query.Where("products.source = ?", f.Source)
+	}
+	if f.PageURL != "" {
+		query = query.Where("products.page_url = ?", f.PageURL)
+	}
+	if f.WithDiscount {
+		query = query.Where("products.discount IS NOT NULL AND products.discount > 0")
+	}
+	if f.Dimension != "" {
+		query = query.Where("products.unit_dimension = ?", f.Dimension)
+	}
+	if f.MinUnitPrice > 0 {
+		query = query.Where("products.unit_price >= ?", f.MinUnitPrice)
+	}
+	if f.MaxUnitPrice > 0 {
+		query = query.Where("products.unit_price <= ?", f.MaxUnitPrice)
+	}
+	return query
+}
+
+// То же условие, что и apply, для хранилища в памяти
+func (f searchFilter) matches(p *Product) bool {
+	switch {
+	case f.MinPrice > 0 && p.Price < f.MinPrice,
+		f.MaxPrice > 0 && p.Price > f.MaxPrice,
+		f.Source != "" && p.Source != f.Source,
+		f.PageURL != "" && p.PageURL != f.PageURL,
+		f.WithDiscount && (p.Discount == nil || *p.Discount <= 0),
+		f.Dimension != "" && p.UnitDimension != f.Dimension,
+		f.MinUnitPrice > 0 && (p.UnitPrice == nil || *p.UnitPrice < f.MinUnitPrice),
+		f.MaxUnitPrice > 0 && (p.UnitPrice == nil || *p.UnitPrice > f.MaxUnitPrice):
+		return false
+	}
+	return true
+}
+
+func (f searchFilter) toMap() map[string]interface{} {
+	return map[string]interface{}{
+		"minPrice":     f.MinPrice,
+		"maxPrice":     f.MaxPrice,
+		"source":       f.Source,
+		"pageUrl":      f.PageURL,
+		"withDiscount": f.WithDiscount,
+		"dimension":    f.Dimension,
+		"minUnitPrice": f.MinUnitPrice,
+		"maxUnitPrice": f.MaxUnitPrice,
+	}
+}
+
+// Обработчик полнотекстового поиска продуктов
+func (app *Application) searchProductsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		app.respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	searchQuery := query.Get("q")
+	if searchQuery == "" {
+		app.respondWithError(w, http.StatusBadRequest, "Search query is required")
+		return
+	}
+
+	order, ok := searchSortOrders[query.Get("sort")]
+	if !ok {
+		app.respondWithError(w, http.StatusBadRequest, "sort must be one of rank, price_asc, price_desc, newest, unit_price_asc, unit_price_desc")
+		return
+	}
+
+	// Режим: fts - только полнотекстовый, fuzzy - только по похожести,
+	// auto - полнотекстовый, а если он ничего не нашел, то по похожести
+	mode := query.Get("mode")
+	if mode == "" {
+		mode = "auto"
+	}
+	if mode != "auto" && mode != "fts" && mode != "fuzzy" {
+		app.respondWithError(w, http.StatusBadRequest, "mode must be one of auto, fts, fuzzy")
+		return
+	}
+
+	page := app.getQueryInt(query, "page", 1)
+	perPage := app.getQueryInt(query, "per_page", 20)
+	filter := app.parseSearchFilter(query)
+	if err := filter.validate(); err != nil {
+		app.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var results []SearchResult
+	var total int64
+	var err error
+	if mode != "fuzzy" {
+		results, total, err = app.searchProducts(searchQuery, filter, order, page, perPage)
+		if err == nil && mode == "auto" && total == 0 {
+			mode = "fuzzy"
+		} else if mode == "auto" {
+			mode = "fts"
+		}
+	}
+	if err == nil && mode == "fuzzy" {
+		results, total, err = app.fuzzySearchProducts(searchQuery, filter, order, page, perPage)
+	}
+
+	if err != nil {
+		log.Printf("Error searching products: %v", err)
+		app.respondWithError(w, http.StatusInternalServerError, "Failed to search products")
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, SearchProductsResponse{
+		Success: true,
+		Query:   searchQuery,
+		Mode:    mode,
+		Results: results,
+		Total:   total,
+		Page:    page,
+		PerPage: perPage,
+		Filters: filter.toMap(),
+	})
+}
+
+func (app *Application) searchProducts(q string, filter searchFilter, order string, page, perPage int) ([]SearchResult, int64, error) {
+	if !app.isPostgres() {
+		return app.searchProductsSQLite(q, filter, order, page, perPage)
+	}
+
+	base := filter.apply(
+		app.db.Table("products, websearch_to_tsquery('russian', ?) AS query", q).
+			Where("products.search_vector @@ query"),
+	)
+
+	var total int64
+	if err := base.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	results := []SearchResult{}
+	offset := (page - 1) * perPage
+	err := base.Session(&gorm.Session{}).
+		Select("products.*, ts_rank_cd(products.search_vector, query) AS rank, "+
+			"ts_headline('russian', products.name || ' ' || coalesce(products.element_text, ''), query, ?) AS snippet",
+			searchHeadlineOptions).
+		Order(order).
+		Offset(offset).
+		Limit(perPage).
+		Scan(&results).Error
+
+	return results, total, err
+}
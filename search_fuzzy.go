@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultSuggestLimit = 10
+	maxSuggestLimit     = 50
+)
+
+type Suggestion struct {
+	Name  string  `json:"name"`
+	Score float64 `json:"score"`
+}
+
+type SuggestResponse struct {
+	Success     bool         `json:"success"`
+	Query       string       `json:"query"`
+	Suggestions []Suggestion `json:"suggestions"`
+}
+
+// Выражение "максимальная похожесть по всем вариантам запроса" и условие для индекса.
+// В SQLite функции pg_trgm зарегистрированы в search_sqlite.go, а вместо оператора - порог.
+func (app *Application) trigramMatch(fn, op string, threshold float64, variants []string) (score string, cond string, args []interface{}) {
+	scores := make([]string, 0, len(variants))
+	conds := make([]string, 0, len(variants))
+	for range variants {
+		scores = append(scores, fn+"(?, LOWER(products.name))")
+		if app.isPostgres() {
+			conds = append(conds, "? "+op+" LOWER(products.name)")
+		} else {
+			conds = append(conds, fmt.Sprintf("%s(?, products.name) >= %g", fn, threshold))
+		}
+	}
+	for _, v := range variants {
+		args = append(args, v)
+	}
+
+	switch {
+	case len(scores) == 1:
+		score = scores[0]
+	case app.isPostgres():
+		score = "GREATEST(" + strings.Join(scores, ", ") + ")"
+	default:
+		// max() от нескольких аргументов в SQLite - скалярная функция
+		score = "max(" + strings.Join(scores, ", ") + ")"
+	}
+	return score, "(" + strings.Join(conds, " OR ") + ")", args
+}
+
+// Поиск по похожести названия с учетом опечаток, транслитерации и раскладки
+func (app *Application) fuzzySearchProducts(q string, filter searchFilter, order string, page, perPage int) ([]SearchResult, int64, error) {
+	variants := queryVariants(q)
+	score, cond, args := app.trigramMatch("similarity", "%", trigramSimilarityThreshold, variants)
+
+	base := filter.apply(app.db.Table("products").Where(cond, args...))
+
+	var total int64
+	if err := base.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	results := []SearchResult{}
+	offset := (page - 1) * perPage
+	err := base.Session(&gorm.Session{}).
+		Select("products.*, "+score+" AS rank, products.name AS snippet", args...).
+		Order(order).
+		Offset(offset).
+		Limit(perPage).
+		Scan(&results).Error
+
+	return results, total, err
+}
+
+// Обработчик автодополнения названий продуктов
+func (app *Application) suggestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		app.respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		app.respondWithError(w, http.StatusBadRequest, "Search query is required")
+		return
+	}
+
+	limit := app.getQueryInt(query, "limit", defaultSuggestLimit)
+	if limit > maxSuggestLimit {
+		limit = maxSuggestLimit
+	}
+
+	variants := queryVariants(q)
+	score, cond, args := app.trigramMatch("word_similarity", "<%", trigramWordSimilarityThreshold, variants)
+
+	suggestions := []Suggestion{}
+	err := app.db.Table("products").
+		Select("products.name AS name, MAX("+score+") AS score", args...).
+		Where(cond, args...).
+		Group("products.name").
+		Order("score DESC, name ASC").
+		Limit(limit).
+		Scan(&suggestions).Error
+
+	if err != nil {
+		log.Printf("Error getting suggestions: %v", err)
+		app.respondWithError(w, http.StatusInternalServerError, "Failed to get suggestions")
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, SuggestResponse{
+		Success:     true,
+		Query:       q,
+		Suggestions: suggestions,
+	})
+}
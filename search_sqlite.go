@@ -0,0 +1,172 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"unicode"
+
+	sqlitedriver "github.com/glebarez/go-sqlite"
+	"gorm.io/gorm"
+)
+
+// Пороги pg_trgm по умолчанию (pg_trgm.similarity_threshold и pg_trgm.word_similarity_threshold).
+// В SQLite операторов % и <% нет - условие записывается через функцию и порог.
+const (
+	trigramSimilarityThreshold     = 0.3
+	trigramWordSimilarityThreshold = 0.6
+)
+
+// Окончания, которые отбрасываются перед префиксным поиском: стеммера для русского в FTS5 нет
+const russianEndingLetters = "аеёиоуыэюяйь"
+
+// similarity и word_similarity для SQLite - те же функции, что в pg_trgm, чтобы нечеткий поиск
+// и подсказки работали одинаково на обоих драйверах. Доступны во всех соединениях, открытых после init.
+func init() {
+	register := func(name string, fn func(a, b string) float64) {
+		sqlitedriver.MustRegisterDeterministicScalarFunction(name, 2, func(_ *sqlitedriver.FunctionContext, args []driver.Value) (driver.Value, error) {
+			a, aOK := args[0].(string)
+			b, bOK := args[1].(string)
+			if !aOK || !bOK {
+				return nil, nil
+			}
+			return fn(a, b), nil
+		})
+	}
+	register("similarity", trigramSimilarity)
+	register("word_similarity", trigramWordSimilarity)
+}
+
+// Триграммы строки в порядке следования, как их строит pg_trgm: строка приводится к нижнему
+// регистру и делится на слова из букв и цифр, каждое слово дополняется двумя пробелами в начале
+// и одним в конце
+func trigrams(s string) []string {
+	var result []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			result = append(result, string(padded[i:i+3]))
+		}
+	}
+	return result
+}
+
+func trigramSet(list []string) map[string]bool {
+	set := make(map[string]bool, len(list))
+	for _, t := range list {
+		set[t] = true
+	}
+	return set
+}
+
+// Доля общих триграмм двух строк, как similarity() в pg_trgm
+func trigramSimilarity(a, b string) float64 {
+	setA, setB := trigramSet(trigrams(a)), trigramSet(trigrams(b))
+	if len(setA) == 0 || len(setB) == 0 {
+		return 0
+	}
+	common := 0
+	for t := range setA {
+		if setB[t] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(setA)+len(setB)-common)
+}
+
+// Наибольшая похожесть a на непрерывный отрезок триграмм b, как word_similarity() в pg_trgm:
+// "мол" почти полностью совпадает с началом "молоко простоквашино"
+func trigramWordSimilarity(a, b string) float64 {
+	setA := trigramSet(trigrams(a))
+	listB := trigrams(b)
+	if len(setA) == 0 || len(listB) == 0 {
+		return 0
+	}
+
+	best := 0.0
+	for start := range listB {
+		extent := make(map[string]bool)
+		common := 0
+		for _, t := range listB[start:] {
+			if !extent[t] {
+				extent[t] = true
+				if setA[t] {
+					common++
+				}
+			}
+			if sml := float64(common) / float64(len(setA)+len(extent)-common); sml > best {
+				best = sml
+			}
+		}
+	}
+	return best
+}
+
+// Переводит поисковый запрос в запрос FTS5. Каждое слово ищется как префикс его основы
+// ("молока" -> "молок"*), "-слово" исключает совпадения. Пустая строка - искать нечего.
+func ftsMatchQuery(q string) string {
+	var include, exclude []string
+	for _, field := range strings.Fields(strings.ToLower(q)) {
+		negative := strings.HasPrefix(field, "-")
+		for _, word := range strings.FieldsFunc(field, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			term := `"` + ftsStem(word) + `"*`
+			if negative {
+				exclude = append(exclude, term)
+			} else {
+				include = append(include, term)
+			}
+		}
+	}
+	if len(include) == 0 {
+		return ""
+	}
+
+	match := strings.Join(include, " ")
+	for _, term := range exclude {
+		match += " NOT " + term
+	}
+	return match
+}
+
+// Отбрасывает до двух гласных (и й, ь) в конце слова, оставляя не меньше трех букв
+func ftsStem(word string) string {
+	runes := []rune(word)
+	for i := 0; i < 2 && len(runes) > 3 && strings.ContainsRune(russianEndingLetters, runes[len(runes)-1]); i++ {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes)
+}
+
+// Полнотекстовый поиск в SQLite по индексу products_fts (FTS5, миграция 0006)
+func (app *Application) searchProductsSQLite(q string, filter searchFilter, order string, page, perPage int) ([]SearchResult, int64, error) {
+	results := []SearchResult{}
+	match := ftsMatchQuery(q)
+	if match == "" {
+		return results, 0, nil
+	}
+
+	base := filter.apply(
+		app.db.Table("products").
+			Joins("JOIN products_fts ON products_fts.rowid = products.seq").
+			Where("products_fts MATCH ?", match),
+	)
+
+	var total int64
+	if err := base.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * perPage
+	err := base.Session(&gorm.Session{}).
+		Select("products.*, -bm25(products_fts) AS rank, " +
+			"snippet(products_fts, -1, '<b>', '</b>', ' … ', 25) AS snippet").
+		Order(order).
+		Offset(offset).
+		Limit(perPage).
+		Scan(&results).Error
+
+	return results, total, err
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"testing"
+)
+
+func TestTrigramSimilarity(t *testing.T) {
+	// Ожидаемые значения - ответы pg_trgm на тех же строках
+	tests := []struct {
+		fn   func(a, b string) float64
+		name string
+		a, b string
+		want float64
+	}{
+		{trigramSimilarity, "similarity", "word", "two words", 4.0 / 11},
+		{trigramSimilarity, "similarity same", "Молоко", "молоко", 1},
+		{trigramSimilarity, "similarity typo", "коктейл", "Молочный коктейль", 7.0 / 19},
+		{trigramSimilarity, "similarity empty", "", "молоко", 0},
+		{trigramWordSimilarity, "word_similarity", "word", "two words", 0.8},
+		{trigramWordSimilarity, "word_similarity prefix", "мол", "Молоко Простоквашино", 0.75},
+		{trigramWordSimilarity, "word_similarity whole word", "молоко", "Молоко Домик в деревне 2,5%", 1},
+		{trigramWordSimilarity, "word_similarity no match", "кефир", "Молоко", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.fn(tt.a, tt.b); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("%s(%q, %q) = %v, want %v", tt.name, tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFTSMatchQuery(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"молока", `"молок"*`},
+		{"Молоко 3,2%", `"молок"* "3"* "2"*`},
+		{"сыра -плавленый", `"сыр"* NOT "плавлен"*`},
+		{"чай", `"чай"*`},
+		{"кофе", `"коф"*`},
+		{"-молоко", ""},
+		{`"` + " -- ", ""},
+	}
+	for _, tt := range tests {
+		if got := ftsMatchQuery(tt.in); got != tt.want {
+			t.Errorf("ftsMatchQuery(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// SQLite не обещает сохранять неявный rowid при VACUUM, поэтому индекс ключуется по seq:
+// после удаления строки и VACUUM поиск должен находить те же товары
+func TestProductsFTSSurvivesVacuum(t *testing.T) {
+	db := openTestSQLite(t)
+	if _, err := migrateUp(db); err != nil {
+		t.Fatalf("migrateUp: %v", err)
+	}
+	api := newTestAPI(t, NewSQLiteStore(db))
+	expectStatus(t, api.do(t, http.MethodPost, "/api/v1/page-data", samplePageData(), true), http.StatusCreated)
+
+	if err := db.Exec("DELETE FROM products WHERE url = ?", "https://shop.example/p/1").Error; err != nil {
+		t.Fatalf("delete product: %v", err)
+	}
+	if err := db.Exec("VACUUM").Error; err != nil {
+		t.Fatalf("VACUUM: %v", err)
+	}
+
+	results, total, err := api.app.searchProductsSQLite("коктейль", searchFilter{}, "", 1, 10)
+	if err != nil {
+		t.Fatalf("searchProductsSQLite: %v", err)
+	}
+	if total != 1 || len(results) != 1 || results[0].Name != "Молочный коктейль" {
+		t.Errorf("after VACUUM: total=%d results=%+v", total, results)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Откуда взяты вес и единица продукта
+const (
+	SizeSupplied = "supplied" // прислал парсер
+	SizeInferred = "inferred" // извлечены из названия или текста карточки
+)
+
+// Написания единиц в названиях -> ключ unitDefinitions.
+// Длинные формы идут в регулярном выражении раньше коротких.
+var unitAliases = []struct{ alias, unit string }{
+	{"килограммов", "кг"}, {"килограмма", "кг"}, {"килограмм", "кг"}, {"кг", "кг"},
+	{"kilograms", "kg"}, {"kilogram", "kg"}, {"kgs", "kg"}, {"kg", "kg"},
+	{"миллилитров", "мл"}, {"миллилитра", "мл"}, {"миллилитр", "мл"}, {"мл", "мл"},
+	{"milliliters", "ml"}, {"millilitres", "ml"}, {"ml", "ml"},
+	{"миллиграммов", "мг"}, {"мг", "мг"}, {"mg", "mg"},
+	{"граммов", "г"}, {"грамма", "г"}, {"грамм", "г"}, {"гр", "г"}, {"г", "г"},
+	{"grams", "g"}, {"gram", "g"}, {"gr", "g"}, {"g", "g"},
+	{"литров", "л"}, {"литра", "л"}, {"литр", "л"}, {"л", "л"},
+	{"liters", "l"}, {"litres", "l"}, {"liter", "l"}, {"litre", "l"}, {"ltr", "l"}, {"l", "l"},
+	{"сл", "сл"}, {"cl", "cl"},
+	{"штук", "шт"}, {"штуки", "шт"}, {"шт", "шт"}, {"pcs", "pcs"}, {"pc", "pc"},
+}
+
+var (
+	unitAliasMap = func() map[string]string {
+		m := make(map[string]string, len(unitAliases))
+		for _, a := range unitAliases {
+			m[a.alias] = a.unit
+		}
+		return m
+	}()
+
+	// [N x] ЧИСЛО [- ЧИСЛО] ЕДИНИЦА [x N [шт]]: "930 мл", "2x250g", "1,2-1,5 кг", "100 г x 4 шт"
+	sizeRe = func() *regexp.Regexp {
+		aliases := make([]string, 0, len(unitAliases))
+		for _, a := range unitAliases {
+			aliases = append(aliases, regexp.QuoteMeta(a.alias))
+		}
+		number := `(\d+(?:[.,]\d+)?)`
+		times := `\s*[xх×*]\s*`
+		return regexp.MustCompile(`(?i)(?:(\d+)` + times + `)?` + number +
+			`(?:\s*[-–—]\s*` + number + `)?\s*(` + strings.Join(aliases, "|") + `)\.?` +
+			`(?:` + times + `(\d+)(?:\s*(?:шт|pcs)\.?)?)?`)
+	}()
+)
+
+// sizeMatch - размер, найденный в тексте; для мультипака вес суммарный
+type sizeMatch struct {
+	Weight float64
+	Unit   string
+}
+
+// Ищет размер в текстах по порядку. Масса и объем предпочтительнее штук:
+// у "Яйцо С0 10 шт 600 г" цену за единицу считаем за кг.
+func extractSize(texts ...string) (sizeMatch, bool) {
+	var count *sizeMatch
+	for _, text := range texts {
+		for _, m := range findSizes(text) {
+			def, _ := parseUnit(m.Unit)
+			if def.Dimension != DimensionCount {
+				return m, true
+			}
+			if count == nil {
+				m := m
+				count = &m
+			}
+		}
+	}
+	if count != nil {
+		return *count, true
+	}
+	return sizeMatch{}, false
+}
+
+func findSizes(text string) []sizeMatch {
+	var sizes []sizeMatch
+	for _, idx := range sizeRe.FindAllStringSubmatchIndex(text, -1) {
+		// Число не должно быть продолжением слова или другого числа ("C10", "1.5.2"),
+		// а единица - началом слова ("500 гречка")
+		if r, _ := utf8.DecodeLastRuneInString(text[:idx[0]]); idx[0] > 0 && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == ',') {
+			continue
+		}
+		if r, _ := utf8.DecodeRuneInString(text[idx[1]:]); idx[1] < len(text) && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			continue
+		}
+
+		group := func(n int) string {
+			if idx[2*n] < 0 {
+				return ""
+			}
+			return text[idx[2*n]:idx[2*n+1]]
+		}
+
+		value := parseDecimal(group(2))
+		if upper := group(3); upper != "" {
+			// Диапазон "1,2-1,5 кг" - берем середину
+			value = (value + parseDecimal(upper)) / 2
+		}
+		if value <= 0 {
+			continue
+		}
+
+		packs := 1.0
+		if n := group(1); n != "" {
+			packs, _ = strconv.ParseFloat(n, 64)
+		} else if n := group(5); n != "" {
+			packs, _ = strconv.ParseFloat(n, 64)
+		}
+		if packs <= 0 {
+			continue
+		}
+
+		sizes = append(sizes, sizeMatch{
+			Weight: math.Round(value*packs*1000) / 1000,
+			Unit:   unitAliasMap[strings.ToLower(group(4))],
+		})
+	}
+	return sizes
+}
+
+func parseDecimal(s string) float64 {
+	v, _ := strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+	return v
+}
+
+// Заполняет незаданные вес и единицу из названия и текста карточки и отмечает источник значений
+func (p *Product) inferSize() {
+	p.WeightSource, p.UnitSource = "", ""
+	if p.Weight != nil && *p.Weight > 0 {
+		p.WeightSource = SizeSupplied
+	}
+	if strings.TrimSpace(p.Unit) != "" {
+		p.UnitSource = SizeSupplied
+	}
+	if p.WeightSource == SizeSupplied && p.UnitSource == SizeSupplied {
+		return
+	}
+
+	size, ok := extractSize(p.Name, p.ElementText)
+	if !ok {
+		return
+	}
+
+	switch {
+	case p.WeightSource == "" && p.UnitSource == "":
+		weight := size.Weight
+		p.Weight, p.Unit = &weight, size.Unit
+		p.WeightSource, p.UnitSource = SizeInferred, SizeInferred
+
+	case p.WeightSource == "":
+		// Единица задана - переводим найденный размер в нее, если измерение совпадает
+		supplied, ok := parseUnit(p.Unit)
+		found, _ := parseUnit(size.Unit)
+		if !ok || supplied.Dimension != found.Dimension {
+			return
+		}
+		weight := math.Round(size.Weight*found.Factor/supplied.Factor*1000) / 1000
+		p.Weight = &weight
+		p.WeightSource = SizeInferred
+
+	default:
+		// Вес задан - единицу берем из названия, только если числа совпадают
+		if math.Abs(*p.Weight-size.Weight) < 0.001 {
+			p.Unit = size.Unit
+			p.UnitSource = SizeInferred
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import "testing"
+
+func TestExtractSize(t *testing.T) {
+	tests := []struct {
+		name   string
+		texts  []string
+		weight float64
+		unit   string
+		ok     bool
+	}{
+		{"milliliters", []string{"Молоко Простоквашино 3,2% 930 мл"}, 930, "мл", true},
+		{"no space", []string{"Молоко 2,5% 1л"}, 1, "л", true},
+		{"decimal comma", []string{"Вода минеральная 1,5 л"}, 1.5, "л", true},
+		{"decimal point", []string{"Сок яблочный 0.97 л"}, 0.97, "л", true},
+		{"grams", []string{"Сыр Российский 200 г"}, 200, "г", true},
+		{"gr abbreviation", []string{"Шоколад горький 90гр."}, 90, "г", true},
+		{"full word", []string{"Сахар 1 килограмм"}, 1, "кг", true},
+		{"genitive plural", []string{"Крупа 800 граммов"}, 800, "г", true},
+		{"kilograms", []string{"Картофель мытый 2,5 кг"}, 2.5, "кг", true},
+		{"english grams", []string{"Coffee Lavazza 250g"}, 250, "g", true},
+		{"english upper case", []string{"Juice 1L"}, 1, "l", true},
+		{"english ml", []string{"Shampoo 400 ml"}, 400, "ml", true},
+		{"multipack latin x", []string{"Кофе 2x250g"}, 500, "g", true},
+		{"multipack cyrillic х", []string{"Йогурт 4х125 г"}, 500, "г", true},
+		{"multipack asterisk", []string{"Пиво 6*0,5 л"}, 3, "л", true},
+		{"multipack suffix", []string{"Сырок 40 г x 4 шт"}, 160, "г", true},
+		{"multipack suffix times sign", []string{"Вода 0,33 л × 12"}, 3.96, "л", true},
+		{"range", []string{"Курица тушка 1,2-1,6 кг"}, 1.4, "кг", true},
+		{"range en dash", []string{"Свинина 700–900 г"}, 800, "г", true},
+		{"pieces", []string{"Яйцо куриное С1 10 шт"}, 10, "шт", true},
+		{"mass preferred over pieces", []string{"Яйцо С0 10 шт 600 г"}, 600, "г", true},
+		{"element text fallback", []string{"Молоко отборное", "Цена за 1 шт, объем 900 мл"}, 900, "мл", true},
+		{"name wins over element text", []string{"Кефир 1% 500 мл", "Кефир 1 л"}, 500, "мл", true},
+		{"percent is not a size", []string{"Сливки 10%"}, 0, "", false},
+		{"unit starts a word", []string{"Набор 500 гвоздей"}, 0, "", false},
+		{"number inside code", []string{"Модель X10L"}, 0, "", false},
+		{"no size", []string{"Хлеб Бородинский"}, 0, "", false},
+		{"empty", []string{""}, 0, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size, ok := extractSize(tt.texts...)
+			if ok != tt.ok {
+				t.Fatalf("extractSize(%q) ok = %v, want %v (got %+v)", tt.texts, ok, tt.ok, size)
+			}
+			if !ok {
+				return
+			}
+			if size.Weight != tt.weight || size.Unit != tt.unit {
+				t.Errorf("extractSize(%q) = %v %q, want %v %q", tt.texts, size.Weight, size.Unit, tt.weight, tt.unit)
+			}
+		})
+	}
+}
+
+func TestProductInferSize(t *testing.T) {
+	ptr := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name         string
+		product      Product
+		weight       *float64
+		unit         string
+		weightSource string
+		unitSource   string
+	}{
+		{
+			name:         "both inferred",
+			product:      Product{Name: "Молоко 3,2% 930 мл"},
+			weight:       ptr(930),
+			unit:         "мл",
+			weightSource: SizeInferred,
+			unitSource:   SizeInferred,
+		},
+		{
+			name:         "both supplied",
+			product:      Product{Name: "Молоко 3,2% 930 мл", Weight: ptr(0.93), Unit: "л"},
+			weight:       ptr(0.93),
+			unit:         "л",
+			weightSource: SizeSupplied,
+			unitSource:   SizeSupplied,
+		},
+		{
+			name:         "weight converted to supplied unit",
+			product:      Product{Name: "Кофе 2x250g", Unit: "кг"},
+			weight:       ptr(0.5),
+			unit:         "кг",
+			weightSource: SizeInferred,
+			unitSource:   SizeSupplied,
+		},
+		{
+			name:         "supplied unit of another dimension",
+			product:      Product{Name: "Яйцо 10 шт", Unit: "кг"},
+			weight:       nil,
+			unit:         "кг",
+			weightSource: "",
+			unitSource:   SizeSupplied,
+		},
+		{
+			name:         "unit inferred for matching weight",
+			product:      Product{Name: "Сыр 200 г", Weight: ptr(200)},
+			weight:       ptr(200),
+			unit:         "г",
+			weightSource: SizeSupplied,
+			unitSource:   SizeInferred,
+		},
+		{
+			name:         "unit not inferred for different weight",
+			product:      Product{Name: "Сыр 200 г", Weight: ptr(0.2)},
+			weight:       ptr(0.2),
+			unit:         "",
+			weightSource: SizeSupplied,
+			unitSource:   "",
+		},
+		{
+			name:         "nothing found",
+			product:      Product{Name: "Хлеб Бородинский"},
+			weight:       nil,
+			unit:         "",
+			weightSource: "",
+			unitSource:   "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.product
+			p.inferSize()
+
+			if (p.Weight == nil) != (tt.weight == nil) || (p.Weight != nil && *p.Weight != *tt.weight) {
+				t.Errorf("Weight = %v, want %v", derefFloat(p.Weight), derefFloat(tt.weight))
+			}
+			if p.Unit != tt.unit {
+				t.Errorf("Unit = %q, want %q", p.Unit, tt.unit)
+			}
+			if p.WeightSource != tt.weightSource || p.UnitSource != tt.unitSource {
+				t.Errorf("sources = %q/%q, want %q/%q", p.WeightSource, p.UnitSource, tt.weightSource, tt.unitSource)
+			}
+		})
+	}
+}
+
+func derefFloat(v *float64) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+func TestApplyUnitPriceUsesStoredWeight(t *testing.T) {
+	// Вес из парсера с лишними знаками: цена за единицу должна считаться по тому,
+	// что ляжет в decimal(11,3), а не по исходному значению
+	weight := 0.3333
+	p := Product{Price: 100, Weight: &weight, Unit: "кг"}
+	p.applyUnitPrice()
+
+	if p.Weight == nil || *p.Weight != 0.333 {
+		t.Fatalf("weight = %v, want 0.333", p.Weight)
+	}
+	if p.UnitPrice == nil || *p.UnitPrice != 300.3 || p.UnitDimension != DimensionMass {
+		t.Errorf("unitPrice = %v %q, want 300.3 %q", p.UnitPrice, p.UnitDimension, DimensionMass)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SnapshotSummary - краткая информация о снимке страницы без списка продуктов
+type SnapshotSummary struct {
+	ID           string    `json:"id"`
+	PageID       string    `json:"pageId"`
+	URL          string    `json:"url"`
+	PageTitle    string    `json:"pageTitle"`
+	PageInfo     PageInfo  `json:"pageInfo"`
+	Stats        Stats     `json:"stats"`
+	Success      bool      `json:"success"`
+	Timestamp    string    `json:"timestamp"`
+	ProductCount int64     `json:"productCount"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+type GetSnapshotsResponse struct {
+	Success   bool              `json:"success"`
+	Page      *Page             `json:"page"`
+	Snapshots []SnapshotSummary `json:"snapshots"`
+	Total     int64             `json:"total"`
+	PageNum   int               `json:"pageNum"`
+	PerPage   int               `json:"perPage"`
+}
+
+type GetSnapshotResponse struct {
+	Success  bool      `json:"success"`
+	Snapshot *PageData `json:"snapshot"`
+}
+
+// Обработчик для получения списка снимков страницы по url
+func (app *Application) getSnapshotsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		app.respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	url := query.Get("url")
+	if url == "" {
+		app.respondWithError(w, http.StatusBadRequest, "url parameter is required")
+		return
+	}
+
+	page := app.getQueryInt(query, "page", 1)
+	perPage := app.getQueryInt(query, "per_page", 20)
+
+	var pageRecord Page
+	if err := app.db.First(&pageRecord, "url = ?", url).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			app.respondWithError(w, http.StatusNotFound, "Page not found")
+		} else {
+			log.Printf("Error getting page: %v", err)
+			app.respondWithError(w, http.StatusInternalServerError, "Failed to get page")
+		}
+		return
+	}
+
+	var total int64
+	if err := app.db.Model(&PageData{}).Where("page_id = ?", pageRecord.ID).Count(&total).Error; err != nil {
+		log.Printf("Error counting snapshots: %v", err)
+		app.respondWithError(w, http.StatusInternalServerError, "Failed to get snapshots")
+		return
+	}
+
+	snapshots := make([]SnapshotSummary, 0, perPage)
+	offset := (page - 1) * perPage
+	err := app.db.Model(&PageData{}).
+		Select("page_data.id, page_data.page_id, page_data.url, page_data.page_title, page_data.page_info, page_data.stats, "+
+			"page_data.success, page_data.timestamp, page_data.created_at, "+
+			"(SELECT COUNT(*) FROM snapshot_products sp WHERE sp.page_data_id = page_data.id) AS product_count").
+		Where("page_data.page_id = ?", pageRecord.ID).
+		Order("page_data.created_at DESC").
+		Offset(offset).
+		Limit(perPage).
+		Scan(&snapshots).Error
+
+	if err != nil {
+		log.Printf("Error getting snapshots: %v", err)
+		app.respondWithError(w, http.StatusInternalServerError, "Failed to get snapshots")
+		return
+	}
+
+	response := GetSnapshotsResponse{
+		Success:   true,
+		Page:      &pageRecord,
+		Snapshots: snapshots,
+		Total:     total,
+		PageNum:   page,
+		PerPage:   perPage,
+	}
+
+	app.respondWithJSON(w, http.StatusOK, response)
+}
+
+// Обработчик для получения одного снимка вместе с продуктами в том виде, в котором они были спарсены
+func (app *Application) getSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		app.respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		app.respondWithError(w, http.StatusBadRequest, "id parameter is required")
+		return
+	}
+	if !isUUID(id) {
+		app.respondWithError(w, http.StatusBadRequest, "id must be a UUID")
+		return
+	}
+
+	snapshot, err := app.getSnapshot(id)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			app.respondWithError(w, http.StatusNotFound, "Snapshot not found")
+		} else {
+			log.Printf("Error getting snapshot: %v", err)
+			app.respondWithError(w, http.StatusInternalServerError, "Failed to get snapshot")
+		}
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, GetSnapshotResponse{
+		Success:  true,
+		Snapshot: snapshot,
+	})
+}
+
+// Загружает снимок и подставляет в продукты цены, зафиксированные в момент парсинга
+func (app *Application) getSnapshot(id string) (*PageData, error) {
+	var snapshot PageData
+	if err := app.db.First(&snapshot, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	if err := loadSnapshotProducts(app.db, &snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// Заполняет snapshot.Products составом снимка из snapshot_products
+func loadSnapshotProducts(db *gorm.DB, snapshot *PageData) error {
+	var items []SnapshotProduct
+	if err := db.Where("page_data_id = ?", snapshot.ID).Order("position ASC").Find(&items).Error; err != nil {
+		return err
+	}
+
+	productIDs := make([]string, 0, len(items))
+	for _, item := range items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+
+	var products []Product
+	if len(productIDs) > 0 {
+		if err := db.Where("id IN ?", productIDs).Find(&products).Error; err != nil {
+			return err
+		}
+	}
+
+	byID := make(map[string]Product, len(products))
+	for _, p := range products {
+		byID[p.ID] = p
+	}
+
+	snapshot.Products = make([]Product, 0, len(items))
+	for _, item := range items {
+		product, ok := byID[item.ProductID]
+		if !ok {
+			continue
+		}
+		product.Price = item.Price
+		product.OldPrice = item.OldPrice
+		product.Discount = item.Discount
+		snapshot.Products = append(snapshot.Products, product)
+	}
+	return nil
+}
+
+// Создает запись страницы или обновляет счетчик снимков, возвращает ID страницы
+func upsertPage(tx *gorm.DB, pageData *PageData) (string, error) {
+	now := time.Now()
+	page := Page{
+		URL:           pageData.URL,
+		Title:         pageData.PageTitle,
+		SnapshotCount: 1,
+		FirstSeenAt:   now,
+		LastSeenAt:    now,
+	}
+
+	err := tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "url"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"title":          gorm.Expr("COALESCE(NULLIF(EXCLUDED.title, ''), pages.title)"),
+			"snapshot_count": gorm.Expr("pages.snapshot_count + 1"),
+			"last_seen_at":   now,
+			"updated_at":     now,
+		}),
+	}).Create(&page).Error
+	if err != nil {
+		return "", fmt.Errorf("ошибка сохранения Page: %w", err)
+	}
+
+	return page.ID, nil
+}
+
+// Сохраняет состав снимка; вызывается после того, как продуктам присвоены ID
+func saveSnapshotProducts(tx *gorm.DB, pageData *PageData) error {
+	if len(pageData.Products) == 0 {
+		return nil
+	}
+
+	items := make([]SnapshotProduct, 0, len(pageData.Products))
+	for i, p := range pageData.Products {
+		items = append(items, SnapshotProduct{
+			PageDataID: pageData.ID,
+			Position:   i,
+			ProductID:  p.ID,
+			Price:      p.Price,
+			OldPrice:   p.OldPrice,
+			Discount:   p.Discount,
+		})
+	}
+
+	if err := tx.CreateInBatches(items, 500).Error; err != nil {
+		return fmt.Errorf("ошибка сохранения состава снимка: %w", err)
+	}
+	return nil
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
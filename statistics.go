@@ -0,0 +1,365 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Агрегаты цен по набору продуктов; считаются одним выражением SELECT.
+// Доля со скидкой считается в Go (setDiscountShare), перцентили - см. pricePercentilesSelect.
+const priceAggregatesSelect = `COUNT(*) AS products,
+	COALESCE(MIN(price), 0) AS min_price,
+	COALESCE(MAX(price), 0) AS max_price,
+	COALESCE(AVG(price), 0) AS avg_price,
+	COUNT(*) FILTER (WHERE discount IS NOT NULL AND discount > 0) AS with_discount,
+	COUNT(*) FILTER (WHERE weight IS NOT NULL) AS with_weight`
+
+// Перцентили цен в Postgres; в SQLite percentile_cont нет - они считаются в Go (fillPricePercentiles)
+const pricePercentilesSelect = `,
+	COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY price), 0) AS median,
+	COALESCE(percentile_cont(0.25) WITHIN GROUP (ORDER BY price), 0) AS p25,
+	COALESCE(percentile_cont(0.75) WITHIN GROUP (ORDER BY price), 0) AS p75,
+	COALESCE(percentile_cont(0.9) WITHIN GROUP (ORDER BY price), 0) AS p90,
+	COALESCE(percentile_cont(0.95) WITHIN GROUP (ORDER BY price), 0) AS p95`
+
+// Количество снимков за скользящие окна; границы окон - параметры из statisticsWindows.
+// Время последнего снимка выбирается отдельно (lastSnapshotSelect): в SQLite MAX от даты - строка.
+const ingestWindowsSelect = `COUNT(*) AS snapshots,
+	COUNT(*) FILTER (WHERE success) AS successful_snapshots,
+	COUNT(*) FILTER (WHERE created_at >= ?) AS last24h,
+	COUNT(*) FILTER (WHERE created_at >= ?) AS last7d,
+	COUNT(*) FILTER (WHERE created_at >= ?) AS last30d`
+
+// Границы окон 24 часа, 7 и 30 дней для ingestWindowsSelect
+func statisticsWindows(now time.Time) []interface{} {
+	return []interface{}{now.Add(-24 * time.Hour), now.Add(-7 * 24 * time.Hour), now.Add(-30 * 24 * time.Hour)}
+}
+
+type PriceAggregates struct {
+	Products      int64   `json:"products"`
+	MinPrice      float64 `json:"minPrice"`
+	MaxPrice      float64 `json:"maxPrice"`
+	AvgPrice      float64 `json:"avgPrice"`
+	Median        float64 `json:"median"`
+	P25           float64 `json:"p25"`
+	P75           float64 `json:"p75"`
+	P90           float64 `json:"p90"`
+	P95           float64 `json:"p95"`
+	WithDiscount  int64   `json:"withDiscount"`
+	DiscountShare float64 `json:"discountShare"`
+	WithWeight    int64   `json:"withWeight"`
+}
+
+type IngestWindows struct {
+	Snapshots           int64      `json:"snapshots"`
+	SuccessfulSnapshots int64      `json:"successfulSnapshots"`
+	Last24h             int64      `json:"last24h"`
+	Last7d              int64      `json:"last7d"`
+	Last30d             int64      `json:"last30d"`
+	LastSnapshotAt      *time.Time `json:"lastSnapshotAt,omitempty"`
+}
+
+type GlobalStatistics struct {
+	UniquePages int64 `json:"uniquePages"`
+	IngestWindows
+	PriceAggregates
+}
+
+type SourceStatistics struct {
+	Source string `json:"source"`
+	PriceAggregates
+	Observations24h int64 `json:"observations24h"`
+	Observations7d  int64 `json:"observations7d"`
+	Observations30d int64 `json:"observations30d"`
+}
+
+type PageURLStatistics struct {
+	PageURL string `json:"pageUrl"`
+	PriceAggregates
+	IngestWindows
+}
+
+type Statistics struct {
+	GeneratedAt time.Time           `json:"generatedAt"`
+	Global      GlobalStatistics    `json:"global"`
+	BySource    []SourceStatistics  `json:"bySource"`
+	ByPageURL   []PageURLStatistics `json:"byPageUrl"`
+}
+
+type GetStatisticsResponse struct {
+	Success bool        `json:"success"`
+	Stats   *Statistics `json:"stats"`
+}
+
+// statisticsFilter - необязательные ограничения по источнику и странице
+type statisticsFilter struct {
+	Source  string
+	PageURL string
+	Limit   int
+}
+
+// Обработчик статистики: общие итоги, разбивка по источникам и страницам
+func (app *Application) getStatisticsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		app.respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	filter := statisticsFilter{
+		Source:  query.Get("source"),
+		PageURL: query.Get("page_url"),
+		Limit:   app.getQueryInt(query, "limit", 50),
+	}
+
+	stats, err := app.getStatistics(filter)
+	if err != nil {
+		log.Printf("Error getting statistics: %v", err)
+		app.respondWithError(w, http.StatusInternalServerError, "Failed to get statistics")
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, GetStatisticsResponse{
+		Success: true,
+		Stats:   stats,
+	})
+}
+
+func (app *Application) getStatistics(filter statisticsFilter) (*Statistics, error) {
+	stats := &Statistics{
+		GeneratedAt: time.Now(),
+		BySource:    []SourceStatistics{},
+		ByPageURL:   []PageURLStatistics{},
+	}
+	windows := statisticsWindows(stats.GeneratedAt)
+	aggregates := priceAggregatesSelect
+	if app.isPostgres() {
+		aggregates += pricePercentilesSelect
+	}
+
+	// Снимки: при фильтре по источнику учитываются снимки, в которых он встречался
+	snapshots := func() *gorm.DB {
+		query := app.db.Table("page_data")
+		if filter.PageURL != "" {
+			query = query.Where("url = ?", filter.PageURL)
+		}
+		if filter.Source != "" {
+			query = query.Where("id IN (SELECT page_data_id FROM price_observations WHERE source = ?)", filter.Source)
+		}
+		return query
+	}
+	err := snapshots().
+		Select("COUNT(DISTINCT url) AS unique_pages, "+ingestWindowsSelect, windows...).
+		Scan(&stats.Global).Error
+	if err != nil {
+		return nil, err
+	}
+	var last []time.Time
+	if err := snapshots().Order("created_at DESC").Limit(1).Pluck("created_at", &last).Error; err != nil {
+		return nil, err
+	}
+	if len(last) > 0 {
+		stats.Global.LastSnapshotAt = &last[0]
+	}
+
+	err = filterProducts(app.db.Table("products"), filter).
+		Select(aggregates).
+		Scan(&stats.Global.PriceAggregates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// По источникам: агрегаты текущих цен и число наблюдений за окна
+	err = app.db.Raw(`WITH p AS (
+			SELECT source, `+aggregates+`
+			FROM products `+productFilterSQL(filter)+`
+			GROUP BY source
+		), o AS (
+			SELECT source,
+				COUNT(*) FILTER (WHERE observed_at >= ?) AS observations24h,
+				COUNT(*) FILTER (WHERE observed_at >= ?) AS observations7d,
+				COUNT(*) AS observations30d
+			FROM price_observations
+			WHERE observed_at >= ?
+			GROUP BY source
+		)
+		SELECT p.*, COALESCE(o.observations24h, 0) AS observations24h,
+			COALESCE(o.observations7d, 0) AS observations7d, COALESCE(o.observations30d, 0) AS observations30d
+		FROM p LEFT JOIN o ON o.source = p.source
+		ORDER BY p.products DESC
+		LIMIT ?`, append(append(productFilterArgs(filter), windows...), filter.Limit)...).
+		Scan(&stats.BySource).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// По страницам: агрегаты цен и окна снимков
+	err = app.db.Raw(`WITH p AS (
+			SELECT page_url, `+aggregates+`
+			FROM products `+productFilterSQL(filter)+`
+			GROUP BY page_url
+		), s AS (
+			SELECT url, `+ingestWindowsSelect+`
+			FROM page_data
+			GROUP BY url
+		)
+		SELECT p.*, COALESCE(s.snapshots, 0) AS snapshots, COALESCE(s.successful_snapshots, 0) AS successful_snapshots,
+			COALESCE(s.last24h, 0) AS last24h, COALESCE(s.last7d, 0) AS last7d, COALESCE(s.last30d, 0) AS last30d
+		FROM p LEFT JOIN s ON s.url = p.page_url
+		ORDER BY p.products DESC
+		LIMIT ?`, append(append(productFilterArgs(filter), windows...), filter.Limit)...).
+		Scan(&stats.ByPageURL).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := app.fillLastSnapshotAt(stats.ByPageURL); err != nil {
+		return nil, err
+	}
+
+	if !app.isPostgres() {
+		if err := app.fillPricePercentiles(filter, stats); err != nil {
+			return nil, err
+		}
+	}
+	stats.Global.setDiscountShare()
+	for i := range stats.BySource {
+		stats.BySource[i].setDiscountShare()
+	}
+	for i := range stats.ByPageURL {
+		stats.ByPageURL[i].setDiscountShare()
+	}
+
+	return stats, nil
+}
+
+// Время последнего снимка страниц. Выбирается сама колонка, а не MAX(created_at):
+// у выражения в SQLite нет типа колонки, и драйвер вернул бы строку вместо времени.
+func (app *Application) fillLastSnapshotAt(pages []PageURLStatistics) error {
+	if len(pages) == 0 {
+		return nil
+	}
+	urls := make([]string, 0, len(pages))
+	for _, p := range pages {
+		urls = append(urls, p.PageURL)
+	}
+
+	var rows []struct {
+		URL       string
+		CreatedAt time.Time
+	}
+	err := app.db.Table("page_data").
+		Select("url, created_at").
+		Where("url IN ? AND created_at = (SELECT MAX(pd.created_at) FROM page_data pd WHERE pd.url = page_data.url)", urls).
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	last := make(map[string]time.Time, len(rows))
+	for _, r := range rows {
+		last[r.URL] = r.CreatedAt
+	}
+	for i := range pages {
+		if t, ok := last[pages[i].PageURL]; ok {
+			pages[i].LastSnapshotAt = &t
+		}
+	}
+	return nil
+}
+
+// Перцентили цен для SQLite: цены выбираются по возрастанию и делятся по источникам и страницам
+func (app *Application) fillPricePercentiles(filter statisticsFilter, stats *Statistics) error {
+	var rows []struct {
+		Source  string
+		PageURL string
+		Price   float64
+	}
+	err := filterProducts(app.db.Table("products"), filter).
+		Select("source, page_url, price").
+		Order("price ASC").
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	all := make([]float64, 0, len(rows))
+	bySource := map[string][]float64{}
+	byPageURL := map[string][]float64{}
+	for _, r := range rows {
+		all = append(all, r.Price)
+		bySource[r.Source] = append(bySource[r.Source], r.Price)
+		byPageURL[r.PageURL] = append(byPageURL[r.PageURL], r.Price)
+	}
+
+	stats.Global.setPercentiles(all)
+	for i := range stats.BySource {
+		stats.BySource[i].setPercentiles(bySource[stats.BySource[i].Source])
+	}
+	for i := range stats.ByPageURL {
+		stats.ByPageURL[i].setPercentiles(byPageURL[stats.ByPageURL[i].PageURL])
+	}
+	return nil
+}
+
+func (a *PriceAggregates) setPercentiles(sorted []float64) {
+	a.Median = percentileCont(sorted, 0.5)
+	a.P25 = percentileCont(sorted, 0.25)
+	a.P75 = percentileCont(sorted, 0.75)
+	a.P90 = percentileCont(sorted, 0.9)
+	a.P95 = percentileCont(sorted, 0.95)
+}
+
+func (a *PriceAggregates) setDiscountShare() {
+	a.DiscountShare = 0
+	if a.Products > 0 {
+		a.DiscountShare = float64(a.WithDiscount) / float64(a.Products)
+	}
+}
+
+// Перцентиль с линейной интерполяцией между соседними значениями, как percentile_cont в Postgres
+func percentileCont(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	pos := p * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	upper := int(math.Ceil(pos))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(pos-float64(lower))
+}
+
+func filterProducts(query *gorm.DB, filter statisticsFilter) *gorm.DB {
+	if filter.Source != "" {
+		query = query.Where("source = ?", filter.Source)
+	}
+	if filter.PageURL != "" {
+		query = query.Where("page_url = ?", filter.PageURL)
+	}
+	return query
+}
+
+func productFilterSQL(filter statisticsFilter) string {
+	switch {
+	case filter.Source != "" && filter.PageURL != "":
+		return "WHERE source = ? AND page_url = ?"
+	case filter.Source != "":
+		return "WHERE source = ?"
+	case filter.PageURL != "":
+		return "WHERE page_url = ?"
+	}
+	return ""
+}
+
+func productFilterArgs(filter statisticsFilter) []interface{} {
+	var args []interface{}
+	if filter.Source != "" {
+		args = append(args, filter.Source)
+	}
+	if filter.PageURL != "" {
+		args = append(args, filter.PageURL)
+	}
+	return args
+}
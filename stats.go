@@ -0,0 +1,266 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Допустимое относительное расхождение присланной статистики с пересчитанной
+const statsTolerance = 0.01
+
+// Размер пачки снимков при пересчете статистики сохраненных ранее данных
+const statsBackfillBatchSize = 500
+
+// Считает статистику снимка по его продуктам
+func computeStats(products []Product) Stats {
+	stats := Stats{TotalProducts: len(products)}
+	if len(products) == 0 {
+		return stats
+	}
+
+	var sum float64
+	for i, p := range products {
+		sum += p.Price
+		if i == 0 || p.Price < stats.MinPrice {
+			stats.MinPrice = p.Price
+		}
+		if p.Price > stats.MaxPrice {
+			stats.MaxPrice = p.Price
+		}
+		if p.Discount != nil && *p.Discount > 0 {
+			stats.WithDiscount++
+		}
+		if p.Weight != nil {
+			stats.WithWeight++
+		}
+	}
+	stats.AvgPrice = roundCents(sum / float64(len(products)))
+	return stats
+}
+
+// ReportedStats - статистика в том виде, в котором ее прислал парсер.
+// nil - поле не прислано; присланный ноль сравнивается как обычное значение.
+type ReportedStats struct {
+	AvgPrice      *float64 `json:"avgPrice,omitempty"`
+	MaxPrice      *float64 `json:"maxPrice,omitempty"`
+	MinPrice      *float64 `json:"minPrice,omitempty"`
+	TotalProducts *int     `json:"totalProducts,omitempty"`
+	WithDiscount  *int     `json:"withDiscount,omitempty"`
+	WithWeight    *int     `json:"withWeight,omitempty"`
+}
+
+func (s ReportedStats) Value() (driver.Value, error) {
+	return json.Marshal(s)
+}
+
+func (s *ReportedStats) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("failed to unmarshal ReportedStats value: %v", value)
+	}
+	return json.Unmarshal(bytes, s)
+}
+
+// Присланные значения без отсутствующих полей
+func (s ReportedStats) stats() Stats {
+	var stats Stats
+	if s.AvgPrice != nil {
+		stats.AvgPrice = *s.AvgPrice
+	}
+	if s.MaxPrice != nil {
+		stats.MaxPrice = *s.MaxPrice
+	}
+	if s.MinPrice != nil {
+		stats.MinPrice = *s.MinPrice
+	}
+	if s.TotalProducts != nil {
+		stats.TotalProducts = *s.TotalProducts
+	}
+	if s.WithDiscount != nil {
+		stats.WithDiscount = *s.WithDiscount
+	}
+	if s.WithWeight != nil {
+		stats.WithWeight = *s.WithWeight
+	}
+	return stats
+}
+
+// Разбирает PageData, запоминая, какие поля stats прислал парсер.
+// Уже нормализованные данные (payload задачи, выгрузка) несут reportedStats - он сохраняется как есть.
+func (pd *PageData) UnmarshalJSON(data []byte) error {
+	type plainPageData PageData
+	aux := struct {
+		*plainPageData
+		Stats         ReportedStats  `json:"stats"`
+		ReportedStats *ReportedStats `json:"reportedStats"`
+	}{plainPageData: (*plainPageData)(pd)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	pd.Stats = aux.Stats.stats()
+	pd.ReportedStats = &aux.Stats
+	if aux.ReportedStats != nil {
+		pd.ReportedStats = aux.ReportedStats
+	}
+	return nil
+}
+
+// Поля Stats в порядке проверки; имена совпадают с JSON
+var statsFields = []string{"totalProducts", "avgPrice", "minPrice", "maxPrice", "withDiscount", "withWeight"}
+
+func statsFieldValues(s Stats) map[string]float64 {
+	return map[string]float64{
+		"totalProducts": float64(s.TotalProducts),
+		"avgPrice":      s.AvgPrice,
+		"minPrice":      s.MinPrice,
+		"maxPrice":      s.MaxPrice,
+		"withDiscount":  float64(s.WithDiscount),
+		"withWeight":    float64(s.WithWeight),
+	}
+}
+
+// Значения только присланных полей
+func reportedFieldValues(s ReportedStats) map[string]float64 {
+	values := make(map[string]float64, len(statsFields))
+	set := func(name string, v *float64) {
+		if v != nil {
+			values[name] = *v
+		}
+	}
+	setInt := func(name string, v *int) {
+		if v != nil {
+			values[name] = float64(*v)
+		}
+	}
+	setInt("totalProducts", s.TotalProducts)
+	set("avgPrice", s.AvgPrice)
+	set("minPrice", s.MinPrice)
+	set("maxPrice", s.MaxPrice)
+	setInt("withDiscount", s.WithDiscount)
+	setInt("withWeight", s.WithWeight)
+	return values
+}
+
+// Поля, по которым присланная статистика расходится с ожидаемой больше допуска.
+// Не присланные поля не сравниваются.
+func statsDivergence(reported ReportedStats, expected Stats) []string {
+	reportedValues, expectedValues := reportedFieldValues(reported), statsFieldValues(expected)
+
+	var diverged []string
+	for _, name := range statsFields {
+		r, ok := reportedValues[name]
+		if !ok {
+			continue
+		}
+		e := expectedValues[name]
+		diff := math.Abs(r - e)
+		// Копейки на округлении среднего расхождением не считаем
+		if diff > 0.01 && diff > statsTolerance*math.Max(math.Abs(r), math.Abs(e)) {
+			diverged = append(diverged, name)
+		}
+	}
+	return diverged
+}
+
+// Пересчитывает Stats по продуктам; присланные значения остаются в ReportedStats
+func (pd *PageData) verifyStats() {
+	if pd.ReportedStats == nil {
+		pd.ReportedStats = &ReportedStats{}
+	}
+	pd.Stats = computeStats(pd.Products)
+
+	// Парсер не знает о весе, извлеченном из названий, - сравниваем с присланным
+	expected := pd.Stats
+	expected.WithWeight = 0
+	for _, p := range pd.Products {
+		if p.Weight != nil && p.WeightSource == SizeSupplied {
+			expected.WithWeight++
+		}
+	}
+
+	diverged := statsDivergence(*pd.ReportedStats, expected)
+	pd.StatsDivergent = len(diverged) > 0
+	pd.StatsDivergence = strings.Join(diverged, ",")
+}
+
+// Пересчитывает статистику снимков, сохраненных до появления проверки, по их составу
+// в snapshot_products. Миграция 0007_backfill_stats; db - транзакция миграции.
+func backfillStats(db *gorm.DB) error {
+	lastID := ""
+	for {
+		// Сохраненные stats читаются как ReportedStats, чтобы отсутствующие ключи не стали нулями
+		var stored []struct {
+			ID    string
+			Stats ReportedStats
+		}
+		err := db.Model(&PageData{}).Select("id", "stats").
+			Where("reported_stats IS NULL AND CAST(id AS TEXT) > ?", lastID).
+			Order("CAST(id AS TEXT) ASC").
+			Limit(statsBackfillBatchSize).
+			Find(&stored).Error
+		if err != nil {
+			return fmt.Errorf("ошибка пересчета статистики: %w", err)
+		}
+		if len(stored) == 0 {
+			return nil
+		}
+
+		snapshots := make([]PageData, 0, len(stored))
+		for _, s := range stored {
+			reported := s.Stats
+			snapshots = append(snapshots, PageData{ID: s.ID, ReportedStats: &reported})
+		}
+		lastID = snapshots[len(snapshots)-1].ID
+
+		ids := make([]string, 0, len(snapshots))
+		for _, s := range snapshots {
+			ids = append(ids, s.ID)
+		}
+
+		var rows []struct {
+			PageDataID   string
+			Price        float64
+			Discount     *float64
+			Weight       *float64
+			WeightSource string
+		}
+		err = db.Table("snapshot_products AS sp").
+			Select("sp.page_data_id, sp.price, sp.discount, p.weight, COALESCE(p.weight_source, '') AS weight_source").
+			Joins("JOIN products p ON p.id = sp.product_id").
+			Where("sp.page_data_id IN ?", ids).
+			Scan(&rows).Error
+		if err != nil {
+			return fmt.Errorf("ошибка пересчета статистики: %w", err)
+		}
+
+		products := make(map[string][]Product, len(snapshots))
+		for _, r := range rows {
+			products[r.PageDataID] = append(products[r.PageDataID], Product{
+				Price: r.Price, Discount: r.Discount, Weight: r.Weight, WeightSource: r.WeightSource,
+			})
+		}
+
+		for i := range snapshots {
+			s := &snapshots[i]
+			s.Products = products[s.ID]
+			s.verifyStats()
+			err := db.Model(&PageData{}).Where("id = ?", s.ID).Updates(map[string]interface{}{
+				"stats":            s.Stats,
+				"reported_stats":   s.ReportedStats,
+				"stats_divergent":  s.StatsDivergent,
+				"stats_divergence": s.StatsDivergence,
+			}).Error
+			if err != nil {
+				return fmt.Errorf("ошибка пересчета статистики: %w", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestVerifyStatsReportedFields(t *testing.T) {
+	products := `[{"name": "Молоко", "url": "https://shop.example/p/1", "price": 100, "discount": 10},
+		{"name": "Кефир", "url": "https://shop.example/p/2", "price": 50}]`
+
+	tests := []struct {
+		name  string
+		stats string
+		want  []string
+	}{
+		{"absent fields are not compared", `{"totalProducts": 2}`, nil},
+		{"no stats", `{}`, nil},
+		{"reported zero is compared", `{"totalProducts": 2, "minPrice": 0, "withWeight": 0}`, []string{"minPrice"}},
+		{"reported zero discount count", `{"withDiscount": 0}`, []string{"withDiscount"}},
+		{"all fields match", `{"totalProducts": 2, "avgPrice": 75, "minPrice": 50, "maxPrice": 100, "withDiscount": 1, "withWeight": 0}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pd PageData
+			if err := json.Unmarshal([]byte(`{"url": "https://shop.example/milk", "products": `+products+`, "stats": `+tt.stats+`}`), &pd); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			pd.verifyStats()
+
+			if got := statsDivergence(*pd.ReportedStats, pd.Stats); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("divergence = %v, want %v", got, tt.want)
+			}
+			if pd.StatsDivergent != (len(tt.want) > 0) {
+				t.Errorf("statsDivergent = %v", pd.StatsDivergent)
+			}
+
+			// Payload задачи в очереди - уже нормализованные данные: присланная статистика не должна
+			// подмениться пересчитанной
+			raw, err := json.Marshal(&pd)
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			var queued PageData
+			if err := json.Unmarshal(raw, &queued); err != nil {
+				t.Fatalf("unmarshal payload: %v", err)
+			}
+			if !reflect.DeepEqual(queued.ReportedStats, pd.ReportedStats) || queued.Stats != pd.Stats {
+				t.Errorf("payload round trip: reported %+v, stats %+v", queued.ReportedStats, queued.Stats)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrNotFound - запись не найдена в хранилище
+var ErrNotFound = errors.New("not found")
+
+// Store - хранилище снимков страниц и продуктов, с которым работают основные обработчики.
+// Остальные возможности (история цен, поиск, статистика и т.д.) пока работают с GORM напрямую
+// и с MemoryStore недоступны; различия SQL Postgres и SQLite решаются в них по app.isPostgres().
+type Store interface {
+	// Сохраняет снимок вместе с продуктами; проставляет ID, PageID и ID продуктов
+	SavePageData(pageData *PageData) error
+	// Снимки от новых к старым, с продуктами; второе значение - общее количество
+	ListPageData(q PageDataQuery) ([]PageData, int64, error)
+	GetProductByID(id string) (*Product, error)
+	GetProductByURL(url string) (*Product, error)
+	// Продукты по фильтру и сортировке из categorySortOrders
+	ListProducts(q ProductQuery) ([]Product, int64, error)
+}
+
+// PageDataQuery - параметры списка снимков
+type PageDataQuery struct {
+	StatsDivergent *bool  // nil - без фильтра
+	StatsField     string // снимки, у которых расходится это поле Stats
+	Page           int
+	PerPage        int
+}
+
+// ProductQuery - параметры списка продуктов
+type ProductQuery struct {
+	Filter  searchFilter
+	Sort    string // ключ categorySortOrders
+	Page    int
+	PerPage int
+}
+
+func (q PageDataQuery) offset() int { return (q.Page - 1) * q.PerPage }
+func (q ProductQuery) offset() int  { return (q.Page - 1) * q.PerPage }
+
+// Оборачивает обработчик, которому нужна SQL-база (app.db)
+func (app *Application) requireDB(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.db == nil {
+			app.respondNotSupported(w)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (app *Application) isPostgres() bool {
+	return app.db != nil && app.db.Dialector.Name() == driverPostgres
+}
+
+func (app *Application) respondNotSupported(w http.ResponseWriter) {
+	backend := "memory"
+	if app.db != nil {
+		backend = app.db.Dialector.Name()
+	}
+	app.respondWithError(w, http.StatusNotImplemented, fmt.Sprintf("Not supported by the %s storage backend", backend))
+}
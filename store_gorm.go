@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// gormStore - общая часть PostgresStore и SQLiteStore: запросы GORM одинаковы,
+// отличаются только выражения, которые в SQLite пишутся иначе
+type gormStore struct {
+	db *gorm.DB
+	// Условие "stats_divergence содержит поле ?"
+	statsFieldCond string
+}
+
+func (s *gormStore) SavePageData(pageData *PageData) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return savePageDataTx(tx, pageData)
+	})
+}
+
+func (s *gormStore) ListPageData(q PageDataQuery) ([]PageData, int64, error) {
+	filtered := s.db.Model(&PageData{})
+	if q.StatsDivergent != nil {
+		filtered = filtered.Where("stats_divergent = ?", *q.StatsDivergent)
+	}
+	if q.StatsField != "" {
+		filtered = filtered.Where(s.statsFieldCond, q.StatsField)
+	}
+
+	var total int64
+	if err := filtered.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var pageDataList []PageData
+	err := filtered.Session(&gorm.Session{}).
+		Order("created_at DESC").
+		Offset(q.offset()).
+		Limit(q.PerPage).
+		Find(&pageDataList).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Состав снимка - из snapshot_products: products.page_data_id указывает
+	// только на последний снимок, в котором продукт встречался
+	for i := range pageDataList {
+		if err := loadSnapshotProducts(s.db, &pageDataList[i]); err != nil {
+			return nil, 0, err
+		}
+	}
+	return pageDataList, total, nil
+}
+
+func (s *gormStore) GetProductByID(id string) (*Product, error) {
+	return s.firstProduct("id = ?", id)
+}
+
+func (s *gormStore) GetProductByURL(url string) (*Product, error) {
+	return s.firstProduct("url = ?", url)
+}
+
+func (s *gormStore) firstProduct(cond string, value string) (*Product, error) {
+	var product Product
+	if err := s.db.First(&product, cond, value).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &product, nil
+}
+
+func (s *gormStore) ListProducts(q ProductQuery) ([]Product, int64, error) {
+	var total int64
+	if err := q.Filter.apply(s.db.Model(&Product{})).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var products []Product
+	err := q.Filter.apply(s.db).
+		Order(categorySortOrders[q.Sort]).
+		Offset(q.offset()).
+		Limit(q.PerPage).
+		Find(&products).Error
+	return products, total, err
+}
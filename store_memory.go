@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore - хранилище в памяти процесса для тестов и локальных экспериментов.
+// Повторяет поведение PostgresStore: продукты объединяются по URL, снимок хранит
+// свой состав с ценами на момент сохранения.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	pages     map[string]string // url страницы -> PageID
+	snapshots []PageData        // в порядке сохранения, вместе с составом
+	products  map[string]*Product
+	byURL     map[string]string // url продукта -> ID
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		pages:    map[string]string{},
+		products: map[string]*Product{},
+		byURL:    map[string]string{},
+	}
+}
+
+func (s *MemoryStore) SavePageData(pageData *PageData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	pageID, ok := s.pages[pageData.URL]
+	if !ok {
+		pageID = newUUID()
+		s.pages[pageData.URL] = pageID
+	}
+
+	pageData.ID = newUUID()
+	pageData.PageID = &pageID
+	pageData.CreatedAt = now
+	pageData.UpdatedAt = now
+
+	// Как и ON CONFLICT (url) DO UPDATE: повторный URL обновляет существующий продукт
+	for i := range pageData.Products {
+		p := &pageData.Products[i]
+		p.PageDataID = &pageData.ID
+		p.UpdatedAt = now
+
+		if id, ok := s.byURL[p.URL]; ok {
+			existing := s.products[id]
+			p.ID = id
+			p.CreatedAt = existing.CreatedAt
+			p.CanonicalProductID = existing.CanonicalProductID
+			p.CanonicalLocked = existing.CanonicalLocked
+		} else {
+			p.ID = newUUID()
+			p.CreatedAt = now
+			s.byURL[p.URL] = p.ID
+		}
+
+		stored := *p
+		s.products[p.ID] = &stored
+	}
+
+	snapshot := *pageData
+	snapshot.Products = append([]Product{}, pageData.Products...)
+	s.snapshots = append(s.snapshots, snapshot)
+	return nil
+}
+
+func (s *MemoryStore) ListPageData(q PageDataQuery) ([]PageData, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matched []PageData
+	for i := len(s.snapshots) - 1; i >= 0; i-- {
+		snapshot := s.snapshots[i]
+		if q.StatsDivergent != nil && snapshot.StatsDivergent != *q.StatsDivergent {
+			continue
+		}
+		if q.StatsField != "" && !containsString(strings.Split(snapshot.StatsDivergence, ","), q.StatsField) {
+			continue
+		}
+		matched = append(matched, snapshot)
+	}
+
+	total := int64(len(matched))
+	matched = paginate(matched, q.offset(), q.PerPage)
+	for i := range matched {
+		matched[i].Products = append([]Product{}, matched[i].Products...)
+	}
+	return matched, total, nil
+}
+
+func (s *MemoryStore) GetProductByID(id string) (*Product, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.products[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	product := *p
+	return &product, nil
+}
+
+func (s *MemoryStore) GetProductByURL(url string) (*Product, error) {
+	s.mu.RLock()
+	id, ok := s.byURL[url]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return s.GetProductByID(id)
+}
+
+func (s *MemoryStore) ListProducts(q ProductQuery) ([]Product, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	less, ok := memoryProductOrders[q.Sort]
+	if !ok {
+		return nil, 0, fmt.Errorf("unsupported sort %q", q.Sort)
+	}
+
+	var matched []Product
+	for _, p := range s.products {
+		if q.Filter.matches(p) {
+			matched = append(matched, *p)
+		}
+	}
+	// Порядок обхода map случаен - при равных ключах сортировки упорядочиваем по URL
+	sort.Slice(matched, func(i, j int) bool { return matched[i].URL < matched[j].URL })
+	sort.SliceStable(matched, func(i, j int) bool { return less(&matched[i], &matched[j]) })
+
+	total := int64(len(matched))
+	return paginate(matched, q.offset(), q.PerPage), total, nil
+}
+
+// Сортировки MemoryStore - те же ключи, что и в categorySortOrders
+var memoryProductOrders = map[string]func(a, b *Product) bool{
+	"":                newerFirst,
+	"newest":          newerFirst,
+	"price_asc":       func(a, b *Product) bool { return a.Price < b.Price },
+	"price_desc":      func(a, b *Product) bool { return a.Price > b.Price },
+	"unit_price_asc":  func(a, b *Product) bool { return compareUnitPrice(a, b, false) },
+	"unit_price_desc": func(a, b *Product) bool { return compareUnitPrice(a, b, true) },
+}
+
+func newerFirst(a, b *Product) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+	return a.URL < b.URL
+}
+
+// Продукты без цены за единицу - в конце (NULLS LAST), при равенстве - по цене
+func compareUnitPrice(a, b *Product, desc bool) bool {
+	switch {
+	case a.UnitPrice == nil || b.UnitPrice == nil:
+		return a.UnitPrice != nil && b.UnitPrice == nil
+	case *a.UnitPrice != *b.UnitPrice:
+		if desc {
+			return *a.UnitPrice > *b.UnitPrice
+		}
+		return *a.UnitPrice < *b.UnitPrice
+	case desc:
+		return a.Price > b.Price
+	}
+	return a.Price < b.Price
+}
+
+func paginate[T any](items []T, offset, limit int) []T {
+	if offset >= len(items) {
+		return []T{}
+	}
+	end := offset + limit
+	if end > len(items) {
+		end = len(items)
+	}
+	return items[offset:end]
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Случайный UUID версии 4, как gen_random_uuid() в Postgres
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,15 @@
+package main
+
+import "gorm.io/gorm"
+
+// PostgresStore - хранилище на GORM и Postgres
+type PostgresStore struct {
+	gormStore
+}
+
+func NewPostgresStore(db *gorm.DB) *PostgresStore {
+	return &PostgresStore{gormStore{
+		db:             db,
+		statsFieldCond: "? = ANY(string_to_array(stats_divergence, ','))",
+	}}
+}
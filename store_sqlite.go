@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// SQLiteStore - хранилище в файле SQLite для одного узла и офлайн-работы.
+// Снимки сохраняются тем же savePageDataTx, что и в Postgres.
+type SQLiteStore struct {
+	gormStore
+}
+
+func NewSQLiteStore(db *gorm.DB) *SQLiteStore {
+	return &SQLiteStore{gormStore{
+		db:             db,
+		statsFieldCond: "instr(',' || stats_divergence || ',', ',' || ? || ',') > 0",
+	}}
+}
+
+// Открывает (или создает) файл базы; схему создают миграции из migrations/sqlite
+func openSQLite(path string, config *gorm.Config) (*gorm.DB, error) {
+	// WAL и busy_timeout: читатели не блокируют запись, а писатели ждут друг друга вместо ошибки SQLITE_BUSY
+	dsn := path + "?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)"
+	db, err := gorm.Open(sqlite.Open(dsn), config)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось открыть базу SQLite %s: %w", path, err)
+	}
+
+	// SQLite допускает одного писателя: одно соединение исключает SQLITE_BUSY внутри процесса
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("не удалось получить sql.DB: %w", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	return db, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Транслитерация кириллица -> латиница (упрощенная, как пишут на ценниках и в URL)
+var cyrillicToLatin = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya",
+}
+
+// Обратная транслитерация: сначала многобуквенные сочетания
+var latinToCyrillicMulti = []struct{ latin, cyrillic string }{
+	{"shch", "щ"}, {"sch", "щ"}, {"zh", "ж"}, {"kh", "х"}, {"ts", "ц"}, {"ch", "ч"},
+	{"sh", "ш"}, {"yu", "ю"}, {"ya", "я"}, {"yo", "ё"}, {"ye", "е"}, {"ph", "ф"},
+}
+
+var latinToCyrillicSingle = map[rune]string{
+	'a': "а", 'b': "б", 'c': "к", 'd': "д", 'e': "е", 'f': "ф", 'g': "г", 'h': "х",
+	'i': "и", 'j': "дж", 'k': "к", 'l': "л", 'm': "м", 'n': "н", 'o': "о", 'p': "п",
+	'q': "к", 'r': "р", 's': "с", 't': "т", 'u': "у", 'v': "в", 'w': "в", 'x': "кс",
+	'y': "й", 'z': "з",
+}
+
+// Раскладка клавиатуры: запрос, набранный не в той раскладке ("vjkjrj" -> "молоко")
+var (
+	qwertyKeys = []rune("qwertyuiop[]asdfghjkl;'zxcvbnm,.`")
+	jcukenKeys = []rune("йцукенгшщзхъфывапролджэячсмитьбюё")
+)
+
+func transliterateToLatin(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if latin, ok := cyrillicToLatin[r]; ok {
+			b.WriteString(latin)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func transliterateToCyrillic(s string) string {
+	lower := strings.ToLower(s)
+	var b strings.Builder
+	for i := 0; i < len(lower); {
+		matched := false
+		for _, m := range latinToCyrillicMulti {
+			if strings.HasPrefix(lower[i:], m.latin) {
+				b.WriteString(m.cyrillic)
+				i += len(m.latin)
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(lower[i:])
+		if cyr, ok := latinToCyrillicSingle[r]; ok {
+			b.WriteString(cyr)
+		} else {
+			b.WriteRune(r)
+		}
+		i += size
+	}
+	return b.String()
+}
+
+func swapKeyboardLayout(s string) string {
+	lower := []rune(strings.ToLower(s))
+	for i, r := range lower {
+		for j, k := range qwertyKeys {
+			if r == k {
+				lower[i] = jcukenKeys[j]
+				break
+			}
+			if r == jcukenKeys[j] {
+				lower[i] = k
+				break
+			}
+		}
+	}
+	return string(lower)
+}
+
+// Варианты написания запроса для нечеткого поиска: исходный, транслитерации и смена раскладки
+func queryVariants(q string) []string {
+	q = strings.ToLower(strings.TrimSpace(q))
+	candidates := []string{q, transliterateToCyrillic(q), transliterateToLatin(q), swapKeyboardLayout(q)}
+
+	seen := make(map[string]bool, len(candidates))
+	variants := make([]string, 0, len(candidates))
+	for _, v := range candidates {
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		variants = append(variants, v)
+	}
+	return variants
+}
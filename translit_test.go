@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTransliteration(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func(string) string
+		in   string
+		want string
+	}{
+		{"to latin", transliterateToLatin, "Молоко", "moloko"},
+		{"to latin multi-letter", transliterateToLatin, "Щука и хлеб", "shchuka i khleb"},
+		{"to latin keeps digits", transliterateToLatin, "Кефир 1%", "kefir 1%"},
+		{"to cyrillic", transliterateToCyrillic, "moloko", "молоко"},
+		{"to cyrillic multi-letter", transliterateToCyrillic, "Shchi", "щи"},
+		{"to cyrillic yo", transliterateToCyrillic, "yogurt", "ёгурт"},
+		{"to cyrillic keeps digits", transliterateToCyrillic, "Milk 1L", "милк 1л"},
+		{"wrong layout latin", swapKeyboardLayout, "vjkjrj", "молоко"},
+		{"wrong layout cyrillic", swapKeyboardLayout, "ьщдщлщ", "moloko"},
+		{"wrong layout short word", swapKeyboardLayout, "cshjr", "сырок"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.fn(tt.in); got != tt.want {
+				t.Errorf("%q -> %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryVariants(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"  Moloko ", []string{"moloko", "молоко", "ьщдщлщ"}},
+		{"vjkjrj", []string{"vjkjrj", "вджкджрдж", "молоко"}},
+		{"", []string{}},
+	}
+	for _, tt := range tests {
+		if got := queryVariants(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("queryVariants(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
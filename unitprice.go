@@ -0,0 +1,66 @@
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+// Измерения, к которым приводятся единицы товара; цена считается за кг, литр или штуку
+const (
+	DimensionMass   = "mass"
+	DimensionVolume = "volume"
+	DimensionCount  = "count"
+)
+
+// unitDef - измерение единицы и множитель перевода в кг, литры или штуки
+type unitDef struct {
+	Dimension string
+	Factor    float64
+}
+
+var unitDefinitions = map[string]unitDef{
+	"мг": {DimensionMass, 0.000001}, "mg": {DimensionMass, 0.000001},
+	"г": {DimensionMass, 0.001}, "гр": {DimensionMass, 0.001}, "g": {DimensionMass, 0.001}, "gr": {DimensionMass, 0.001},
+	"кг": {DimensionMass, 1}, "kg": {DimensionMass, 1},
+	"мл": {DimensionVolume, 0.001}, "ml": {DimensionVolume, 0.001},
+	"сл": {DimensionVolume, 0.01}, "cl": {DimensionVolume, 0.01},
+	"л": {DimensionVolume, 1}, "l": {DimensionVolume, 1},
+	"шт": {DimensionCount, 1}, "pcs": {DimensionCount, 1}, "pc": {DimensionCount, 1},
+}
+
+// Приводит единицу к ключу unitDefinitions: регистр, пробелы и точка сокращения ("шт.")
+func normalizeUnit(unit string) string {
+	return strings.TrimRight(strings.ToLower(strings.TrimSpace(unit)), ".")
+}
+
+func parseUnit(unit string) (unitDef, bool) {
+	def, ok := unitDefinitions[normalizeUnit(unit)]
+	return def, ok
+}
+
+// Цена за кг, литр или штуку; nil, если вес или единица не заданы или не распознаны
+func computeUnitPrice(price float64, weight *float64, unit string) (*float64, string) {
+	if weight == nil || *weight <= 0 || price <= 0 {
+		return nil, ""
+	}
+	def, ok := parseUnit(unit)
+	if !ok {
+		return nil, ""
+	}
+	value := roundCents(price / (*weight * def.Factor))
+	return &value, def.Dimension
+}
+
+// Вес хранится с точностью до трех знаков (decimal(11,3)); цена за единицу считается по
+// тому же округленному значению, чтобы сохраненные weight и unit_price не расходились
+func (p *Product) applyUnitPrice() {
+	if p.Weight != nil {
+		weight := roundWeight(*p.Weight)
+		p.Weight = &weight
+	}
+	p.UnitPrice, p.UnitDimension = computeUnitPrice(p.Price, p.Weight, p.Unit)
+}
+
+func roundWeight(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Размер пачки для upsert продуктов: колонки * 500 строк должны укладываться в лимит 65535 параметров Postgres
+const productUpsertBatchSize = 500
+
+// Колонки products, которые пишутся при upsert; порядок совпадает с productUpsertValues
+var productUpsertColumns = []string{
+	"discount", "element_text", "image", "name", "old_price", "page_title", "page_url", "price",
+	"source", "timestamp", "unit", "url", "weight", "page_data_id", "gtin", "sku", "unit_price", "unit_dimension",
+	"weight_source", "unit_source", "created_at", "updated_at",
+}
+
+func productUpsertValues(p *Product, now time.Time) []interface{} {
+	return []interface{}{
+		p.Discount, p.ElementText, p.Image, p.Name, p.OldPrice, p.PageTitle, p.PageURL, p.Price,
+		p.Source, p.Timestamp, p.Unit, p.URL, p.Weight, p.PageDataID, p.GTIN, p.SKU, p.UnitPrice, p.UnitDimension,
+		p.WeightSource, p.UnitSource, now, now,
+	}
+}
+
+// Сохраняет продукты снимка пачками INSERT ... ON CONFLICT (url) DO UPDATE и проставляет
+// полученные ID обратно в pageData.Products. Предыдущее состояние продуктов читается
+// одним SELECT на пачку - оно нужно для ленты изменений цен.
+func upsertProducts(tx *gorm.DB, pageData *PageData, prevSnapshotID *string) ([]PriceChange, error) {
+	for i := range pageData.Products {
+		pageData.Products[i].PageDataID = &pageData.ID
+	}
+
+	// Один и тот же URL может встретиться на странице несколько раз: ON CONFLICT не умеет
+	// обновлять строку дважды за команду, поэтому пишем последнее вхождение
+	positions := make(map[string][]int, len(pageData.Products))
+	urls := make([]string, 0, len(pageData.Products))
+	for i, p := range pageData.Products {
+		if _, ok := positions[p.URL]; !ok {
+			urls = append(urls, p.URL)
+		}
+		positions[p.URL] = append(positions[p.URL], i)
+	}
+
+	var changes []PriceChange
+	now := time.Now()
+
+	for start := 0; start < len(urls); start += productUpsertBatchSize {
+		end := start + productUpsertBatchSize
+		if end > len(urls) {
+			end = len(urls)
+		}
+		batch := urls[start:end]
+
+		var existing []Product
+		if err := tx.Where("url IN ?", batch).Find(&existing).Error; err != nil {
+			return nil, fmt.Errorf("ошибка проверки продуктов: %w", err)
+		}
+		existingByURL := make(map[string]*Product, len(existing))
+		for i := range existing {
+			existingByURL[existing[i].URL] = &existing[i]
+		}
+
+		ids, err := execProductUpsert(tx, pageData.Products, positions, batch, now)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, url := range batch {
+			idx := positions[url]
+			id, ok := ids[url]
+			if !ok {
+				return nil, fmt.Errorf("upsert не вернул ID продукта %q", url)
+			}
+			for _, i := range idx {
+				pageData.Products[i].ID = id
+				pageData.Products[i].CreatedAt = now
+				pageData.Products[i].UpdatedAt = now
+			}
+
+			current := &pageData.Products[idx[len(idx)-1]]
+			if prev, ok := existingByURL[url]; ok {
+				current.CreatedAt = prev.CreatedAt
+				if change := detectPriceChange(prev, current, prevSnapshotID); change != nil {
+					changes = append(changes, *change)
+				}
+			} else {
+				changes = append(changes, *detectPriceChange(nil, current, prevSnapshotID))
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+// Выполняет один INSERT ... ON CONFLICT для пачки URL и возвращает соответствие url -> id
+func execProductUpsert(tx *gorm.DB, products []Product, positions map[string][]int, batch []string, now time.Time) (map[string]string, error) {
+	placeholder := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(productUpsertColumns)), ", ") + ")"
+	rows := make([]string, 0, len(batch))
+	args := make([]interface{}, 0, len(batch)*len(productUpsertColumns))
+	for _, url := range batch {
+		idx := positions[url]
+		rows = append(rows, placeholder)
+		args = append(args, productUpsertValues(&products[idx[len(idx)-1]], now)...)
+	}
+
+	updates := make([]string, 0, len(productUpsertColumns))
+	for _, col := range productUpsertColumns {
+		if col == "url" || col == "created_at" {
+			continue
+		}
+		updates = append(updates, fmt.Sprintf("%q = EXCLUDED.%q", col, col))
+	}
+
+	quoted := make([]string, 0, len(productUpsertColumns))
+	for _, col := range productUpsertColumns {
+		quoted = append(quoted, fmt.Sprintf("%q", col))
+	}
+
+	sql := fmt.Sprintf(
+		"INSERT INTO products (%s) VALUES %s ON CONFLICT (url) DO UPDATE SET %s RETURNING id, url",
+		strings.Join(quoted, ", "),
+		strings.Join(rows, ", "),
+		strings.Join(updates, ", "),
+	)
+
+	var returned []struct {
+		ID  string
+		URL string
+	}
+	if err := tx.Raw(sql, args...).Scan(&returned).Error; err != nil {
+		return nil, fmt.Errorf("ошибка сохранения продуктов: %w", err)
+	}
+
+	ids := make(map[string]string, len(returned))
+	for _, r := range returned {
+		ids[r.URL] = r.ID
+	}
+	return ids, nil
+}
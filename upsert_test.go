@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Бенчмарк требует локальный Postgres: выигрыш upsert - в числе обращений к серверу, а SQLite
+// выполняет запросы в процессе, и сравнение на нем ничего не показывает.
+//
+//	TEST_DATABASE_DSN="host=localhost user=postgres password=postgres dbname=simple_api_test sslmode=disable" \
+//		go test -run '^$' -bench SaveProducts -benchtime 20x
+func openBenchmarkDB(b *testing.B) *gorm.DB {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		b.Skip("TEST_DATABASE_DSN не задан")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger:         logger.Default.LogMode(logger.Silent),
+		PrepareStmt:    true,
+		TranslateError: true,
+	})
+	if err != nil {
+		b.Fatalf("не удалось подключиться к базе данных: %v", err)
+	}
+	if _, err := migrateUp(db); err != nil {
+		b.Fatalf("ошибка миграции: %v", err)
+	}
+	return db
+}
+
+func benchmarkPageData(prefix string, n int, iteration int) *PageData {
+	pageData := &PageData{
+		URL:       prefix + "/category",
+		PageTitle: "Benchmark",
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+	for i := 0; i < n; i++ {
+		pageData.Products = append(pageData.Products, Product{
+			Name:      fmt.Sprintf("Товар %d", i),
+			URL:       fmt.Sprintf("%s/product/%d", prefix, i),
+			PageURL:   pageData.URL,
+			Price:     float64(100 + (i+iteration)%50),
+			Source:    "benchmark",
+			Timestamp: time.Now(),
+		})
+	}
+	return pageData
+}
+
+// Прежний путь записи: SELECT и затем INSERT или UPDATE на каждый продукт
+func savePageDataPerRow(tx *gorm.DB, pageData *PageData) error {
+	if err := tx.Omit("Products").Create(pageData).Error; err != nil {
+		return err
+	}
+	for i := range pageData.Products {
+		pageData.Products[i].PageDataID = &pageData.ID
+
+		var existingProduct Product
+		err := tx.Where("url = ?", pageData.Products[i].URL).First(&existingProduct).Error
+		if err == nil {
+			pageData.Products[i].ID = existingProduct.ID
+			if err := tx.Save(&pageData.Products[i]).Error; err != nil {
+				return err
+			}
+		} else if err == gorm.ErrRecordNotFound {
+			if err := tx.Create(&pageData.Products[i]).Error; err != nil {
+				return err
+			}
+		} else {
+			return err
+		}
+	}
+	return nil
+}
+
+func upsertProductsOnly(tx *gorm.DB, pageData *PageData) error {
+	if err := tx.Omit("Products").Create(pageData).Error; err != nil {
+		return err
+	}
+	_, err := upsertProducts(tx, pageData, nil)
+	return err
+}
+
+func benchmarkSaveProducts(b *testing.B, save func(tx *gorm.DB, pageData *PageData) error) {
+	db := openBenchmarkDB(b)
+	prefix := fmt.Sprintf("https://bench.local/%d", time.Now().UnixNano())
+	b.Cleanup(func() {
+		db.Exec("DELETE FROM products WHERE url LIKE ?", prefix+"%")
+		db.Exec("DELETE FROM page_data WHERE url LIKE ?", prefix+"%")
+	})
+
+	// Первая запись создает продукты, дальше измеряются обновления, как при повторном парсинге
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		return save(tx, benchmarkPageData(prefix, 500, 0))
+	}); err != nil {
+		b.Fatalf("ошибка подготовки данных: %v", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pageData := benchmarkPageData(prefix, 500, i+1)
+		tx := db.Begin()
+		if err := save(tx, pageData); err != nil {
+			tx.Rollback()
+			b.Fatalf("ошибка сохранения: %v", err)
+		}
+		tx.Rollback()
+	}
+}
+
+func BenchmarkSaveProductsPerRow(b *testing.B) {
+	benchmarkSaveProducts(b, savePageDataPerRow)
+}
+
+func BenchmarkSaveProductsUpsert(b *testing.B) {
+	benchmarkSaveProducts(b, upsertProductsOnly)
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// Максимум для колонок decimal(10,2)
+	maxDecimal10_2 = 99999999.99
+	// Больше ошибок в ответ не выводим - обычно дальше все то же самое
+	maxValidationErrors = 100
+	// Допустимое расхождение скидки с рассчитанной по цене и старой цене
+	discountTolerance = 1.0
+)
+
+// FieldError - ошибка в конкретном поле запроса; Pointer - JSON Pointer (RFC 6901)
+type FieldError struct {
+	Pointer string      `json:"pointer"`
+	Message string      `json:"message"`
+	Value   interface{} `json:"value,omitempty"`
+}
+
+type ValidationErrorResponse struct {
+	Success bool         `json:"success"`
+	Error   string       `json:"error"`
+	Errors  []FieldError `json:"errors"`
+}
+
+// validator накапливает ошибки полей
+type validator struct {
+	errors []FieldError
+}
+
+func (v *validator) add(pointer, message string, value interface{}) {
+	if len(v.errors) < maxValidationErrors {
+		v.errors = append(v.errors, FieldError{Pointer: pointer, Message: message, Value: value})
+	}
+}
+
+func (v *validator) maxLength(pointer, value string, max int) {
+	if n := utf8.RuneCountInString(value); n > max {
+		v.add(pointer, fmt.Sprintf("must be at most %d characters, got %d", max, n), value)
+	}
+}
+
+// Абсолютный http(s) URL
+func (v *validator) httpURL(pointer, value string, required bool) {
+	if value == "" {
+		if required {
+			v.add(pointer, "is required", nil)
+		}
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.add(pointer, "must be an absolute http(s) URL", value)
+	}
+}
+
+// Сумма, помещающаяся в decimal(10,2)
+func (v *validator) amount(pointer string, value float64, positive bool) {
+	switch {
+	case math.IsNaN(value) || math.IsInf(value, 0):
+		v.add(pointer, "must be a finite number", nil)
+	case positive && value <= 0:
+		v.add(pointer, "must be positive", value)
+	case value < 0:
+		v.add(pointer, "must not be negative", value)
+	case value > maxDecimal10_2:
+		v.add(pointer, fmt.Sprintf("must not exceed %.2f", maxDecimal10_2), value)
+	}
+}
+
+// Проверяет PageData и возвращает ошибки по полям; пустой результат - данные корректны
+func validatePageData(pageData *PageData) []FieldError {
+	v := &validator{}
+
+	if pageData.URL == "" {
+		v.add("/url", "URL is required", nil)
+	} else {
+		v.httpURL("/url", pageData.URL, true)
+	}
+
+	if len(pageData.Products) == 0 {
+		v.add("/products", "At least one product is required", nil)
+	}
+
+	for i := range pageData.Products {
+		validateProduct(v, "/products/"+strconv.Itoa(i), &pageData.Products[i])
+	}
+
+	return v.errors
+}
+
+func validateProduct(v *validator, pointer string, p *Product) {
+	v.maxLength(pointer+"/name", p.Name, 255)
+	v.maxLength(pointer+"/source", p.Source, 100)
+	v.maxLength(pointer+"/unit", p.Unit, 50)
+	v.maxLength(pointer+"/gtin", p.GTIN, 14)
+	v.maxLength(pointer+"/sku", p.SKU, 100)
+
+	// Продукты сопоставляются по url, поэтому он обязателен
+	v.httpURL(pointer+"/url", p.URL, true)
+	v.httpURL(pointer+"/pageUrl", p.PageURL, false)
+
+	v.amount(pointer+"/price", p.Price, true)
+	if p.OldPrice != nil {
+		v.amount(pointer+"/oldPrice", *p.OldPrice, true)
+	}
+	if p.Weight != nil {
+		v.amount(pointer+"/weight", *p.Weight, true)
+	}
+
+	if p.Discount != nil {
+		validateDiscount(v, pointer, p)
+	}
+}
+
+// Скидка может быть задана в процентах или в рублях; со старой ценой должна сходиться хотя бы одна форма
+func validateDiscount(v *validator, pointer string, p *Product) {
+	discount := *p.Discount
+	v.amount(pointer+"/discount", discount, false)
+	if discount <= 0 || p.OldPrice == nil || *p.OldPrice <= 0 || p.Price <= 0 {
+		return
+	}
+
+	oldPrice := *p.OldPrice
+	if oldPrice < p.Price {
+		v.add(pointer+"/oldPrice", "must not be less than price when discount is set", oldPrice)
+		return
+	}
+
+	absolute := oldPrice - p.Price
+	percent := absolute / oldPrice * 100
+	if math.Abs(discount-absolute) > discountTolerance && math.Abs(discount-percent) > discountTolerance {
+		v.add(pointer+"/discount",
+			fmt.Sprintf("is inconsistent with price and oldPrice: expected %.2f%% or %.2f", percent, absolute),
+			discount)
+	}
+}
+
+// Описывает ошибку декодирования JSON как ошибку поля
+func decodeError(err error) FieldError {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return FieldError{
+			Pointer: "",
+			Message: fmt.Sprintf("Invalid JSON format at offset %d: %v", syntaxErr.Offset, syntaxErr),
+		}
+	case errors.As(err, &typeErr):
+		pointer := ""
+		if typeErr.Field != "" {
+			pointer = "/" + strings.ReplaceAll(typeErr.Field, ".", "/")
+		}
+		return FieldError{
+			Pointer: pointer,
+			Message: fmt.Sprintf("must be %s, got %s", jsonKind(typeErr.Type), typeErr.Value),
+		}
+	}
+	return FieldError{Pointer: "", Message: "Invalid JSON format: " + err.Error()}
+}
+
+// Название типа JSON для Go-типа поля
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsonKind(t.Elem())
+	case reflect.Float32, reflect.Float64, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "number"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	}
+	return "string"
+}
+
+// Сводка ошибок одной строкой - для bulk и логов
+func summarizeErrors(errs []FieldError) string {
+	parts := make([]string, 0, len(errs))
+	for _, e := range errs {
+		if e.Pointer == "" {
+			parts = append(parts, e.Message)
+		} else {
+			parts = append(parts, e.Pointer+": "+e.Message)
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+func (app *Application) respondWithValidationErrors(w http.ResponseWriter, errs []FieldError) {
+	app.respondWithJSON(w, http.StatusBadRequest, ValidationErrorResponse{
+		Success: false,
+		Error:   "Validation failed",
+		Errors:  errs,
+	})
+}
+
+// Общий разбор тела для сохранения и проверки: Content-Type, JSON и проверки полей.
+// ok == false - ответ уже отправлен; pageData == nil - тело не разобралось, причина в errs
+func (app *Application) decodePageDataRequest(w http.ResponseWriter, r *http.Request) (pageData *PageData, errs []FieldError, ok bool) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		app.respondWithError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+		return nil, nil, false
+	}
+
+	pageData = &PageData{}
+	if err := json.NewDecoder(r.Body).Decode(pageData); err != nil {
+		return nil, []FieldError{decodeError(err)}, true
+	}
+	return pageData, validatePageData(pageData), true
+}
+
+// Предупреждения по нормализованным данным: сохранению не мешают, но скорее всего означают ошибку парсера
+func pageDataWarnings(pageData *PageData) []FieldError {
+	v := &validator{}
+
+	firstByURL := make(map[string]int, len(pageData.Products))
+	for i := range pageData.Products {
+		p := &pageData.Products[i]
+		pointer := "/products/" + strconv.Itoa(i)
+
+		if strings.TrimSpace(p.Name) == "" {
+			v.add(pointer+"/name", "is empty", nil)
+		}
+		if strings.TrimSpace(p.Source) == "" {
+			v.add(pointer+"/source", "is empty", nil)
+		}
+		if p.Discount != nil && *p.Discount > 0 && p.OldPrice == nil {
+			v.add(pointer+"/discount", "is set without oldPrice and cannot be checked", *p.Discount)
+		}
+		if first, ok := firstByURL[p.URL]; ok && p.URL != "" {
+			v.add(pointer+"/url", fmt.Sprintf("duplicates /products/%d/url; only the last occurrence is stored", first), p.URL)
+		} else {
+			firstByURL[p.URL] = i
+		}
+
+		if p.WeightSource == SizeInferred {
+			v.add(pointer+"/weight", "inferred from name", *p.Weight)
+		}
+		if p.UnitSource == SizeInferred {
+			v.add(pointer+"/unit", "inferred from name", p.Unit)
+		}
+		if p.Weight != nil && p.UnitPrice == nil {
+			v.add(pointer+"/unit", "is not recognized; unit price is not computed", p.Unit)
+		}
+	}
+
+	if pageData.StatsDivergent {
+		computed := statsFieldValues(pageData.Stats)
+		reported := reportedFieldValues(*pageData.ReportedStats)
+		for _, name := range strings.Split(pageData.StatsDivergence, ",") {
+			v.add("/stats/"+name, fmt.Sprintf("differs from server-computed value %g", computed[name]), reported[name])
+		}
+	}
+
+	return v.errors
+}
+
+// Проверяет формат UUID (8-4-4-4-12 шестнадцатеричных цифр): в Postgres сравнение
+// колонки uuid с произвольной строкой - ошибка запроса, а не пустой результат
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+				return false
+			}
+		}
+	}
+	return true
+}